@@ -0,0 +1,50 @@
+package v1
+
+import (
+	"ethereum-data-service/internal/config"
+	"ethereum-data-service/pkg/enum"
+	"fmt"
+	"net/http"
+
+	eth_err "ethereum-data-service/pkg/err"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+)
+
+// requireDetail responds with 501 Not Implemented and returns false when what a request asks for, described by
+// what, is not ingested at the `INGEST_DETAIL` level.
+func requireDetail(c *gin.Context, cfg *config.Config, level enum.DetailLevel, what string) bool {
+	if cfg.INGEST_DETAIL.Includes(level) {
+		return true
+	}
+
+	notIngested(c, cfg, fmt.Sprintf("%s are not ingested at the configured detail level, %s or above is needed", what, level))
+	return false
+}
+
+// requireAllowlisted responds with 501 Not Implemented and returns false when one of the addresses or event
+// signatures a request filters events on is left out by the `INGEST_DETAIL` allowlists, since its events
+// would silently be missing from the response.
+func requireAllowlisted(c *gin.Context, cfg *config.Config, addresses, signatures []string) bool {
+	for _, address := range addresses {
+		if !cfg.INGEST_DETAIL.AllowsAddress(common.HexToAddress(address)) {
+			notIngested(c, cfg, fmt.Sprintf("events of %s are not ingested, it is not in the INGEST_ADDRESSES allowlist", address))
+			return false
+		}
+	}
+
+	for _, signature := range signatures {
+		if !cfg.INGEST_DETAIL.AllowsTopic(common.HexToHash(signature)) {
+			notIngested(c, cfg, fmt.Sprintf("events with signature %s are not ingested, it is not in the INGEST_TOPICS allowlist", signature))
+			return false
+		}
+	}
+
+	return true
+}
+
+// notIngested responds with 501 Not Implemented, reporting the configured detail.
+func notIngested(c *gin.Context, cfg *config.Config, details string) {
+	c.JSON(http.StatusNotImplemented, gin.H{"error": eth_err.ErrNotIngested.Error(), "details": details, "ingest_detail": cfg.INGEST_DETAIL.String()})
+}
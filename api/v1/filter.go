@@ -0,0 +1,210 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"ethereum-data-service/internal/config"
+	"ethereum-data-service/internal/storage"
+	"ethereum-data-service/pkg/enum"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	eth_err "ethereum-data-service/pkg/err"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+)
+
+// logFilterRequest is an `eth_getLogs` filter object, as posted to /logs.
+type logFilterRequest struct {
+	FromBlock string       `json:"fromBlock"`
+	ToBlock   string       `json:"toBlock"`
+	BlockHash string       `json:"blockHash"`
+	Address   stringList   `json:"address"`
+	Topics    []stringList `json:"topics"`
+}
+
+// stringList is a JSON value which is either null, a single string or a list of strings, as the address and
+// the topics of an `eth_getLogs` filter are.
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*l = nil
+		return nil
+	}
+
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		*l = stringList{value}
+		return nil
+	}
+
+	var values []string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("expected null, a string or a list of strings: %v", err)
+	}
+	*l = values
+	return nil
+}
+
+// getLogs handles the /logs endpoint, retrieving the events matching an `eth_getLogs` filter from storage, ordered
+// by block number and log index. The filter is either posted as JSON, or given as the `fromBlock`, `toBlock`,
+// `blockHash`, `address` (comma-separated, or repeated) and `topic0` to `topic3` (comma-separated OR-sets, empty
+// for any topic) query parameters. Either way, the `cursor` and `limit` query parameters select a page of the events.
+func getLogs(store storage.Store, node HeadReader, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, err := parsePage(c, cfg)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page", "details": err.Error()})
+			return
+		}
+
+		var request logFilterRequest
+		if c.Request.Method == http.MethodPost {
+			if err := c.ShouldBindJSON(&request); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid filter", "details": err.Error()})
+				return
+			}
+		} else {
+			request = logFilterQuery(c)
+		}
+
+		filter, err := newLogFilter(c, store, node, cfg, &request)
+		if errors.Is(err, eth_err.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "block not found", "details": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid filter", "details": err.Error()})
+			return
+		}
+
+		var signatures []string
+		if len(filter.Topics) > 0 {
+			signatures = filter.Topics[0]
+		}
+		if !requireDetail(c, cfg, enum.FULL, "events") || !requireAllowlisted(c, cfg, filter.Addresses, signatures) {
+			return
+		}
+
+		events, err := store.GetLogs(c, filter, peek(page))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get logs from storage", "details": err.Error()})
+			return
+		}
+
+		events = events[:paginate(c, page, len(events), func(i int) storage.Cursor { return eventCursor(events[i]) })]
+		c.JSON(http.StatusOK, events)
+	}
+}
+
+// logFilterQuery reads a filter from the query parameters.
+func logFilterQuery(c *gin.Context) logFilterRequest {
+	request := logFilterRequest{
+		FromBlock: c.Query("fromBlock"),
+		ToBlock:   c.Query("toBlock"),
+		BlockHash: c.Query("blockHash"),
+	}
+
+	for _, address := range c.QueryArray("address") {
+		request.Address = append(request.Address, splitList(address)...)
+	}
+
+	// Topic positions up to the last one given, the ones left out matching any topic
+	for position := 0; position < storage.MAX_TOPICS; position++ {
+		if topics, ok := c.GetQuery(fmt.Sprint("topic", position)); ok {
+			for len(request.Topics) < position {
+				request.Topics = append(request.Topics, nil)
+			}
+			request.Topics = append(request.Topics, splitList(topics))
+		}
+	}
+
+	return request
+}
+
+// newLogFilter validates a filter request and resolves its block range against storage. As with `eth_getLogs`,
+// the range defaults to the latest block, and a block hash selects that single block instead of a range.
+func newLogFilter(ctx context.Context, store storage.Store, node HeadReader, cfg *config.Config, request *logFilterRequest) (*storage.LogFilter, error) {
+	filter := &storage.LogFilter{}
+
+	if request.BlockHash != "" {
+		if request.FromBlock != "" || request.ToBlock != "" {
+			return nil, errors.New("blockHash cannot be combined with fromBlock or toBlock")
+		}
+
+		block, err := store.GetBlockByHash(ctx, request.BlockHash)
+		if err != nil {
+			return nil, err
+		}
+		filter.FromBlock = block.Header.Number.Uint64()
+		filter.ToBlock = filter.FromBlock
+	} else {
+		var err error
+		if filter.FromBlock, err = parseBlockParam(ctx, store, node, cfg, request.FromBlock); err != nil {
+			return nil, err
+		}
+		if filter.ToBlock, err = parseBlockParam(ctx, store, node, cfg, request.ToBlock); err != nil {
+			return nil, err
+		}
+		if filter.FromBlock > filter.ToBlock {
+			return nil, fmt.Errorf("fromBlock %d is after toBlock %d", filter.FromBlock, filter.ToBlock)
+		}
+	}
+
+	for _, address := range request.Address {
+		if !common.IsHexAddress(address) {
+			return nil, fmt.Errorf("invalid address %q", address)
+		}
+		filter.Addresses = append(filter.Addresses, strings.ToLower(common.HexToAddress(address).Hex()))
+	}
+
+	if len(request.Topics) > storage.MAX_TOPICS {
+		return nil, fmt.Errorf("at most %d topic positions can be filtered", storage.MAX_TOPICS)
+	}
+	for _, topics := range request.Topics {
+		var set []string
+		for _, topic := range topics {
+			if len(topic) != 2+2*common.HashLength || !strings.HasPrefix(topic, "0x") {
+				return nil, fmt.Errorf("invalid topic %q", topic)
+			}
+			set = append(set, strings.ToLower(topic))
+		}
+		filter.Topics = append(filter.Topics, set)
+	}
+
+	return filter, nil
+}
+
+// parseBlockParam returns the block number given in decimal, in hex (`0x`-prefixed) or as a block tag,
+// `latest` when empty.
+func parseBlockParam(ctx context.Context, store storage.Store, node HeadReader, cfg *config.Config, value string) (uint64, error) {
+	if value == "" {
+		value = "latest"
+	}
+
+	blockNumber, err := resolveBlockTag(ctx, store, node, cfg, value)
+	if err != nil {
+		return 0, err
+	}
+
+	if hex, ok := strings.CutPrefix(blockNumber, "0x"); ok {
+		return strconv.ParseUint(hex, 16, 64)
+	}
+	return strconv.ParseUint(blockNumber, 10, 64)
+}
+
+// splitList splits a comma-separated query parameter, an empty one being an empty list.
+func splitList(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
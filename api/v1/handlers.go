@@ -1,25 +1,40 @@
 package v1
 
 import (
+	"context"
+	"errors"
+	"ethereum-data-service/internal/config"
+	"ethereum-data-service/internal/model"
 	"ethereum-data-service/internal/storage"
+	"ethereum-data-service/pkg/enum"
+	"fmt"
+	"math/big"
 	"net/http"
+	"strconv"
 	"strings"
 
+	eth_err "ethereum-data-service/pkg/err"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/gin-gonic/gin"
-	"github.com/redis/go-redis/v9"
 )
 
 // setupHandlers registers all endpoint handlers.
-func setupHandlers(router *gin.Engine, rdb *redis.Client) {
+func setupHandlers(router *gin.Engine, store storage.Store, node HeadReader, cfg *config.Config) {
 
 	// Default home route
 	router.GET("/", listRoutes(router)) // VC-00
 
 	// Application specific
-	router.GET("/v1/blocks", getAllBlocks(rdb)) // VC-01
-	router.GET("/v1/events", getEvents(rdb))    // VC-02
-	router.GET("/v1/block", getBlock(rdb))      // VC-03
-	router.GET("/v1/tx", getTransaction(rdb))   // VC-04
+	router.GET("/v1/blocks", getAllBlocks(store, cfg))  // VC-01
+	router.GET("/v1/events", getEvents(store, cfg))     // VC-02
+	router.GET("/v1/block", getBlock(store, node, cfg)) // VC-03
+	router.GET("/v1/tx", getTransaction(store, cfg))    // VC-04
+	router.GET("/v1/stats", getStats(store))            // VC-05
+	router.GET("/v1/watermark", getWatermark(store))    // VC-06
+	router.GET("/v1/logs", getLogs(store, node, cfg))   // VC-07
+	router.POST("/v1/logs", getLogs(store, node, cfg))  // VC-07
 
 	// Handle favicon.ico request without logging
 	router.GET("/favicon.ico", handleFavicon)
@@ -43,51 +58,84 @@ func listRoutes(router *gin.Engine) gin.HandlerFunc {
 	}
 }
 
-// getEvents handles the /events endpoint, retrieving events related to a specific address from Redis.
-func getEvents(rdb *redis.Client) gin.HandlerFunc {
+// getEvents handles the /events endpoint, retrieving a page of the events related to a specific address from storage.
+func getEvents(store storage.Store, cfg *config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		address := c.Query("address")
 		if address == "" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "address query parameter is required"})
 			return
 		}
+		if !requireDetail(c, cfg, enum.FULL, "events") || !requireAllowlisted(c, cfg, []string{address}, nil) {
+			return
+		}
+
+		page, err := parsePage(c, cfg)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page", "details": err.Error()})
+			return
+		}
 
 		// We indexed address by first converting it to lower case to eliminate case sensitivity wrt. to address
-		events, err := storage.GetEventsByAddress(rdb, strings.ToLower(address))
+		events, err := store.GetEventsByAddress(c, strings.ToLower(address), peek(page))
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get events from Redis", "details": err.Error()})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get events from storage", "details": err.Error()})
 			return
 		}
 
+		events = events[:paginate(c, page, len(events), func(i int) storage.Cursor { return eventCursor(events[i]) })]
 		c.JSON(http.StatusOK, events)
 	}
 }
 
-// getAllBlocks handles the /blocks endpoint, retrieving all block numbers from Redis.
-func getAllBlocks(rdb *redis.Client) gin.HandlerFunc {
+// getAllBlocks handles the /blocks endpoint, retrieving a page of the block numbers from storage.
+func getAllBlocks(store storage.Store, cfg *config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		blocks, err := storage.GetAllBlockNumbers(rdb)
+		page, err := parsePage(c, cfg)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get all blocks from Redis", "details": err.Error()})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page", "details": err.Error()})
 			return
 		}
 
+		blocks, err := store.GetAllBlockNumbers(c, peek(page))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get all blocks from storage", "details": err.Error()})
+			return
+		}
+
+		blocks = blocks[:paginate(c, page, len(blocks), func(i int) storage.Cursor { return blockCursor(blocks[i]) })]
+
 		c.JSON(http.StatusOK, blocks)
 	}
 }
 
-// getBlock handles the /block endpoint, retrieving a block by its number from Redis.
-func getBlock(rdb *redis.Client) gin.HandlerFunc {
+// getBlock handles the /block endpoint, retrieving a block from storage by its number, by a block tag given
+// in place of the number (`latest`, `earliest`, `safe` or `finalized`), or by its hash.
+func getBlock(store storage.Store, node HeadReader, cfg *config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		blockNumber := c.Query("block_number")
-		if blockNumber == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "block_number query parameter is required"})
+		blockHash := c.Query("block_hash")
+		if (blockNumber == "") == (blockHash == "") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "exactly one of the block_number and block_hash query parameters is required"})
 			return
 		}
 
-		block, err := storage.GetBlockByNumber(rdb, blockNumber)
+		var block *model.Block
+		var err error
+		if blockHash != "" {
+			block, err = store.GetBlockByHash(c, blockHash)
+		} else {
+			blockNumber, err = resolveBlockTag(c, store, node, cfg, blockNumber)
+			if err == nil {
+				block, err = store.GetBlockByNumber(c, blockNumber)
+			}
+		}
+		if errors.Is(err, eth_err.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "block not found", "details": err.Error()})
+			return
+		}
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get block from Redis", "details": err.Error()})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get block from storage", "details": err.Error()})
 			return
 		}
 
@@ -95,21 +143,118 @@ func getBlock(rdb *redis.Client) gin.HandlerFunc {
 	}
 }
 
-// getTransaction handles the /transaction endpoint, retrieving a transaction by its hash from Redis.
-func getTransaction(rdb *redis.Client) gin.HandlerFunc {
+// HeadReader reads the headers of the node, which the `safe` and `finalized` block tags resolve against. An
+// *ethclient.Client implements it.
+type HeadReader interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+// resolveBlockTag returns the number of the block a tag refers to within the stored window: `latest` is the
+// highest stored block and `earliest` the lowest. `safe` and `finalized` are the safe and finalized heads of the
+// node, capped at `latest` since every stored block below them is safe or finalized too, and are not found when
+// the whole window lies above them. A node which does not report them (pre-merge chains, or no node at all)
+// falls back to `SAFE_BLOCK_DEPTH` and `FINALIZED_BLOCK_DEPTH` blocks below `latest`, clamped to the window.
+// Anything but a tag is returned as is.
+func resolveBlockTag(ctx context.Context, store storage.Store, node HeadReader, cfg *config.Config, blockNumber string) (string, error) {
+	tag := enum.BlockTag(strings.ToLower(blockNumber))
+	var nodeTag rpc.BlockNumber
+	var depth uint64
+	switch tag {
+	case enum.LATEST, enum.EARLIEST:
+	case enum.SAFE:
+		nodeTag, depth = rpc.SafeBlockNumber, uint64(cfg.SAFE_BLOCK_DEPTH)
+	case enum.FINALIZED:
+		nodeTag, depth = rpc.FinalizedBlockNumber, uint64(cfg.FINALIZED_BLOCK_DEPTH)
+	default:
+		return blockNumber, nil
+	}
+
+	earliest, err := store.EarliestBlock(ctx)
+	if err != nil {
+		return "", fmt.Errorf("block %s: %w", tag, err)
+	}
+	latest, err := store.LatestBlock(ctx)
+	if err != nil {
+		return "", fmt.Errorf("block %s: %w", tag, err)
+	}
+
+	switch tag {
+	case enum.LATEST:
+		return strconv.FormatUint(latest, 10), nil
+	case enum.EARLIEST:
+		return strconv.FormatUint(earliest, 10), nil
+	}
+
+	if node != nil {
+		if header, err := node.HeaderByNumber(ctx, big.NewInt(nodeTag.Int64())); err == nil {
+			head := min(header.Number.Uint64(), latest)
+			if head < earliest {
+				return "", fmt.Errorf("block %s (%d on the node) is below the stored window %d-%d: %w", tag, header.Number, earliest, latest, eth_err.ErrNotFound)
+			}
+			return strconv.FormatUint(head, 10), nil
+		}
+	}
+
+	if latest < earliest+depth {
+		return strconv.FormatUint(earliest, 10), nil
+	}
+	return strconv.FormatUint(latest-depth, 10), nil
+}
+
+// getTransaction handles the /transaction endpoint, retrieving a transaction by its hash from storage.
+func getTransaction(store storage.Store, cfg *config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		txHash := c.Query("tx_hash")
 		if txHash == "" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "tx_hash query parameter is required"})
 			return
 		}
+		if !requireDetail(c, cfg, enum.FULL, "transactions") {
+			return
+		}
 
-		tx, err := storage.GetTransactionByHash(rdb, txHash)
+		tx, err := store.GetTransactionByHash(c, txHash)
+		if errors.Is(err, eth_err.ErrNotFound) && cfg.INGEST_DETAIL.Filters() {
+			c.JSON(http.StatusNotFound, gin.H{"error": "transaction not found", "details": err.Error() + ", only the transactions passing the INGEST_ADDRESSES and INGEST_TOPICS allowlists are ingested"})
+			return
+		}
+		if errors.Is(err, eth_err.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "transaction not found", "details": err.Error()})
+			return
+		}
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get transaction from Redis", "details": err.Error()})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get transaction from storage", "details": err.Error()})
 			return
 		}
 
 		c.JSON(http.StatusOK, tx)
 	}
 }
+
+// getStats handles the /stats endpoint, retrieving the ingestion counters (blocks applied, duplicates skipped
+// and conflicting blocks replaced) from storage.
+func getStats(store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stats, err := store.GetStats(c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get stats from storage", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, stats)
+	}
+}
+
+// getWatermark handles the /watermark endpoint, reporting the highest block number up to which every block
+// is stored without a gap.
+func getWatermark(store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		watermark, err := store.GetWatermark(c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get watermark from storage", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"contiguous_up_to": watermark})
+	}
+}
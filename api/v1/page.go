@@ -0,0 +1,96 @@
+package v1
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"ethereum-data-service/internal/config"
+	"ethereum-data-service/internal/storage"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/gin-gonic/gin"
+)
+
+// parsePage reads the page a list request asks for from the `cursor` and `limit` query parameters. The limit
+// defaults to `PAGE_SIZE` and is capped at `MAX_PAGE_SIZE`.
+func parsePage(c *gin.Context, cfg *config.Config) (storage.Page, error) {
+	page := storage.Page{Limit: cfg.PAGE_SIZE}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return page, fmt.Errorf("invalid limit %q, expected a positive integer", value)
+		}
+		page.Limit = limit
+	}
+	if page.Limit > cfg.MAX_PAGE_SIZE {
+		page.Limit = cfg.MAX_PAGE_SIZE
+	}
+
+	if value := c.Query("cursor"); value != "" {
+		cursor, err := decodeCursor(value)
+		if err != nil {
+			return page, err
+		}
+		page.After = cursor
+	}
+
+	return page, nil
+}
+
+// peek returns the page grown by one item, which tells whether another page follows.
+func peek(page storage.Page) storage.Page {
+	page.Limit++
+	return page
+}
+
+// paginate takes the n items read for the peeked page, and returns how many of them the page holds. When
+// another page follows, it links to it in the `Link` header, with the cursor of the last item of the page.
+func paginate(c *gin.Context, page storage.Page, n int, cursorAt func(i int) storage.Cursor) int {
+	if n <= page.Limit {
+		return n
+	}
+
+	next := *c.Request.URL
+	query := next.Query()
+	query.Set("cursor", encodeCursor(cursorAt(page.Limit-1)))
+	query.Set("limit", strconv.Itoa(page.Limit))
+	next.RawQuery = query.Encode()
+
+	c.Header("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+	return page.Limit
+}
+
+// eventCursor returns the position of an event.
+func eventCursor(event *types.Log) storage.Cursor {
+	return storage.Cursor{BlockNumber: event.BlockNumber, LogIndex: event.Index}
+}
+
+// blockCursor returns the position of a block key (`block:<number>`).
+func blockCursor(blockKey string) storage.Cursor {
+	number, _ := strconv.ParseUint(strings.TrimPrefix(blockKey, storage.BLOCK_PREFIX), 10, 64)
+	return storage.Cursor{BlockNumber: number}
+}
+
+// encodeCursor returns the opaque form of a cursor, the URL-safe base64 of its block number and log index.
+func encodeCursor(cursor storage.Cursor) string {
+	buf := binary.BigEndian.AppendUint64(nil, cursor.BlockNumber)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(cursor.LogIndex))
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// decodeCursor parses a cursor returned by encodeCursor.
+func decodeCursor(value string) (*storage.Cursor, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(buf) != 16 {
+		return nil, errors.New("invalid cursor")
+	}
+
+	return &storage.Cursor{
+		BlockNumber: binary.BigEndian.Uint64(buf[:8]),
+		LogIndex:    uint(binary.BigEndian.Uint64(buf[8:])),
+	}, nil
+}
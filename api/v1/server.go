@@ -3,15 +3,33 @@ package v1
 import (
 	"context"
 	"ethereum-data-service/internal/config"
+	"ethereum-data-service/internal/storage"
 	"log"
+	"net"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
-	"github.com/redis/go-redis/v9"
 )
 
-// RunAPIServer: Initializes and runs the API server with graceful shutdown.
-func RunAPIServer(rdb *redis.Client, cfg *config.Config, shutdown <-chan struct{}) {
+// RunAPIServer: Initializes and runs the API server with graceful shutdown. The `safe` and `finalized` block tags
+// resolve against the heads of node.
+func RunAPIServer(store storage.Store, node HeadReader, cfg *config.Config, shutdown <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		<-shutdown
+		cancel()
+	}()
+
+	if err := Serve(ctx, store, node, cfg, func() {}); err != nil {
+		log.Fatalf("listen: %s\n", err)
+	}
+}
+
+// Serve runs the API server until ctx is cancelled and then shuts it down gracefully. It calls ready once the
+// server is listening, and returns an error if the server cannot listen or fails while serving.
+func Serve(ctx context.Context, store storage.Store, node HeadReader, cfg *config.Config, ready func()) error {
 
 	// Set Gin mode to release for production
 	gin.SetMode(gin.ReleaseMode)
@@ -23,32 +41,48 @@ func RunAPIServer(rdb *redis.Client, cfg *config.Config, shutdown <-chan struct{
 	router.Use(gin.Logger(), gin.Recovery())
 
 	// Define the endpoints and their handlers
-	setupHandlers(router, rdb)
+	setupHandlers(router, store, node, cfg)
 
 	srv := &http.Server{
 		Addr:    ":" + cfg.API_PORT,
 		Handler: router,
 	}
 
+	listener, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+
 	// Run server in a goroutine so it doesn't block
 	log.Printf("Listening on port:%s \n", cfg.API_PORT)
+	serveErr := make(chan error, 1)
 	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("listen: %s\n", err)
+		if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			serveErr <- err
 		}
+		close(serveErr)
 	}()
 
-	<-shutdown
+	ready()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
 	log.Println("Shutting down API server...")
 
 	// Create a deadline to wait for server shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), cfg.DEFAULT_TIMEOUT)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.DEFAULT_TIMEOUT)
 	defer cancel()
 
 	// Attempt a graceful server shutdown
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatalf("API Server forced to shutdown: %v", err)
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("API Server forced to shutdown: %v", err)
+		return nil
 	}
 
 	log.Println("API server exited gracefully")
+	return nil
 }
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"context"
+	"sync"
+
+	v1 "ethereum-data-service/api/v1"
+
+	"ethereum-data-service/internal/config"
+	"ethereum-data-service/internal/services/archiver"
+	"ethereum-data-service/internal/services/auditor"
+	"ethereum-data-service/internal/services/bootstrapper"
+	"ethereum-data-service/internal/services/pub"
+	"ethereum-data-service/internal/services/sub"
+	"ethereum-data-service/internal/services/supervisor"
+	"ethereum-data-service/internal/transport"
+	"ethereum-data-service/pkg/util"
+
+	"github.com/spf13/cobra"
+)
+
+var allCmd = &cobra.Command{
+	Use:     "all",
+	Aliases: []string{"run"},
+	Short:   "Start every service in a single supervised process",
+	Run: func(cmd *cobra.Command, args []string) {
+		requireSchema()
+
+		var wg sync.WaitGroup
+		shutdown := make(chan struct{})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runAllServices(shutdown)
+		}()
+		handleShutdown(&wg, shutdown)
+	},
+}
+
+// runAllServices supervises all services in this process, handing blocks from the notifier to the subscriber
+// through the in-memory transport. The services start in dependency order: the API server and the subscriber
+// first, then the notifier, and the bootstrapper only once the notifier is subscribed to new heads, so that
+// every block after the bootstrapped range is picked up by the notifier.
+func runAllServices(shutdown chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Handle OS signals for graceful shutdown
+	go util.HandleGracefulShutdown(cancel, shutdown)
+
+	tr := transport.NewMemory(cfg)
+	defer tr.Close()
+
+	supervisor.Run(ctx, allServices(cfg, tr))
+}
+
+func allServices(cfg *config.Config, tr transport.Transport) []supervisor.Service {
+	services := []supervisor.Service{
+		{
+			Name: "api-server",
+			Run: func(ctx context.Context, ready func()) error {
+				return v1.Serve(ctx, apiStore(), clientInstance.ETH_HTTPS, cfg, ready)
+			},
+		},
+		{
+			Name: "sub",
+			Run: func(ctx context.Context, ready func()) error {
+				return sub.Run(ctx, clientInstance, cfg, tr, ready)
+			},
+		},
+		{
+			Name: "pub",
+			Run: func(ctx context.Context, ready func()) error {
+				return pub.Run(ctx, clientInstance, cfg, tr, ready)
+			},
+		},
+		{
+			Name: "bootstrap",
+			Run: func(ctx context.Context, ready func()) error {
+				return bootstrapper.Bootstrap(ctx, clientInstance, cfg)
+			},
+		},
+	}
+
+	if cfg.ARCHIVE_DIR != "" {
+		services = append(services, supervisor.Service{
+			Name: "archive",
+			Run: func(ctx context.Context, ready func()) error {
+				return archiver.Run(ctx, clientInstance.STORE, cfg, ready)
+			},
+		})
+	}
+
+	if cfg.AUDIT_INTERVAL > 0 {
+		services = append(services, supervisor.Service{
+			Name: "audit",
+			Run: func(ctx context.Context, ready func()) error {
+				return auditor.Run(ctx, clientInstance, cfg, ready)
+			},
+		})
+	}
+
+	return services
+}
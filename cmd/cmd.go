@@ -10,11 +10,18 @@ import (
 
 	v1 "ethereum-data-service/api/v1"
 
+	"ethereum-data-service/internal/archive"
 	"ethereum-data-service/internal/client"
 	"ethereum-data-service/internal/config"
+	"ethereum-data-service/internal/services/archiver"
+	"ethereum-data-service/internal/services/auditor"
 	"ethereum-data-service/internal/services/bootstrapper"
 	"ethereum-data-service/internal/services/pub"
 	"ethereum-data-service/internal/services/sub"
+	"ethereum-data-service/internal/storage"
+	"ethereum-data-service/pkg/enum"
+
+	eth_err "ethereum-data-service/pkg/err"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
@@ -23,19 +30,37 @@ import (
 var (
 	clientInstance *client.Client
 	cfg            *config.Config
+
+	// storageBackend overrides `STORAGE_BACKEND` when set through the `--storage` flag.
+	storageBackend string
 )
 
-// Init initializes the configuration and clients.
-func Init() {
+// Init initializes the configuration and the clients the given command needs.
+func Init(cmd *cobra.Command) {
 	var err error
 	// Load configuration
 	cfg, err = config.LoadConfig()
 	if err != nil {
 		log.Fatalf("failed to load configuration: %v", err)
 	}
+	if storageBackend != "" {
+		cfg.STORAGE_BACKEND = storageBackend
+	}
+	if (cmd == pubCmd || cmd == subCmd) && enum.Transport(cfg.TRANSPORT) == enum.MEMORY {
+		log.Fatalf("failed to load configuration: %v", eth_err.ErrMemoryTransport)
+	}
+	// Blocks stored in memory are neither shared with another process nor kept once this one exits
+	if cmd != allCmd && enum.StorageBackend(cfg.STORAGE_BACKEND) == enum.IN_MEMORY {
+		log.Fatalf("failed to load configuration: %v", eth_err.ErrMemoryStorage)
+	}
+	// `all` hands blocks from the notifier to the subscriber in-process, and the commands other than `pub` and
+	// `sub` neither publish nor consume blocks, so none of them needs a Redis transport
+	if cmd != pubCmd && cmd != subCmd {
+		cfg.TRANSPORT = string(enum.MEMORY)
+	}
 
 	// Initialize clients
-	clientInstance, err = client.InitClient()
+	clientInstance, err = client.InitClient(cfg)
 	if err != nil {
 		log.Fatalf("failed to initialize clients: %v", err)
 	}
@@ -51,22 +76,41 @@ var RootCmd = &cobra.Command{
 		color.HiCyan("To start the BlockSubscription service: `go run main.go sub`")
 		color.HiCyan("To start the BlockNotification service `go run main.go pub`")
 		color.HiCyan("To start the HTTP API server: `go run main.go api-server`")
+		color.HiCyan("To archive confirmed blocks to ARCHIVE_DIR: `go run main.go archive`")
+		color.HiCyan("To verify the stored blocks against their headers every AUDIT_INTERVAL: `go run main.go audit`")
+		color.HiCyan("To start every service in a single process: `go run main.go all`")
+		color.HiCyan("To list, inspect, replay or purge dead-lettered blocks: `go run main.go dlq [list|inspect|replay|purge]`")
+		color.HiCyan("To upgrade the stored data to the storage schema of this build: `go run main.go migrate [--dry-run] [--rebuild]`")
+		color.HiCyan("To check the stored blocks against their headers once: `go run main.go verify [--from N] [--to N] [--receipts] [--refetch]`")
+		color.HiCyan("To export the stored window to a file, or import one into an empty store: `go run main.go snapshot [export|import] <file>`")
 	},
 }
 
 func init() {
-	cobra.OnInitialize(Init)
+	RootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		Init(cmd)
+	}
+	RootCmd.PersistentFlags().StringVar(&storageBackend, "storage", "", "storage backend overriding STORAGE_BACKEND: redis, postgres, bolt or memory")
 
 	RootCmd.AddCommand(bootstrapCmd)
 	RootCmd.AddCommand(pubCmd)
 	RootCmd.AddCommand(subCmd)
 	RootCmd.AddCommand(apiServerCmd)
+	RootCmd.AddCommand(archiveCmd)
+	RootCmd.AddCommand(auditCmd)
+	RootCmd.AddCommand(allCmd)
+	RootCmd.AddCommand(dlqCmd)
+	RootCmd.AddCommand(migrateCmd)
+	RootCmd.AddCommand(verifyCmd)
+	RootCmd.AddCommand(snapshotCmd)
 }
 
 var bootstrapCmd = &cobra.Command{
 	Use:   "bootstrap",
 	Short: "Start BlockBootstrap service",
 	Run: func(cmd *cobra.Command, args []string) {
+		requireSchema()
+
 		var wg sync.WaitGroup
 		shutdown := make(chan struct{})
 		wg.Add(1)
@@ -97,12 +141,14 @@ var subCmd = &cobra.Command{
 	Use:   "sub",
 	Short: "Start BlockSubscriber service",
 	Run: func(cmd *cobra.Command, args []string) {
+		requireSchema()
+
 		var wg sync.WaitGroup
 		shutdown := make(chan struct{})
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			sub.RunBlockSubscriberSvc(clientInstance.REDIS, cfg, shutdown)
+			sub.RunBlockSubscriberSvc(clientInstance, cfg, shutdown)
 		}()
 		handleShutdown(&wg, shutdown)
 	},
@@ -112,17 +158,68 @@ var apiServerCmd = &cobra.Command{
 	Use:   "api-server",
 	Short: "Start HTTP-API server",
 	Run: func(cmd *cobra.Command, args []string) {
+		requireSchema()
+
+		var wg sync.WaitGroup
+		shutdown := make(chan struct{})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v1.RunAPIServer(apiStore(), clientInstance.ETH_HTTPS, cfg, shutdown)
+		}()
+		handleShutdown(&wg, shutdown)
+	},
+}
+
+var archiveCmd = &cobra.Command{
+	Use:   "archive",
+	Short: "Start BlockArchiver service",
+	Run: func(cmd *cobra.Command, args []string) {
+		if cfg.ARCHIVE_DIR == "" {
+			log.Fatalf("ARCHIVE_DIR must be set to archive blocks")
+		}
+		requireSchema()
+
 		var wg sync.WaitGroup
 		shutdown := make(chan struct{})
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			v1.RunAPIServer(clientInstance.REDIS, cfg, shutdown)
+			archiver.RunBlockArchiverSvc(clientInstance, cfg, shutdown)
 		}()
 		handleShutdown(&wg, shutdown)
 	},
 }
 
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Start BlockAuditor service",
+	Run: func(cmd *cobra.Command, args []string) {
+		if cfg.AUDIT_INTERVAL <= 0 {
+			log.Fatalf("AUDIT_INTERVAL must be set to audit blocks")
+		}
+		requireSchema()
+
+		var wg sync.WaitGroup
+		shutdown := make(chan struct{})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			auditor.RunBlockAuditorSvc(clientInstance, cfg, shutdown)
+		}()
+		handleShutdown(&wg, shutdown)
+	},
+}
+
+// apiStore returns the store the API serves, falling back to the archive for evicted blocks when `ARCHIVE_DIR` is set.
+func apiStore() storage.Store {
+	if cfg.ARCHIVE_DIR == "" {
+		return clientInstance.STORE
+	}
+
+	return archive.NewFallbackStore(clientInstance.STORE, archive.NewReader(cfg.ARCHIVE_DIR))
+}
+
 func handleShutdown(wg *sync.WaitGroup, shutdown chan struct{}) {
 	// Setup a signal handler to capture interrupt and termination signals
 	done := make(chan os.Signal, 1)
@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"ethereum-data-service/internal/dlq"
+	"ethereum-data-service/internal/storage"
+
+	"github.com/spf13/cobra"
+)
+
+var dlqReplayAll bool
+
+var dlqCmd = &cobra.Command{
+	Use:   "dlq",
+	Short: "Inspect and manage dead-lettered blocks",
+}
+
+var dlqListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all dead-lettered blocks",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		entries, err := dlq.List(context.Background(), openDLQ())
+		if err != nil {
+			log.Fatalf("failed to list dead-letter entries: %v", err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSOURCE\tBLOCK\tATTEMPTS\tTIME\tERROR")
+		for _, entry := range entries {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\n", entry.ID, entry.Source, entry.BlockNumber, entry.Attempts,
+				time.UnixMilli(entry.Timestamp).Format(time.RFC3339), entry.Error)
+		}
+		w.Flush()
+	},
+}
+
+var dlqInspectCmd = &cobra.Command{
+	Use:   "inspect <id>",
+	Short: "Print a dead-lettered block, including its payload",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		entry, err := dlq.Get(context.Background(), openDLQ(), args[0])
+		if err != nil {
+			log.Fatalf("failed to get dead-letter entry %s: %v", args[0], err)
+		}
+
+		entryJSON, err := json.MarshalIndent(entry, "", "  ")
+		if err != nil {
+			log.Fatalf("failed to marshal dead-letter entry %s: %v", args[0], err)
+		}
+		fmt.Println(string(entryJSON))
+	},
+}
+
+var dlqReplayCmd = &cobra.Command{
+	Use:   "replay [<id>...]",
+	Short: "Reprocess dead-lettered blocks and remove the ones which succeed",
+	Run: func(cmd *cobra.Command, args []string) {
+		requireSchema()
+
+		ctx := context.Background()
+		dl := openDLQ()
+
+		var entries []*dlq.Entry
+		switch {
+		case dlqReplayAll:
+			all, err := dlq.List(ctx, dl)
+			if err != nil {
+				log.Fatalf("failed to list dead-letter entries: %v", err)
+			}
+			entries = all
+		case len(args) > 0:
+			for _, id := range args {
+				entry, err := dlq.Get(ctx, dl, id)
+				if err != nil {
+					log.Fatalf("failed to get dead-letter entry %s: %v", id, err)
+				}
+				entries = append(entries, entry)
+			}
+		default:
+			log.Fatal("specify the entry IDs to replay or --all")
+		}
+
+		failed := 0
+		for _, entry := range entries {
+			if err := dlq.Replay(ctx, clientInstance, dl, cfg, entry); err != nil {
+				log.Printf("Replay of entry %s (block %d) failed: %v", entry.ID, entry.BlockNumber, err)
+				failed++
+				continue
+			}
+			log.Printf("Replayed entry %s (block %d)", entry.ID, entry.BlockNumber)
+		}
+
+		log.Printf("Replayed %d of %d entries", len(entries)-failed, len(entries))
+		if failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+var dlqPurgeCmd = &cobra.Command{
+	Use:   "purge [<id>...]",
+	Short: "Delete the given dead-lettered blocks, or all of them",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		dl := openDLQ()
+
+		if len(args) == 0 {
+			n, err := dlq.Purge(ctx, dl)
+			if err != nil {
+				log.Fatalf("failed to purge dead-letter entries: %v", err)
+			}
+			log.Printf("Purged %d entries", n)
+			return
+		}
+
+		for _, id := range args {
+			if err := dlq.Remove(ctx, dl, id); err != nil {
+				log.Fatalf("failed to remove dead-letter entry %s: %v", id, err)
+			}
+			log.Printf("Removed entry %s", id)
+		}
+	},
+}
+
+// openDLQ returns the dead-letter store of the configured deployment, exiting when there is none.
+func openDLQ() storage.DeadLetters {
+	dl, err := dlq.Open(clientInstance)
+	if err != nil {
+		log.Fatalf("failed to open the dead-letter store: %v", err)
+	}
+	return dl
+}
+
+func init() {
+	dlqReplayCmd.Flags().BoolVar(&dlqReplayAll, "all", false, "replay every dead-lettered block")
+
+	dlqCmd.AddCommand(dlqListCmd)
+	dlqCmd.AddCommand(dlqInspectCmd)
+	dlqCmd.AddCommand(dlqReplayCmd)
+	dlqCmd.AddCommand(dlqPurgeCmd)
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"context"
+	"log"
+
+	"ethereum-data-service/internal/storage"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	migrateDryRun  bool
+	migrateRebuild bool
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade the stored data to the storage schema version of this build",
+	Long: `Upgrade the stored data to the storage schema version of this build, applying the pending migrations
+to every stored block. --rebuild rewrites every block from its values with the current layout and STORAGE_CODEC
+instead. Stop the other services first. An interrupted migration is resumed by running it again.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		versioned, ok := clientInstance.STORE.(storage.Versioned)
+		if !ok {
+			log.Fatalf("the %s storage backend has no schema version to migrate", cfg.STORAGE_BACKEND)
+		}
+
+		result, err := versioned.Migrate(context.Background(), storage.MigrateOptions{DryRun: migrateDryRun, Rebuild: migrateRebuild})
+		if err != nil {
+			log.Fatalf("failed to migrate storage schema: %v", err)
+		}
+
+		if migrateDryRun {
+			log.Printf("Dry run: migrating from schema version %d to %d would write %d keys and index entries of %d blocks\n", result.From, result.To, result.Writes, result.Blocks)
+			return
+		}
+		log.Printf("Migrated from schema version %d to %d: wrote %d keys and index entries of %d blocks\n", result.From, result.To, result.Writes, result.Blocks)
+	},
+}
+
+func init() {
+	migrateCmd.Flags().BoolVar(&migrateDryRun, "dry-run", false, "report what would be migrated without writing anything")
+	migrateCmd.Flags().BoolVar(&migrateRebuild, "rebuild", false, "rewrite every stored block instead of applying the pending migrations")
+}
+
+// requireSchema exits unless the stored data has the storage schema version of this build.
+func requireSchema() {
+	versioned, ok := clientInstance.STORE.(storage.Versioned)
+	if !ok {
+		return
+	}
+
+	if err := versioned.CheckSchema(context.Background()); err != nil {
+		log.Fatalf("refusing to start: %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"context"
+	"log"
+
+	"ethereum-data-service/internal/snapshot"
+	"ethereum-data-service/pkg/enum"
+
+	"github.com/spf13/cobra"
+)
+
+var snapshotExpiry string
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Export the stored window to a snapshot file, or import one into an empty store",
+}
+
+var snapshotExportCmd = &cobra.Command{
+	Use:   "export <file>",
+	Short: "Write every stored block, with its transactions and events, to a snapshot file",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		requireSchema()
+
+		result, err := snapshot.Export(context.Background(), clientInstance.STORE, cfg, args[0])
+		if err != nil {
+			log.Fatalf("failed to export snapshot: %v", err)
+		}
+		log.Printf("Exported %d blocks (%d to %d, watermark %d) to %s\n", result.Blocks, result.Header.FromBlock, result.Header.ToBlock, result.Header.Watermark, args[0])
+	},
+}
+
+var snapshotImportCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Restore a snapshot file into an empty store, rebuilding every index",
+	Long: `Restore a snapshot file into an empty store, rebuilding every index and restoring the watermark.
+--expiry=original keeps the expiry every block had when it was exported, leaving out the blocks past it and
+keeping the blocks which had none without expiry, while --expiry=refresh gives every block the fresh
+REDIS_KEY_EXPIRY_TIME. Stop the other services first.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		requireSchema()
+
+		result, err := snapshot.Import(context.Background(), clientInstance.STORE, cfg, args[0], enum.SnapshotExpiry(snapshotExpiry))
+		if err != nil {
+			log.Fatalf("failed to import snapshot: %v", err)
+		}
+		log.Printf("Imported %d blocks (%d to %d, watermark %d) from %s, %d left out as expired\n", result.Blocks, result.Header.FromBlock, result.Header.ToBlock, result.Header.Watermark, args[0], result.Expired)
+	},
+}
+
+func init() {
+	snapshotImportCmd.Flags().StringVar(&snapshotExpiry, "expiry", string(enum.ORIGINAL), "expiry of the imported blocks: original or refresh")
+
+	snapshotCmd.AddCommand(snapshotExportCmd)
+	snapshotCmd.AddCommand(snapshotImportCmd)
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"ethereum-data-service/internal/verify"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	verifyFrom     uint64
+	verifyTo       uint64
+	verifyReceipts bool
+	verifyRefetch  bool
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check the stored blocks against the roots and hashes of their headers",
+	Long: `Recompute the transactions root, uncles hash, withdrawals root and logs bloom of every stored block from
+the stored data and compare them with its header, and check its hash against the parent hash of the next stored
+block, or against the node for the latest stored block. --receipts also fetches the receipts of every block
+from the node to check the receipts root and the stored events, and --refetch replaces the blocks which fail
+verification by the ones fetched from the node. Exits with status 1 if a block fails verification and is not
+re-fetched.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		requireSchema()
+
+		verifier := verify.New(clientInstance.STORE, clientInstance.ETH_HTTPS, verify.Options{Receipts: verifyReceipts, Refetch: verifyRefetch, Detail: cfg.INGEST_DETAIL})
+		summary, err := verifier.Range(context.Background(), verifyFrom, verifyTo, verify.LogMismatches)
+		if err != nil {
+			log.Fatalf("failed to verify blocks: %v", err)
+		}
+
+		log.Printf("Verified %d blocks: %d failed verification, %d re-fetched\n", summary.Verified, summary.Mismatched, summary.Refetched)
+		if summary.Mismatched > summary.Refetched {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	verifyCmd.Flags().Uint64Var(&verifyFrom, "from", 0, "first block to verify, the oldest stored block by default")
+	verifyCmd.Flags().Uint64Var(&verifyTo, "to", 0, "last block to verify, the latest stored block by default")
+	verifyCmd.Flags().BoolVar(&verifyReceipts, "receipts", false, "fetch the receipts of every block from the node to check the receipts root")
+	verifyCmd.Flags().BoolVar(&verifyRefetch, "refetch", false, "replace the blocks which fail verification by the ones fetched from the node")
+}
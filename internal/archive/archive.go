@@ -0,0 +1,615 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"ethereum-data-service/internal/config"
+	"ethereum-data-service/internal/model"
+	"ethereum-data-service/pkg/enum"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	eth_err "ethereum-data-service/pkg/err"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	// MANIFEST_FILE lists every archived file along with the range of blocks and the number of bytes it holds.
+	MANIFEST_FILE = "manifest.json"
+	// TX_INDEX_DIR holds the transaction index, sharded by the first byte of the transaction hash.
+	TX_INDEX_DIR = "txindex"
+
+	// NDJSON_EXT is the extension of the files rows are appended to. With the `parquet` format, a file is
+	// converted to Parquet once it no longer receives rows.
+	NDJSON_EXT = ".ndjson"
+	// PARQUET_EXT is the extension of the files converted to Parquet.
+	PARQUET_EXT = ".parquet"
+
+	BLOCKS       = "blocks"
+	TRANSACTIONS = "transactions"
+	RECEIPTS     = "receipts"
+	LOGS         = "logs"
+)
+
+// kinds are the tables every archived block is written to.
+var kinds = []string{BLOCKS, TRANSACTIONS, RECEIPTS, LOGS}
+
+// BlockRecord is a row of the blocks table. The body of the block is split into the transactions table.
+type BlockRecord struct {
+	Number            uint64              `json:"number"`
+	Hash              common.Hash         `json:"hash"`
+	ParentHash        common.Hash         `json:"parent_hash"`
+	Timestamp         uint64              `json:"timestamp"`
+	TransactionHashes []common.Hash       `json:"transaction_hashes"`
+	Header            *types.Header       `json:"header"`
+	Uncles            []*types.Header     `json:"uncles,omitempty"`
+	Withdrawals       []*types.Withdrawal `json:"withdrawals,omitempty"`
+	Detail            enum.DetailLevel    `json:"detail,omitempty"` // Detail is the ingestion detail level of the block, see `model.Block`.
+	Filtered          bool                `json:"filtered,omitempty"`
+	Receipts          []*model.Receipt    `json:"receipts,omitempty"`
+	Traces            []*model.Trace      `json:"traces,omitempty"`
+}
+
+// TransactionRecord is a row of the transactions table.
+type TransactionRecord struct {
+	Hash        common.Hash        `json:"hash"`
+	BlockNumber uint64             `json:"block_number"`
+	BlockHash   common.Hash        `json:"block_hash"`
+	Index       int                `json:"transaction_index"`
+	From        *common.Address    `json:"from,omitempty"`
+	To          *common.Address    `json:"to,omitempty"`
+	Value       *big.Int           `json:"value"`
+	Transaction *types.Transaction `json:"transaction"`
+}
+
+// ReceiptRecord is a row of the receipts table. The service stores the logs of a receipt but not its status
+// or gas used, so a receipt record only ties a transaction to its logs.
+type ReceiptRecord struct {
+	TransactionHash common.Hash `json:"transaction_hash"`
+	BlockNumber     uint64      `json:"block_number"`
+	BlockHash       common.Hash `json:"block_hash"`
+	Index           int         `json:"transaction_index"`
+	LogCount        int         `json:"log_count"`
+}
+
+// txIndexEntry is a line of the transaction index.
+type txIndexEntry struct {
+	Hash        string `json:"hash"`
+	BlockNumber uint64 `json:"block_number"`
+}
+
+// Manifest lists the archived files. Data files are only ever read up to the number of bytes the manifest
+// records, which is written (atomically) after the data, so a crash between the two leaves no partial rows.
+type Manifest struct {
+	// Format is the format the files are written in. Files keep the format they were written in when it changes,
+	// the readers going by their extension.
+	Format string `json:"format"`
+	// LastArchived is the number of the last archived block, 0 when none is archived yet.
+	LastArchived uint64  `json:"last_archived"`
+	Files        []*File `json:"files"`
+}
+
+// File is an archived data file holding the rows of a single table, date and height partition.
+type File struct {
+	Path      string `json:"path"`
+	Kind      string `json:"kind"`
+	Date      string `json:"date"`
+	FromBlock uint64 `json:"from_block"`
+	ToBlock   uint64 `json:"to_block"`
+	Rows      int    `json:"rows"`
+	Bytes     int64  `json:"bytes"`
+	// Closed is set once the file reached `ARCHIVE_MAX_FILE_SIZE` and rows went on in a new file.
+	Closed bool `json:"closed"`
+}
+
+// Archiver writes blocks to date- and height-partitioned files:
+// `<kind>/date=YYYY-MM-DD/height=<first block of partition>/part-NNNN.<ndjson|parquet>`.
+//
+// Rows are always appended to NDJSON files, which can be truncated back to the bytes the manifest records. With
+// the `parquet` format, a file is converted to Parquet once it no longer receives rows, that is once it is rotated
+// or the blocks moved on to another partition, and the manifest then points to the Parquet file. The file being
+// appended to therefore stays in NDJSON until then.
+type Archiver struct {
+	dir             string
+	format          enum.ArchiveFormat
+	partitionBlocks uint64
+	maxFileSize     int64
+	retention       time.Duration
+	manifest        *Manifest
+	// converted lists the NDJSON files converted to Parquet, which are removed once the manifest is saved.
+	converted []string
+}
+
+// NewArchiver opens the archive in `ARCHIVE_DIR`, creating it if needed.
+func NewArchiver(cfg *config.Config) (*Archiver, error) {
+	format := enum.ArchiveFormat(cfg.ARCHIVE_FORMAT)
+	if format != enum.NDJSON && format != enum.PARQUET {
+		return nil, fmt.Errorf("%w: %s", eth_err.ErrInvalidArchiveFormat, cfg.ARCHIVE_FORMAT)
+	}
+
+	if err := os.MkdirAll(filepath.Join(cfg.ARCHIVE_DIR, TX_INDEX_DIR), 0o755); err != nil {
+		return nil, fmt.Errorf("error creating archive directory: %v", err)
+	}
+
+	manifest, err := LoadManifest(cfg.ARCHIVE_DIR)
+	if err != nil {
+		return nil, err
+	}
+	manifest.Format = string(format)
+
+	partitionBlocks := uint64(cfg.ARCHIVE_PARTITION_BLOCKS)
+	if partitionBlocks == 0 {
+		partitionBlocks = 1
+	}
+
+	return &Archiver{
+		dir:             cfg.ARCHIVE_DIR,
+		format:          format,
+		partitionBlocks: partitionBlocks,
+		maxFileSize:     cfg.ARCHIVE_MAX_FILE_SIZE,
+		retention:       time.Duration(cfg.ARCHIVE_RETENTION_DAYS) * 24 * time.Hour,
+		manifest:        manifest,
+	}, nil
+}
+
+// LastArchived returns the number of the last archived block, 0 when none is archived yet.
+func (a *Archiver) LastArchived() uint64 {
+	return a.manifest.LastArchived
+}
+
+// Archive appends the block, its transactions, receipts and logs to the current file of every table, rotating
+// the files which reached `ARCHIVE_MAX_FILE_SIZE`, indexes its transactions and records it in the manifest.
+// On failure the manifest is reloaded, so that the block is written again from scratch on the next attempt.
+func (a *Archiver) Archive(blockData *model.Data) error {
+	err := a.archive(blockData)
+	if err != nil {
+		if manifest, loadErr := LoadManifest(a.dir); loadErr == nil {
+			manifest.Format = string(a.format)
+			a.manifest = manifest
+		}
+	}
+	a.converted = nil
+
+	return err
+}
+
+func (a *Archiver) archive(blockData *model.Data) error {
+	header := blockData.Block.Header
+	number := header.Number.Uint64()
+	date := time.Unix(int64(header.Time), 0).UTC().Format(time.DateOnly)
+
+	rows, err := records(blockData)
+	if err != nil {
+		return err
+	}
+
+	for _, kind := range kinds {
+		if len(rows[kind]) == 0 {
+			continue
+		}
+		if err := a.append(kind, date, number, rows[kind]); err != nil {
+			return err
+		}
+	}
+
+	if err := a.index(blockData, number); err != nil {
+		return err
+	}
+
+	if a.format == enum.PARQUET {
+		if err := a.convertDone(date, number); err != nil {
+			return err
+		}
+	}
+
+	a.manifest.LastArchived = number
+	if err := a.manifest.save(a.dir); err != nil {
+		return err
+	}
+
+	// The manifest no longer points to the converted files
+	for _, path := range a.converted {
+		os.Remove(filepath.Join(a.dir, path))
+	}
+
+	return nil
+}
+
+// convertDone converts to Parquet the NDJSON files which no longer receive rows: the rotated ones, and the ones
+// of the partitions before the one of the block just archived, blocks being archived in height order.
+func (a *Archiver) convertDone(date string, number uint64) error {
+	for _, f := range a.manifest.Files {
+		if filepath.Ext(f.Path) != NDJSON_EXT || f.Rows == 0 {
+			continue
+		}
+		if !f.Closed && filepath.Dir(f.Path) == a.partition(f.Kind, date, number) {
+			continue
+		}
+		if err := a.convert(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// convert writes the rows of an NDJSON file to a Parquet file next to it, and points the manifest to it. The
+// NDJSON file is left in place until the manifest is saved.
+func (a *Archiver) convert(f *File) error {
+	var rows [][]byte
+	err := NewReader(a.dir).scan(f, func(row []byte) (bool, error) {
+		rows = append(rows, bytes.Clone(row))
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	path := strings.TrimSuffix(f.Path, NDJSON_EXT) + PARQUET_EXT
+	size, err := writeParquet(filepath.Join(a.dir, path), f.Kind, rows)
+	if err != nil {
+		return err
+	}
+
+	a.converted = append(a.converted, f.Path)
+	f.Path, f.Bytes, f.Closed = path, size, true
+
+	return nil
+}
+
+// Prune deletes the date partitions older than `ARCHIVE_RETENTION_DAYS` (0 keeping every partition) and
+// drops their transactions from the index. It returns the number of deleted files.
+func (a *Archiver) Prune(now time.Time) (int, error) {
+	if a.retention <= 0 {
+		return 0, nil
+	}
+	cutoff := now.Add(-a.retention).UTC().Format(time.DateOnly)
+
+	var kept []*File
+	var pruned []*File
+	for _, f := range a.manifest.Files {
+		if f.Date < cutoff {
+			pruned = append(pruned, f)
+		} else {
+			kept = append(kept, f)
+		}
+	}
+	if len(pruned) == 0 {
+		return 0, nil
+	}
+
+	// Drop the files from the manifest first, so that readers never look for a deleted file
+	a.manifest.Files = kept
+	if err := a.manifest.save(a.dir); err != nil {
+		return 0, err
+	}
+
+	for _, f := range pruned {
+		if err := os.Remove(filepath.Join(a.dir, f.Path)); err != nil && !os.IsNotExist(err) {
+			return 0, fmt.Errorf("error removing archived file %s: %v", f.Path, err)
+		}
+		// Remove the partition directories left empty, ignoring the ones still holding files
+		partition := filepath.Dir(filepath.Join(a.dir, f.Path))
+		if os.Remove(partition) == nil {
+			os.Remove(filepath.Dir(partition))
+		}
+	}
+
+	return len(pruned), a.compactIndex()
+}
+
+// append writes the rows of a block to the current file of a table, date and height partition.
+func (a *Archiver) append(kind, date string, number uint64, rows [][]byte) error {
+	f := a.current(kind, date, number)
+
+	file, err := os.OpenFile(filepath.Join(a.dir, f.Path), os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening archived file %s: %v", f.Path, err)
+	}
+	defer file.Close()
+
+	// Drop whatever was written past the manifest before a crash
+	if err := file.Truncate(f.Bytes); err != nil {
+		return fmt.Errorf("error truncating archived file %s: %v", f.Path, err)
+	}
+
+	if _, err := file.Seek(f.Bytes, io.SeekStart); err != nil {
+		return fmt.Errorf("error seeking archived file %s: %v", f.Path, err)
+	}
+
+	w := bufio.NewWriter(file)
+	var written int64
+	for _, row := range rows {
+		n, _ := w.Write(row)
+		w.WriteByte('\n')
+		written += int64(n) + 1
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("error writing archived file %s: %v", f.Path, err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("error syncing archived file %s: %v", f.Path, err)
+	}
+
+	if f.Rows == 0 {
+		f.FromBlock = number
+	}
+	f.ToBlock = number
+	f.Rows += len(rows)
+	f.Bytes += written
+	if a.maxFileSize > 0 && f.Bytes >= a.maxFileSize {
+		f.Closed = true
+	}
+
+	return nil
+}
+
+// current returns the open file of a table, date and height partition, adding a new file to the manifest if
+// there is none.
+func (a *Archiver) current(kind, date string, number uint64) *File {
+	partition := a.partition(kind, date, number)
+
+	parts := 0
+	for _, f := range a.manifest.Files {
+		if filepath.Dir(f.Path) != partition {
+			continue
+		}
+		if !f.Closed {
+			return f
+		}
+		parts++
+	}
+
+	f := &File{
+		Path: filepath.Join(partition, fmt.Sprintf("part-%04d%s", parts, NDJSON_EXT)),
+		Kind: kind,
+		Date: date,
+	}
+	os.MkdirAll(filepath.Join(a.dir, partition), 0o755)
+	a.manifest.Files = append(a.manifest.Files, f)
+
+	return f
+}
+
+// partition returns the directory of the partition of a table holding the rows of the given block.
+func (a *Archiver) partition(kind, date string, number uint64) string {
+	return filepath.Join(kind, "date="+date, fmt.Sprintf("height=%d", number-number%a.partitionBlocks))
+}
+
+// index appends the transactions of a block to the index shards. The index is appended to rather than
+// rewritten, a transaction archived twice (after a crash) resolving to the latest block.
+func (a *Archiver) index(blockData *model.Data, number uint64) error {
+	shards := make(map[string][]byte)
+	for hash := range blockData.TransactionHashes {
+		line, err := json.Marshal(txIndexEntry{Hash: strings.ToLower(hash), BlockNumber: number})
+		if err != nil {
+			return err
+		}
+		shard := shardOf(hash)
+		shards[shard] = append(append(shards[shard], line...), '\n')
+	}
+
+	for shard, lines := range shards {
+		file, err := os.OpenFile(filepath.Join(a.dir, TX_INDEX_DIR, shard), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return fmt.Errorf("error opening transaction index %s: %v", shard, err)
+		}
+		_, err = file.Write(lines)
+		if err == nil {
+			err = file.Sync()
+		}
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("error writing transaction index %s: %v", shard, err)
+		}
+	}
+
+	return nil
+}
+
+// compactIndex rewrites the index shards without the transactions of blocks which are no longer archived.
+func (a *Archiver) compactIndex() error {
+	oldest, ok := a.manifest.oldestBlock()
+
+	shards, err := os.ReadDir(filepath.Join(a.dir, TX_INDEX_DIR))
+	if err != nil {
+		return fmt.Errorf("error listing transaction index: %v", err)
+	}
+
+	for _, shard := range shards {
+		path := filepath.Join(a.dir, TX_INDEX_DIR, shard.Name())
+		entries, err := readIndex(path)
+		if err != nil {
+			return err
+		}
+
+		var lines []byte
+		for _, entry := range entries {
+			if ok && entry.BlockNumber >= oldest {
+				line, _ := json.Marshal(entry)
+				lines = append(append(lines, line...), '\n')
+			}
+		}
+
+		if len(lines) == 0 {
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("error removing transaction index %s: %v", shard.Name(), err)
+			}
+			continue
+		}
+		if err := writeAtomic(path, lines); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// records encodes the rows of every table for a block.
+func records(blockData *model.Data) (map[string][][]byte, error) {
+	header := blockData.Block.Header
+	number := header.Number.Uint64()
+	hash := header.Hash()
+
+	block := BlockRecord{
+		Number:     number,
+		Hash:       hash,
+		ParentHash: header.ParentHash,
+		Timestamp:  header.Time,
+		Header:     header,
+		Detail:     blockData.Block.Detail,
+		Filtered:   blockData.Block.Filtered,
+		Receipts:   blockData.Block.Receipts,
+		Traces:     blockData.Block.Traces,
+	}
+
+	// Below the `full` detail level, only the transaction hashes are kept, if any
+	block.TransactionHashes = append(block.TransactionHashes, blockData.Block.TxHashes...)
+
+	var txs []*types.Transaction
+	if body := blockData.Block.Body; body != nil {
+		txs = body.Transactions
+		block.Uncles = body.Uncles
+		block.Withdrawals = body.Withdrawals
+	}
+
+	rows := make(map[string][][]byte)
+	add := func(kind string, v interface{}) error {
+		row, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("error encoding archived %s row: %v", kind, err)
+		}
+		rows[kind] = append(rows[kind], row)
+		return nil
+	}
+
+	for i, tx := range txs {
+		block.TransactionHashes = append(block.TransactionHashes, tx.Hash())
+
+		record := TransactionRecord{
+			Hash:        tx.Hash(),
+			BlockNumber: number,
+			BlockHash:   hash,
+			Index:       i,
+			To:          tx.To(),
+			Value:       tx.Value(),
+			Transaction: tx,
+		}
+		if from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx); err == nil {
+			record.From = &from
+		}
+		if err := add(TRANSACTIONS, record); err != nil {
+			return nil, err
+		}
+
+		logs := blockData.Events[tx.Hash().Hex()]
+		receipt := ReceiptRecord{
+			TransactionHash: tx.Hash(),
+			BlockNumber:     number,
+			BlockHash:       hash,
+			Index:           i,
+			LogCount:        len(logs),
+		}
+		if err := add(RECEIPTS, receipt); err != nil {
+			return nil, err
+		}
+
+		for _, event := range logs {
+			if err := add(LOGS, event); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := add(BLOCKS, block); err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// LoadManifest reads the manifest of the archive in dir, returning an empty manifest if there is none yet.
+func LoadManifest(dir string) (*Manifest, error) {
+	value, err := os.ReadFile(filepath.Join(dir, MANIFEST_FILE))
+	if os.IsNotExist(err) {
+		return &Manifest{Format: string(enum.NDJSON)}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading archive manifest: %v", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(value, &manifest); err != nil {
+		return nil, fmt.Errorf("error decoding archive manifest: %v", err)
+	}
+
+	return &manifest, nil
+}
+
+// save writes the manifest atomically.
+func (m *Manifest) save(dir string) error {
+	value, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeAtomic(filepath.Join(dir, MANIFEST_FILE), value)
+}
+
+// filesOf returns the files of a table holding the given block, newest first.
+func (m *Manifest) filesOf(kind string, number uint64) []*File {
+	var files []*File
+	for _, f := range m.Files {
+		if f.Kind == kind && f.Rows > 0 && f.FromBlock <= number && number <= f.ToBlock {
+			files = append(files, f)
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].ToBlock > files[j].ToBlock })
+
+	return files
+}
+
+// oldestBlock returns the number of the oldest archived block.
+func (m *Manifest) oldestBlock() (uint64, bool) {
+	var oldest uint64
+	found := false
+	for _, f := range m.Files {
+		if f.Kind == BLOCKS && f.Rows > 0 && (!found || f.FromBlock < oldest) {
+			oldest, found = f.FromBlock, true
+		}
+	}
+
+	return oldest, found
+}
+
+// shardOf returns the index shard of a transaction hash.
+func shardOf(hash string) string {
+	hash = strings.ToLower(strings.TrimPrefix(hash, "0x"))
+	if len(hash) < 2 {
+		return "00.ndjson"
+	}
+
+	return hash[:2] + ".ndjson"
+}
+
+// writeAtomic replaces the file at path through a temporary file and a rename.
+func writeAtomic(path string, value []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, value, 0o644); err != nil {
+		return fmt.Errorf("error writing %s: %v", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("error renaming %s: %v", tmp, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,283 @@
+package archive
+
+import (
+	"errors"
+	"ethereum-data-service/internal/config"
+	"ethereum-data-service/internal/model"
+	"ethereum-data-service/internal/testutil"
+	"ethereum-data-service/pkg/enum"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	eth_err "ethereum-data-service/pkg/err"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var formats = []enum.ArchiveFormat{enum.NDJSON, enum.PARQUET}
+
+func newTestArchiver(t *testing.T, cfg config.Config) *Archiver {
+	t.Helper()
+	if cfg.ARCHIVE_DIR == "" {
+		cfg.ARCHIVE_DIR = t.TempDir()
+	}
+	if cfg.ARCHIVE_PARTITION_BLOCKS == 0 {
+		cfg.ARCHIVE_PARTITION_BLOCKS = 10000
+	}
+
+	a, err := NewArchiver(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func mustArchive(t *testing.T, a *Archiver, blockData *model.Data) {
+	t.Helper()
+	if err := a.Archive(blockData); err != nil {
+		t.Fatalf("archiving block %d: %v", blockData.Block.Header.Number, err)
+	}
+}
+
+// checkArchived checks that the reader finds the block and every transaction of blockData.
+func checkArchived(t *testing.T, r *Reader, blockData *model.Data) {
+	t.Helper()
+	number := blockData.Block.Header.Number.String()
+
+	block, err := r.GetBlockByNumber(number)
+	if err != nil {
+		t.Fatalf("GetBlockByNumber(%s): %v", number, err)
+	}
+	if block.Header.Hash() != blockData.Block.Header.Hash() {
+		t.Errorf("block %s: got hash %s, want %s", number, block.Header.Hash(), blockData.Block.Header.Hash())
+	}
+	if got, want := len(block.Body.Transactions), len(blockData.Block.Body.Transactions); got != want {
+		t.Fatalf("block %s: got %d transactions, want %d", number, got, want)
+	}
+	for i, tx := range blockData.Block.Body.Transactions {
+		if block.Body.Transactions[i].Hash() != tx.Hash() {
+			t.Errorf("block %s: transaction %d is %s, want %s", number, i, block.Body.Transactions[i].Hash(), tx.Hash())
+		}
+
+		archived, err := r.GetTransactionByHash(tx.Hash().Hex())
+		if err != nil {
+			t.Fatalf("GetTransactionByHash(%s): %v", tx.Hash(), err)
+		}
+		if archived.Hash() != tx.Hash() {
+			t.Errorf("GetTransactionByHash(%s) = %s", tx.Hash(), archived.Hash())
+		}
+	}
+}
+
+func TestArchiveLookups(t *testing.T) {
+	for _, format := range formats {
+		t.Run(string(format), func(t *testing.T) {
+			// Partitions of 2 blocks, so that the blocks span files of several partitions
+			a := newTestArchiver(t, config.Config{ARCHIVE_FORMAT: string(format), ARCHIVE_PARTITION_BLOCKS: 2})
+			r := NewReader(a.dir)
+
+			var blocks []*model.Data
+			for n := int64(1); n <= 5; n++ {
+				blockData := testutil.BlockData(n, time.Now(), 3, 2)
+				mustArchive(t, a, blockData)
+				blocks = append(blocks, blockData)
+			}
+			if a.LastArchived() != 5 {
+				t.Errorf("LastArchived() = %d, want 5", a.LastArchived())
+			}
+
+			// The files of the partitions the blocks moved on from are converted, the ones of block 5 still appended to
+			for _, f := range a.manifest.Files {
+				want := NDJSON_EXT
+				if format == enum.PARQUET && f.FromBlock < 4 {
+					want = PARQUET_EXT
+				}
+				if filepath.Ext(f.Path) != want {
+					t.Errorf("file %s of blocks %d to %d, want a %s file", f.Path, f.FromBlock, f.ToBlock, want)
+				}
+			}
+
+			for _, blockData := range blocks {
+				checkArchived(t, r, blockData)
+			}
+
+			if _, err := r.GetBlockByNumber("6"); !errors.Is(err, eth_err.ErrNotFound) {
+				t.Errorf("GetBlockByNumber of a block not archived: got error %v, want ErrNotFound", err)
+			}
+			if _, err := r.GetTransactionByHash(common.Hash{1}.Hex()); !errors.Is(err, eth_err.ErrNotFound) {
+				t.Errorf("GetTransactionByHash of a transaction not archived: got error %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestArchiveRotation(t *testing.T) {
+	for _, format := range formats {
+		t.Run(string(format), func(t *testing.T) {
+			// Every block fills a file of 1KB, which is rotated past it
+			a := newTestArchiver(t, config.Config{ARCHIVE_FORMAT: string(format), ARCHIVE_MAX_FILE_SIZE: 1 << 10})
+			r := NewReader(a.dir)
+
+			var blocks []*model.Data
+			for n := int64(1); n <= 3; n++ {
+				blockData := testutil.BlockData(n, time.Now(), 4, 1)
+				mustArchive(t, a, blockData)
+				blocks = append(blocks, blockData)
+			}
+
+			manifest, err := LoadManifest(a.dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var files []*File
+			for _, f := range manifest.Files {
+				if f.Kind == TRANSACTIONS {
+					files = append(files, f)
+				}
+			}
+			if len(files) != 3 {
+				t.Fatalf("got %d transactions files, want one per block", len(files))
+			}
+			for i, f := range files {
+				ext := NDJSON_EXT
+				if format == enum.PARQUET {
+					ext = PARQUET_EXT
+				}
+				if want := fmt.Sprintf("part-%04d%s", i, ext); filepath.Base(f.Path) != want {
+					t.Errorf("file %d is %s, want %s", i, f.Path, want)
+				}
+				if f.FromBlock != uint64(i+1) || f.ToBlock != uint64(i+1) || !f.Closed {
+					t.Errorf("file %s holds blocks %d to %d (closed: %v), want block %d closed", f.Path, f.FromBlock, f.ToBlock, f.Closed, i+1)
+				}
+
+				info, err := os.Stat(filepath.Join(a.dir, f.Path))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Size() != f.Bytes {
+					t.Errorf("file %s is %d bytes, the manifest records %d", f.Path, info.Size(), f.Bytes)
+				}
+				if format == enum.PARQUET {
+					if _, err := os.Stat(filepath.Join(a.dir, strings.TrimSuffix(f.Path, PARQUET_EXT)+NDJSON_EXT)); !os.IsNotExist(err) {
+						t.Errorf("NDJSON file of %s left after its conversion: %v", f.Path, err)
+					}
+				}
+			}
+
+			for _, blockData := range blocks {
+				checkArchived(t, r, blockData)
+			}
+		})
+	}
+}
+
+func TestArchiveRecovery(t *testing.T) {
+	a := newTestArchiver(t, config.Config{ARCHIVE_FORMAT: string(enum.NDJSON)})
+	r := NewReader(a.dir)
+
+	first := testutil.BlockData(1, time.Now(), 2, 1)
+	mustArchive(t, a, first)
+
+	// A crash leaves rows written past the manifest in every file
+	manifest, err := LoadManifest(a.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range manifest.Files {
+		file, err := os.OpenFile(filepath.Join(a.dir, f.Path), os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.WriteString(`{"number":2,"partial`)
+		file.Close()
+	}
+
+	// Readers stop at the bytes the manifest records
+	checkArchived(t, r, first)
+	if _, err := r.GetBlockByNumber("2"); !errors.Is(err, eth_err.ErrNotFound) {
+		t.Errorf("GetBlockByNumber of a partially written block: got error %v, want ErrNotFound", err)
+	}
+
+	// The archiver reopened after the crash truncates the partial rows before appending
+	a = newTestArchiver(t, config.Config{ARCHIVE_DIR: a.dir, ARCHIVE_FORMAT: string(enum.NDJSON)})
+	second := testutil.BlockData(2, time.Now(), 2, 1)
+	mustArchive(t, a, second)
+
+	manifest, err = LoadManifest(a.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range manifest.Files {
+		value, err := os.ReadFile(filepath.Join(a.dir, f.Path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(len(value)) != f.Bytes || strings.Contains(string(value), "partial") {
+			t.Errorf("file %s holds %d bytes, the manifest records %d: partial rows not truncated", f.Path, len(value), f.Bytes)
+		}
+	}
+
+	checkArchived(t, r, first)
+	checkArchived(t, r, second)
+}
+
+func TestArchivePrune(t *testing.T) {
+	for _, format := range formats {
+		t.Run(string(format), func(t *testing.T) {
+			now := time.Now()
+			a := newTestArchiver(t, config.Config{ARCHIVE_FORMAT: string(format), ARCHIVE_RETENTION_DAYS: 7})
+			r := NewReader(a.dir)
+
+			old := testutil.BlockData(1, now.Add(-10*24*time.Hour), 2, 1)
+			recent := testutil.BlockData(2, now, 2, 1)
+			mustArchive(t, a, old)
+			mustArchive(t, a, recent)
+
+			// The blocks, transactions, receipts and logs files of the old block
+			pruned, err := a.Prune(now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pruned != len(kinds) {
+				t.Errorf("Prune() = %d, want %d", pruned, len(kinds))
+			}
+
+			if _, err := r.GetBlockByNumber("1"); !errors.Is(err, eth_err.ErrNotFound) {
+				t.Errorf("GetBlockByNumber of a pruned block: got error %v, want ErrNotFound", err)
+			}
+			for txHash := range old.TransactionHashes {
+				if _, err := r.GetTransactionByHash(txHash); !errors.Is(err, eth_err.ErrNotFound) {
+					t.Errorf("GetTransactionByHash of a pruned transaction: got error %v, want ErrNotFound", err)
+				}
+
+				entries, err := readIndex(filepath.Join(a.dir, TX_INDEX_DIR, shardOf(txHash)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, entry := range entries {
+					if entry.Hash == strings.ToLower(txHash) {
+						t.Errorf("pruned transaction %s still indexed", txHash)
+					}
+				}
+			}
+
+			oldDate := time.Unix(int64(old.Block.Header.Time), 0).UTC().Format(time.DateOnly)
+			for _, kind := range kinds {
+				if _, err := os.Stat(filepath.Join(a.dir, kind, "date="+oldDate)); !os.IsNotExist(err) {
+					t.Errorf("pruned %s partition of %s left: %v", kind, oldDate, err)
+				}
+			}
+
+			checkArchived(t, r, recent)
+
+			if pruned, err := a.Prune(now); err != nil || pruned != 0 {
+				t.Errorf("Prune() again = %d, %v, want 0", pruned, err)
+			}
+		})
+	}
+}
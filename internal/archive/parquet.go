@@ -0,0 +1,209 @@
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress/zstd"
+)
+
+// parquetBatchSize is the number of rows read from a Parquet file at once.
+const parquetBatchSize = 256
+
+// The Parquet rows of every table flatten the fields worth querying into columns, and keep the whole record as
+// JSON in the `record` column, which is what the reader decodes.
+
+type parquetBlock struct {
+	Number           uint64 `parquet:"number"`
+	Hash             string `parquet:"hash"`
+	ParentHash       string `parquet:"parent_hash"`
+	Timestamp        uint64 `parquet:"timestamp"`
+	TransactionCount int    `parquet:"transaction_count"`
+	Detail           string `parquet:"detail"`
+	Record           string `parquet:"record"`
+}
+
+type parquetTransaction struct {
+	Hash        string `parquet:"hash"`
+	BlockNumber uint64 `parquet:"block_number"`
+	BlockHash   string `parquet:"block_hash"`
+	Index       int    `parquet:"transaction_index"`
+	From        string `parquet:"from,optional"`
+	To          string `parquet:"to,optional"`
+	Value       string `parquet:"value"`
+	Record      string `parquet:"record"`
+}
+
+type parquetReceipt struct {
+	TransactionHash string `parquet:"transaction_hash"`
+	BlockNumber     uint64 `parquet:"block_number"`
+	BlockHash       string `parquet:"block_hash"`
+	Index           int    `parquet:"transaction_index"`
+	LogCount        int    `parquet:"log_count"`
+	Record          string `parquet:"record"`
+}
+
+type parquetLog struct {
+	Address          string `parquet:"address"`
+	Topic0           string `parquet:"topic0,optional"`
+	BlockNumber      uint64 `parquet:"block_number"`
+	BlockHash        string `parquet:"block_hash"`
+	TransactionHash  string `parquet:"transaction_hash"`
+	TransactionIndex uint   `parquet:"transaction_index"`
+	LogIndex         uint   `parquet:"log_index"`
+	Record           string `parquet:"record"`
+}
+
+// parquetRecord reads the `record` column alone, whatever the table.
+type parquetRecord struct {
+	Record string `parquet:"record"`
+}
+
+// writeParquet writes the NDJSON rows of a table to a Parquet file at path, through a temporary file and a
+// rename, and returns its size.
+func writeParquet(path, kind string, rows [][]byte) (int64, error) {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return 0, fmt.Errorf("error creating %s: %v", tmp, err)
+	}
+	defer file.Close()
+
+	switch kind {
+	case BLOCKS:
+		err = writeParquetRows(file, rows, func(row []byte) (parquetBlock, error) {
+			var record BlockRecord
+			err := json.Unmarshal(row, &record)
+			return parquetBlock{
+				Number:           record.Number,
+				Hash:             record.Hash.Hex(),
+				ParentHash:       record.ParentHash.Hex(),
+				Timestamp:        record.Timestamp,
+				TransactionCount: len(record.TransactionHashes),
+				Detail:           string(record.Detail),
+				Record:           string(row),
+			}, err
+		})
+	case TRANSACTIONS:
+		err = writeParquetRows(file, rows, func(row []byte) (parquetTransaction, error) {
+			var record TransactionRecord
+			err := json.Unmarshal(row, &record)
+			tx := parquetTransaction{
+				Hash:        record.Hash.Hex(),
+				BlockNumber: record.BlockNumber,
+				BlockHash:   record.BlockHash.Hex(),
+				Index:       record.Index,
+				Record:      string(row),
+			}
+			if record.From != nil {
+				tx.From = record.From.Hex()
+			}
+			if record.To != nil {
+				tx.To = record.To.Hex()
+			}
+			if record.Value != nil {
+				tx.Value = record.Value.String()
+			}
+			return tx, err
+		})
+	case RECEIPTS:
+		err = writeParquetRows(file, rows, func(row []byte) (parquetReceipt, error) {
+			var record ReceiptRecord
+			err := json.Unmarshal(row, &record)
+			return parquetReceipt{
+				TransactionHash: record.TransactionHash.Hex(),
+				BlockNumber:     record.BlockNumber,
+				BlockHash:       record.BlockHash.Hex(),
+				Index:           record.Index,
+				LogCount:        record.LogCount,
+				Record:          string(row),
+			}, err
+		})
+	case LOGS:
+		err = writeParquetRows(file, rows, func(row []byte) (parquetLog, error) {
+			var record types.Log
+			err := json.Unmarshal(row, &record)
+			log := parquetLog{
+				Address:          record.Address.Hex(),
+				BlockNumber:      record.BlockNumber,
+				BlockHash:        record.BlockHash.Hex(),
+				TransactionHash:  record.TxHash.Hex(),
+				TransactionIndex: record.TxIndex,
+				LogIndex:         record.Index,
+				Record:           string(row),
+			}
+			if len(record.Topics) > 0 {
+				log.Topic0 = record.Topics[0].Hex()
+			}
+			return log, err
+		})
+	default:
+		err = fmt.Errorf("unknown archived table %q", kind)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error writing %s: %v", tmp, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("error reading size of %s: %v", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return 0, fmt.Errorf("error renaming %s: %v", tmp, err)
+	}
+
+	return info.Size(), nil
+}
+
+// writeParquetRows writes the rows, converted by project, as a zstd-compressed Parquet file.
+func writeParquetRows[T any](w io.Writer, rows [][]byte, project func(row []byte) (T, error)) error {
+	records := make([]T, 0, len(rows))
+	for _, row := range rows {
+		record, err := project(row)
+		if err != nil {
+			return fmt.Errorf("error decoding archived row: %v", err)
+		}
+		records = append(records, record)
+	}
+
+	writer := parquet.NewGenericWriter[T](w, parquet.Compression(&zstd.Codec{}))
+	if _, err := writer.Write(records); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// scanParquet calls fn with the record of every row of the first size bytes of a Parquet file, until fn returns
+// true or an error.
+func scanParquet(file *os.File, size int64, fn func(row []byte) (bool, error)) error {
+	// Opening the file first returns an error where the reader would panic on an invalid file
+	f, err := parquet.OpenFile(file, size)
+	if err != nil {
+		return err
+	}
+	reader := parquet.NewGenericReader[parquetRecord](f)
+	defer reader.Close()
+
+	records := make([]parquetRecord, parquetBatchSize)
+	for {
+		n, err := reader.Read(records)
+		for _, record := range records[:n] {
+			if done, err := fn([]byte(record.Record)); err != nil || done {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package archive
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"ethereum-data-service/internal/model"
+	"ethereum-data-service/internal/storage"
+	"ethereum-data-service/pkg/enum"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	eth_err "ethereum-data-service/pkg/err"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// maxRowSize caps the size of an archived row, well above the largest transaction a block can hold.
+const maxRowSize = 16 << 20
+
+// Reader looks blocks and transactions up in the archive. It reads the manifest on every lookup, so that it
+// sees the blocks archived since by another process, and never reads a file past the bytes the manifest records.
+type Reader struct {
+	dir string
+}
+
+// NewReader returns a reader of the archive in dir.
+func NewReader(dir string) *Reader {
+	return &Reader{dir: dir}
+}
+
+// GetBlockByNumber returns the archived block with the given number, its body rebuilt from the transactions table.
+func (r *Reader) GetBlockByNumber(blockNumber string) (*model.Block, error) {
+	number, err := strconv.ParseUint(blockNumber, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("block %s: %w", blockNumber, eth_err.ErrNotFound)
+	}
+
+	manifest, err := LoadManifest(r.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var block *BlockRecord
+	for _, f := range manifest.filesOf(BLOCKS, number) {
+		err := r.scan(f, func(row []byte) (bool, error) {
+			var record BlockRecord
+			if err := json.Unmarshal(row, &record); err != nil {
+				return false, fmt.Errorf("error decoding archived block: %v", err)
+			}
+			if record.Number == number {
+				block = &record
+			}
+			return block != nil, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if block != nil {
+			break
+		}
+	}
+	if block == nil {
+		return nil, fmt.Errorf("block %s: %w", blockNumber, eth_err.ErrNotFound)
+	}
+
+	archived := &model.Block{Header: block.Header, Detail: block.Detail, Filtered: block.Filtered, Receipts: block.Receipts, Traces: block.Traces}
+	if !model.IncludesLevel(block.Detail, enum.FULL) {
+		if model.IncludesLevel(block.Detail, enum.TX_HASHES) {
+			archived.TxHashes = block.TransactionHashes
+		}
+		return archived, nil
+	}
+
+	txs, err := r.transactions(manifest, number, func(*TransactionRecord) bool { return true })
+	if err != nil {
+		return nil, err
+	}
+
+	archived.Body = &types.Body{Uncles: block.Uncles, Withdrawals: block.Withdrawals}
+	for _, tx := range txs {
+		archived.Body.Transactions = append(archived.Body.Transactions, tx.Transaction)
+	}
+
+	return archived, nil
+}
+
+// GetTransactionByHash returns the archived transaction with the given hash, located through the transaction index.
+func (r *Reader) GetTransactionByHash(txHash string) (*types.Transaction, error) {
+	txHash = strings.ToLower(txHash)
+
+	entries, err := readIndex(filepath.Join(r.dir, TX_INDEX_DIR, shardOf(txHash)))
+	if err != nil {
+		return nil, err
+	}
+
+	// The latest entry wins, the same transaction being indexed again if its block was archived twice
+	var number uint64
+	found := false
+	for _, entry := range entries {
+		if entry.Hash == txHash {
+			number, found = entry.BlockNumber, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("transaction %s: %w", txHash, eth_err.ErrNotFound)
+	}
+
+	manifest, err := LoadManifest(r.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	txs, err := r.transactions(manifest, number, func(record *TransactionRecord) bool {
+		return strings.EqualFold(record.Hash.Hex(), txHash)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(txs) == 0 {
+		return nil, fmt.Errorf("transaction %s: %w", txHash, eth_err.ErrNotFound)
+	}
+
+	return txs[0].Transaction, nil
+}
+
+// transactions returns the archived transactions of a block matching keep, in transaction index order.
+func (r *Reader) transactions(manifest *Manifest, number uint64, keep func(*TransactionRecord) bool) ([]*TransactionRecord, error) {
+	var txs []*TransactionRecord
+	for _, f := range manifest.filesOf(TRANSACTIONS, number) {
+		err := r.scan(f, func(row []byte) (bool, error) {
+			var record TransactionRecord
+			if err := json.Unmarshal(row, &record); err != nil {
+				return false, fmt.Errorf("error decoding archived transaction: %v", err)
+			}
+			if record.BlockNumber == number && keep(&record) {
+				txs = append(txs, &record)
+			}
+			return false, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(txs, func(i, j int) bool { return txs[i].Index < txs[j].Index })
+
+	return txs, nil
+}
+
+// scan calls fn with every row of an archived file until fn returns true or an error.
+func (r *Reader) scan(f *File, fn func(row []byte) (bool, error)) error {
+	file, err := os.Open(filepath.Join(r.dir, f.Path))
+	if err != nil {
+		return fmt.Errorf("error opening archived file %s: %v", f.Path, err)
+	}
+	defer file.Close()
+
+	if filepath.Ext(f.Path) == PARQUET_EXT {
+		if err := scanParquet(file, f.Bytes, fn); err != nil {
+			return fmt.Errorf("error reading archived file %s: %v", f.Path, err)
+		}
+		return nil
+	}
+
+	scanner := bufio.NewScanner(io.LimitReader(file, f.Bytes))
+	scanner.Buffer(make([]byte, 64*1024), maxRowSize)
+	for scanner.Scan() {
+		done, err := fn(scanner.Bytes())
+		if err != nil || done {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading archived file %s: %v", f.Path, err)
+	}
+
+	return nil
+}
+
+// readIndex reads the entries of an index shard, which may not exist yet.
+func readIndex(path string) ([]txIndexEntry, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening transaction index: %v", err)
+	}
+	defer file.Close()
+
+	var entries []txIndexEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry txIndexEntry
+		// Skip a line left partially written by a crash
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading transaction index: %v", err)
+	}
+
+	return entries, nil
+}
+
+// FallbackStore is a store whose block and transaction lookups fall back to the archive for the data the
+// store no longer holds.
+type FallbackStore struct {
+	storage.Store
+	reader *Reader
+}
+
+// NewFallbackStore returns store with its block and transaction lookups falling back to reader.
+func NewFallbackStore(store storage.Store, reader *Reader) *FallbackStore {
+	return &FallbackStore{Store: store, reader: reader}
+}
+
+func (s *FallbackStore) GetBlockByNumber(ctx context.Context, blockNumber string) (*model.Block, error) {
+	block, err := s.Store.GetBlockByNumber(ctx, blockNumber)
+	if errors.Is(err, eth_err.ErrNotFound) {
+		return s.reader.GetBlockByNumber(blockNumber)
+	}
+
+	return block, err
+}
+
+func (s *FallbackStore) GetTransactionByHash(ctx context.Context, txHash string) (*types.Transaction, error) {
+	tx, err := s.Store.GetTransactionByHash(ctx, txHash)
+	if errors.Is(err, eth_err.ErrNotFound) {
+		return s.reader.GetTransactionByHash(txHash)
+	}
+
+	return tx, err
+}
@@ -1,8 +1,15 @@
 package client
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"ethereum-data-service/internal/config"
+	"ethereum-data-service/internal/storage"
 	"ethereum-data-service/pkg/enum"
+	"fmt"
+	"os"
+	"strings"
 
 	eth_err "ethereum-data-service/pkg/err"
 
@@ -13,16 +20,12 @@ import (
 type Client struct {
 	ETH_HTTPS *ethclient.Client
 	ETH_WSS   *ethclient.Client
-	REDIS     *redis.Client
+	REDIS     redis.UniversalClient // REDIS is nil when the services do not need Redis, see `Config.NeedsRedis`.
+	STORE     storage.Store
 }
 
 // InitClient initializes and returns all clients
-func InitClient() (*Client, error) {
-	cfg, err := config.LoadConfig()
-	if err != nil {
-		return nil, err
-	}
-
+func InitClient(cfg *config.Config) (*Client, error) {
 	httpsETHClient, err := newETHClient(cfg, enum.HTTPS)
 	if err != nil {
 		return nil, err
@@ -33,7 +36,18 @@ func InitClient() (*Client, error) {
 		return nil, err
 	}
 
-	rdb, err := newRedisClient(cfg)
+	// Single-process deployments on the embedded backend run without Redis
+	var rdb redis.UniversalClient
+	if cfg.NeedsRedis() {
+		if err := cfg.CheckRedis(); err != nil {
+			return nil, err
+		}
+		if rdb, err = newRedisClient(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	store, err := storage.New(context.Background(), cfg, rdb)
 	if err != nil {
 		return nil, err
 	}
@@ -42,6 +56,7 @@ func InitClient() (*Client, error) {
 		ETH_HTTPS: httpsETHClient,
 		ETH_WSS:   wssETHClient,
 		REDIS:     rdb,
+		STORE:     store,
 	}, nil
 }
 
@@ -66,12 +81,77 @@ func newETHClient(cfg *config.Config, protocol enum.Protocol) (*ethclient.Client
 	return client, nil
 }
 
-// newRedisClient initializes and returns a new Redis client
-func newRedisClient(cfg *config.Config) (*redis.Client, error) {
-	rdb := redis.NewClient(&redis.Options{
-		Addr: cfg.REDIS_ADDR, // Redis server address
-		DB:   cfg.REDIS_DB,   // Use default DB
-	})
+// newRedisClient initializes and returns a new Redis client for the deployment selected by `REDIS_MODE`: a single
+// server, a master found through Sentinel, or a Redis Cluster.
+func newRedisClient(cfg *config.Config) (redis.UniversalClient, error) {
+	opts := &redis.UniversalOptions{
+		Addrs:            strings.Split(cfg.REDIS_ADDR, ","), // Redis server, Sentinel or seed node addresses
+		DB:               cfg.REDIS_DB,                       // Use default DB
+		Username:         cfg.REDIS_USERNAME,
+		Password:         cfg.REDIS_PASSWORD,
+		SentinelUsername: cfg.REDIS_SENTINEL_USERNAME,
+		SentinelPassword: cfg.REDIS_SENTINEL_PASSWORD,
+	}
+	for i, addr := range opts.Addrs {
+		opts.Addrs[i] = strings.TrimSpace(addr)
+	}
+
+	if cfg.REDIS_TLS {
+		tlsConfig, err := newRedisTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		opts.TLSConfig = tlsConfig
+	}
+
+	switch enum.RedisMode(cfg.REDIS_MODE) {
+	case enum.STANDALONE:
+		if len(opts.Addrs) > 1 {
+			return nil, fmt.Errorf("error configuring Redis: REDIS_ADDR lists %d addresses in standalone mode", len(opts.Addrs))
+		}
+		return redis.NewClient(opts.Simple()), nil
+	case enum.SENTINEL:
+		if cfg.REDIS_MASTER_NAME == "" {
+			return nil, eth_err.ConfigKeyMissingError("REDIS_MASTER_NAME")
+		}
+		opts.MasterName = cfg.REDIS_MASTER_NAME
+		return redis.NewUniversalClient(opts), nil
+	case enum.CLUSTER:
+		if cfg.REDIS_DB != 0 {
+			return nil, fmt.Errorf("error configuring Redis: REDIS_DB must be 0 in cluster mode, got %d", cfg.REDIS_DB)
+		}
+		// The universal client only picks a cluster client for several addresses, a single seed node being enough
+		return redis.NewClusterClient(opts.Cluster()), nil
+	default:
+		return nil, eth_err.ErrInvalidRedisMode
+	}
+}
+
+// newRedisTLSConfig returns the TLS configuration of the Redis connections.
+func newRedisTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: cfg.REDIS_TLS_SERVER_NAME,
+	}
+
+	if cfg.REDIS_TLS_CA_CERT != "" {
+		caCert, err := os.ReadFile(cfg.REDIS_TLS_CA_CERT)
+		if err != nil {
+			return nil, fmt.Errorf("error reading Redis CA certificate: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("error parsing Redis CA certificate %s", cfg.REDIS_TLS_CA_CERT)
+		}
+	}
+
+	if cfg.REDIS_TLS_CERT != "" || cfg.REDIS_TLS_KEY != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.REDIS_TLS_CERT, cfg.REDIS_TLS_KEY)
+		if err != nil {
+			return nil, fmt.Errorf("error loading Redis client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
 
-	return rdb, nil
+	return tlsConfig, nil
 }
@@ -0,0 +1,144 @@
+package dlq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"ethereum-data-service/internal/client"
+	"ethereum-data-service/internal/storage"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	eth_err "ethereum-data-service/pkg/err"
+)
+
+const (
+	// ENTRIES_KEY is the Redis hash holding the dead-lettered entries by ID.
+	ENTRIES_KEY = "dlq:entries"
+	// SEQ_KEY is the counter the entry IDs are drawn from.
+	SEQ_KEY = "dlq:seq"
+
+	// SOURCE_PUB marks a block the notifier failed to fetch, format or publish.
+	SOURCE_PUB = "pub"
+	// SOURCE_SUB marks a payload the subscriber failed to validate or store.
+	SOURCE_SUB = "sub"
+)
+
+// ErrEntryNotFound is returned when no entry exists for the given ID.
+var ErrEntryNotFound = errors.New("dead-letter entry not found")
+
+// Entry is a block which could not be processed after all automatic retries.
+type Entry struct {
+	ID          string `json:"id"`                // ID identifies the entry within the dead-letter store.
+	Source      string `json:"source"`            // Source is the service which dead-lettered the block (`pub` or `sub`).
+	BlockNumber uint64 `json:"block_number"`      // BlockNumber is the number of the failed block, if known.
+	Payload     []byte `json:"payload,omitempty"` // Payload is the raw message received by the subscriber. Empty for `pub` entries.
+	Error       string `json:"error"`             // Error is the last error encountered.
+	Attempts    int    `json:"attempts"`          // Attempts is the number of processing attempts so far, replays included.
+	Timestamp   int64  `json:"timestamp"`         // Timestamp is the time (Unix milliseconds) the entry was last updated.
+}
+
+// Open returns the dead-letter store: the storage backend when it keeps the dead-letter queue itself, whether or
+// not Redis is configured, so that every command finds the entries at the same place, else Redis. It returns an
+// error when there is neither, so that the services refuse to start rather than lose the blocks which fail every
+// retry.
+func Open(cli *client.Client) (storage.DeadLetters, error) {
+	if dl, ok := cli.STORE.(storage.DeadLetters); ok {
+		return dl, nil
+	}
+	if cli.REDIS != nil {
+		return NewRedisStore(cli.REDIS), nil
+	}
+	return nil, errors.New("no dead-letter store: the storage backend cannot keep the dead-letter queue and Redis is not configured")
+}
+
+// Add assigns an ID to the entry, stamps it and stores it in the dead-letter store.
+func Add(ctx context.Context, dl storage.DeadLetters, entry *Entry) error {
+	seq, err := dl.NextDeadLetterID(ctx)
+	if err != nil {
+		return fmt.Errorf("error allocating dead-letter entry ID: %v", err)
+	}
+
+	entry.ID = strconv.FormatInt(seq, 10)
+	return Put(ctx, dl, entry)
+}
+
+// Put stores the entry under its ID, overwriting any previous version of it.
+func Put(ctx context.Context, dl storage.DeadLetters, entry *Entry) error {
+	entry.Timestamp = time.Now().UnixMilli()
+
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error marshalling dead-letter entry: %v", err)
+	}
+
+	if err := dl.PutDeadLetter(ctx, entry.ID, entryJSON); err != nil {
+		return fmt.Errorf("error storing dead-letter entry %s: %v", entry.ID, err)
+	}
+	return nil
+}
+
+// Get returns the entry with the given ID.
+func Get(ctx context.Context, dl storage.DeadLetters, id string) (*Entry, error) {
+	entryJSON, err := dl.GetDeadLetter(ctx, id)
+	if errors.Is(err, eth_err.ErrNotFound) {
+		return nil, ErrEntryNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching dead-letter entry %s: %v", id, err)
+	}
+
+	var entry Entry
+	if err := json.Unmarshal(entryJSON, &entry); err != nil {
+		return nil, fmt.Errorf("error unmarshalling dead-letter entry %s: %v", id, err)
+	}
+	return &entry, nil
+}
+
+// List returns all entries, oldest first.
+func List(ctx context.Context, dl storage.DeadLetters) ([]*Entry, error) {
+	values, err := dl.ListDeadLetters(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching dead-letter entries: %v", err)
+	}
+
+	entries := make([]*Entry, 0, len(values))
+	for id, entryJSON := range values {
+		var entry Entry
+		if err := json.Unmarshal(entryJSON, &entry); err != nil {
+			return nil, fmt.Errorf("error unmarshalling dead-letter entry %s: %v", id, err)
+		}
+		entries = append(entries, &entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, _ := strconv.ParseInt(entries[i].ID, 10, 64)
+		b, _ := strconv.ParseInt(entries[j].ID, 10, 64)
+		return a < b
+	})
+
+	return entries, nil
+}
+
+// Remove deletes the entry with the given ID.
+func Remove(ctx context.Context, dl storage.DeadLetters, id string) error {
+	err := dl.RemoveDeadLetter(ctx, id)
+	if errors.Is(err, eth_err.ErrNotFound) {
+		return ErrEntryNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("error removing dead-letter entry %s: %v", id, err)
+	}
+	return nil
+}
+
+// Purge deletes all entries and returns how many there were.
+func Purge(ctx context.Context, dl storage.DeadLetters) (int64, error) {
+	n, err := dl.PurgeDeadLetters(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error purging dead-letter entries: %v", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,69 @@
+package dlq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	eth_err "ethereum-data-service/pkg/err"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore keeps the dead-letter queue in Redis, the entries in the `dlq:entries` hash and their IDs drawn from
+// the `dlq:seq` counter.
+type RedisStore struct {
+	rdb redis.UniversalClient
+}
+
+func NewRedisStore(rdb redis.UniversalClient) *RedisStore {
+	return &RedisStore{rdb: rdb}
+}
+
+func (s *RedisStore) NextDeadLetterID(ctx context.Context) (int64, error) {
+	return s.rdb.Incr(ctx, SEQ_KEY).Result()
+}
+
+func (s *RedisStore) PutDeadLetter(ctx context.Context, id string, entry []byte) error {
+	return s.rdb.HSet(ctx, ENTRIES_KEY, id, entry).Err()
+}
+
+func (s *RedisStore) GetDeadLetter(ctx context.Context, id string) ([]byte, error) {
+	entry, err := s.rdb.HGet(ctx, ENTRIES_KEY, id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("dead-letter entry %s: %w", id, eth_err.ErrNotFound)
+	}
+	return entry, err
+}
+
+func (s *RedisStore) ListDeadLetters(ctx context.Context) (map[string][]byte, error) {
+	values, err := s.rdb.HGetAll(ctx, ENTRIES_KEY).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string][]byte, len(values))
+	for id, entry := range values {
+		entries[id] = []byte(entry)
+	}
+	return entries, nil
+}
+
+func (s *RedisStore) RemoveDeadLetter(ctx context.Context, id string) error {
+	n, err := s.rdb.HDel(ctx, ENTRIES_KEY, id).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("dead-letter entry %s: %w", id, eth_err.ErrNotFound)
+	}
+	return nil
+}
+
+func (s *RedisStore) PurgeDeadLetters(ctx context.Context) (int64, error) {
+	n, err := s.rdb.HLen(ctx, ENTRIES_KEY).Result()
+	if err != nil {
+		return 0, err
+	}
+	return n, s.rdb.Del(ctx, ENTRIES_KEY).Err()
+}
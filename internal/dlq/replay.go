@@ -0,0 +1,60 @@
+package dlq
+
+import (
+	"context"
+	"ethereum-data-service/internal/client"
+	"ethereum-data-service/internal/config"
+	"ethereum-data-service/internal/model"
+	"ethereum-data-service/internal/storage"
+	"fmt"
+	"math/big"
+)
+
+// Replay reprocesses a dead-lettered entry and removes it from the dead-letter store dl once it has been stored
+// successfully. Payloads dead-lettered by the subscriber are validated and stored again, while blocks dead-lettered
+// by the notifier are re-fetched over HTTPS and stored directly. A failed replay updates the entry's error and attempts.
+func Replay(ctx context.Context, cli *client.Client, dl storage.DeadLetters, cfg *config.Config, entry *Entry) error {
+	if err := replay(ctx, cli, cfg, entry); err != nil {
+		entry.Attempts++
+		entry.Error = err.Error()
+		if putErr := Put(ctx, dl, entry); putErr != nil {
+			return fmt.Errorf("%v (and %v)", err, putErr)
+		}
+		return err
+	}
+
+	return Remove(ctx, dl, entry.ID)
+}
+
+func replay(ctx context.Context, cli *client.Client, cfg *config.Config, entry *Entry) error {
+	var blockData *model.Data
+
+	switch entry.Source {
+	case SOURCE_SUB:
+		env, data, err := model.OpenEnvelope(entry.Payload)
+		if err != nil {
+			return err
+		}
+		if err := env.CheckChain(cfg.CHAIN_ID); err != nil {
+			return err
+		}
+		blockData = data
+
+	case SOURCE_PUB:
+		block, err := cli.ETH_HTTPS.BlockByNumber(ctx, new(big.Int).SetUint64(entry.BlockNumber))
+		if err != nil {
+			return err
+		}
+
+		blockData, err = model.NewBlockData(cli.ETH_HTTPS, block, cfg.INGEST_DETAIL)
+		if err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("unknown dead-letter source %q", entry.Source)
+	}
+
+	_, err := cli.STORE.ApplyBlockData(ctx, blockData)
+	return err
+}
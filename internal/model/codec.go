@@ -0,0 +1,421 @@
+package model
+
+import (
+	"encoding/json"
+	"ethereum-data-service/pkg/enum"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// ENCODING_JSON marks a payload serialized with encoding/json.
+	ENCODING_JSON = "json"
+	// ENCODING_RLP marks a payload serialized with Ethereum's RLP.
+	ENCODING_RLP = "rlp"
+
+	// COMPRESSION_NONE, COMPRESSION_SNAPPY and COMPRESSION_ZSTD are the supported payload compressions.
+	COMPRESSION_NONE   = "none"
+	COMPRESSION_SNAPPY = "snappy"
+	COMPRESSION_ZSTD   = "zstd"
+
+	// frameMagic starts every value written by a non-default codec. Plain JSON values (the original layout)
+	// start with `{` and carry no frame, which lets readers tell both apart.
+	frameMagic byte = 0xe7
+	// frameHeaderLen is the length of the frame header: magic, encoding ID and compression ID.
+	frameHeaderLen = 3
+)
+
+var (
+	encodingIDs    = map[string]byte{ENCODING_JSON: 0, ENCODING_RLP: 1}
+	compressionIDs = map[string]byte{COMPRESSION_NONE: 0, COMPRESSION_SNAPPY: 1, COMPRESSION_ZSTD: 2}
+
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// Codec describes how block data is serialized and compressed, on the transport as well as in storage.
+// Values written by any codec can be read back by any other, since non-default codecs prefix their
+// output with a small frame naming the encoding and compression used.
+type Codec struct {
+	Encoding    string // Encoding is `json` or `rlp`.
+	Compression string // Compression is `none`, `snappy` or `zstd`.
+}
+
+// ParseCodec validates the encoding and compression names and returns the matching codec.
+func ParseCodec(encoding, compression string) (Codec, error) {
+	if _, ok := encodingIDs[encoding]; !ok {
+		return Codec{}, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+	if _, ok := compressionIDs[compression]; !ok {
+		return Codec{}, fmt.Errorf("unsupported compression %q", compression)
+	}
+	return Codec{Encoding: encoding, Compression: compression}, nil
+}
+
+// ParseCodecName parses a name as returned by Codec.String, for ex. `rlp+zstd` or `json`.
+func ParseCodecName(name string) (Codec, error) {
+	encoding, compression, found := strings.Cut(name, "+")
+	if !found {
+		compression = COMPRESSION_NONE
+	}
+	return ParseCodec(encoding, compression)
+}
+
+// String returns the codec name, for ex. `rlp+zstd`. The compression is omitted when there is none.
+func (c Codec) String() string {
+	if c.Compression == COMPRESSION_NONE {
+		return c.Encoding
+	}
+	return c.Encoding + "+" + c.Compression
+}
+
+// rlpData is the RLP layout of Data. Transactions are stored once in the block body, the transaction hash
+// map being rebuilt from it on decoding.
+type rlpData struct {
+	Block  rlpBlock
+	Events []rlpTxEvents
+}
+
+// rlpBlock is the RLP layout of Block. The fields added along with the detail levels are optional, so that blocks
+// written before them still decode.
+type rlpBlock struct {
+	Header   *types.Header
+	Body     *types.Body      `rlp:"nil"`
+	Detail   enum.DetailLevel `rlp:"optional"`
+	Filtered bool             `rlp:"optional"`
+	TxHashes []common.Hash    `rlp:"optional"`
+	Receipts []*Receipt       `rlp:"optional"`
+	Traces   []*Trace         `rlp:"optional"`
+}
+
+func toRLPBlock(block *Block) rlpBlock {
+	return rlpBlock{
+		Header:   block.Header,
+		Body:     block.Body,
+		Detail:   block.Detail,
+		Filtered: block.Filtered,
+		TxHashes: block.TxHashes,
+		Receipts: block.Receipts,
+		Traces:   block.Traces,
+	}
+}
+
+func fromRLPBlock(dec *rlpBlock) Block {
+	return Block{
+		Header:   dec.Header,
+		Body:     dec.Body,
+		Detail:   dec.Detail,
+		Filtered: dec.Filtered,
+		TxHashes: dec.TxHashes,
+		Receipts: dec.Receipts,
+		Traces:   dec.Traces,
+	}
+}
+
+type rlpTxEvents struct {
+	TxHash common.Hash
+	Logs   []*rlpLog
+}
+
+// rlpLog carries every field of types.Log, including the derived ones which its own RLP encoding leaves out.
+type rlpLog struct {
+	Address     common.Address
+	Topics      []common.Hash
+	Data        []byte
+	BlockNumber uint64
+	TxHash      common.Hash
+	TxIndex     uint
+	BlockHash   common.Hash
+	Index       uint
+	Removed     bool
+}
+
+// MarshalData serializes the block data of a whole block.
+func (c Codec) MarshalData(data *Data) ([]byte, error) {
+	if c.Encoding == ENCODING_JSON {
+		return c.marshalJSON(data)
+	}
+
+	enc := rlpData{Block: toRLPBlock(&data.Block)}
+	for txHash, logs := range data.Events {
+		txEvents := rlpTxEvents{TxHash: common.HexToHash(txHash)}
+		for _, log := range logs {
+			txEvents.Logs = append(txEvents.Logs, toRLPLog(log))
+		}
+		enc.Events = append(enc.Events, txEvents)
+	}
+
+	return c.marshalRLP(&enc)
+}
+
+// UnmarshalData deserializes block data written by any codec.
+func UnmarshalData(value []byte) (*Data, error) {
+	codec, body, err := unframe(value)
+	if err != nil {
+		return nil, err
+	}
+	return decodeData(codec.Encoding, body)
+}
+
+// UnmarshalData deserializes block data, rejecting a value written by another codec.
+func (c Codec) UnmarshalData(value []byte) (*Data, error) {
+	codec, body, err := unframe(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if codec != c {
+		return nil, fmt.Errorf("value is encoded with %s, expected %s", codec, c)
+	}
+	return decodeData(codec.Encoding, body)
+}
+
+// decodeData deserializes the decompressed body of a block data value.
+func decodeData(encoding string, body []byte) (*Data, error) {
+	var data Data
+	if encoding == ENCODING_JSON {
+		if err := json.Unmarshal(body, &data); err != nil {
+			return nil, err
+		}
+		return &data, nil
+	}
+
+	var dec rlpData
+	if err := rlp.DecodeBytes(body, &dec); err != nil {
+		return nil, err
+	}
+
+	data.Block = fromRLPBlock(&dec.Block)
+	data.TransactionHashes = make(map[string]*types.Transaction)
+	data.Events = make(map[string][]*types.Log)
+	if data.Block.Body != nil {
+		for _, tx := range data.Block.Body.Transactions {
+			data.TransactionHashes[tx.Hash().Hex()] = tx
+		}
+	}
+	for _, txEvents := range dec.Events {
+		logs := make([]*types.Log, len(txEvents.Logs))
+		for i, log := range txEvents.Logs {
+			logs[i] = fromRLPLog(log)
+		}
+		data.Events[txEvents.TxHash.Hex()] = logs
+	}
+
+	return &data, nil
+}
+
+// MarshalBlock serializes a block (header and body).
+func (c Codec) MarshalBlock(block *Block) ([]byte, error) {
+	if c.Encoding == ENCODING_JSON {
+		return c.marshalJSON(block)
+	}
+	enc := toRLPBlock(block)
+	return c.marshalRLP(&enc)
+}
+
+// UnmarshalBlock deserializes a block written by any codec.
+func UnmarshalBlock(value []byte) (*Block, error) {
+	codec, body, err := unframe(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var block Block
+	if codec.Encoding == ENCODING_JSON {
+		if err := json.Unmarshal(body, &block); err != nil {
+			return nil, err
+		}
+		return &block, nil
+	}
+
+	var dec rlpBlock
+	if err := rlp.DecodeBytes(body, &dec); err != nil {
+		return nil, err
+	}
+	block = fromRLPBlock(&dec)
+	return &block, nil
+}
+
+// MarshalTx serializes a transaction. RLP uses the canonical typed transaction encoding.
+func (c Codec) MarshalTx(tx *types.Transaction) ([]byte, error) {
+	if c.Encoding == ENCODING_JSON {
+		return c.marshalJSON(tx)
+	}
+
+	body, err := tx.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return c.frame(body)
+}
+
+// UnmarshalTx deserializes a transaction written by any codec.
+func UnmarshalTx(value []byte) (*types.Transaction, error) {
+	codec, body, err := unframe(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var tx types.Transaction
+	if codec.Encoding == ENCODING_JSON {
+		err = json.Unmarshal(body, &tx)
+	} else {
+		err = tx.UnmarshalBinary(body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+// MarshalLog serializes an event log.
+func (c Codec) MarshalLog(log *types.Log) ([]byte, error) {
+	if c.Encoding == ENCODING_JSON {
+		return c.marshalJSON(log)
+	}
+	return c.marshalRLP(toRLPLog(log))
+}
+
+// UnmarshalLog deserializes an event log written by any codec.
+func UnmarshalLog(value []byte) (*types.Log, error) {
+	codec, body, err := unframe(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if codec.Encoding == ENCODING_JSON {
+		var log types.Log
+		if err := json.Unmarshal(body, &log); err != nil {
+			return nil, err
+		}
+		return &log, nil
+	}
+
+	var dec rlpLog
+	if err := rlp.DecodeBytes(body, &dec); err != nil {
+		return nil, err
+	}
+	return fromRLPLog(&dec), nil
+}
+
+func (c Codec) marshalJSON(v interface{}) ([]byte, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	// Uncompressed JSON is written as is, which keeps the original storage layout readable by older builds
+	if c.Compression == COMPRESSION_NONE {
+		return body, nil
+	}
+	return c.frame(body)
+}
+
+func (c Codec) marshalRLP(v interface{}) ([]byte, error) {
+	body, err := rlp.EncodeToBytes(v)
+	if err != nil {
+		return nil, err
+	}
+	return c.frame(body)
+}
+
+// frame compresses the serialized body and prefixes it with the frame header.
+func (c Codec) frame(body []byte) ([]byte, error) {
+	var compressed []byte
+	switch c.Compression {
+	case COMPRESSION_NONE:
+		compressed = body
+	case COMPRESSION_SNAPPY:
+		compressed = snappy.Encode(nil, body)
+	case COMPRESSION_ZSTD:
+		compressed = zstdEncoder.EncodeAll(body, nil)
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", c.Compression)
+	}
+
+	framed := make([]byte, 0, frameHeaderLen+len(compressed))
+	framed = append(framed, frameMagic, encodingIDs[c.Encoding], compressionIDs[c.Compression])
+	return append(framed, compressed...), nil
+}
+
+// unframe returns the codec and the decompressed body of a value. Values without a frame are plain JSON.
+func unframe(value []byte) (Codec, []byte, error) {
+	if len(value) == 0 || value[0] != frameMagic {
+		return Codec{Encoding: ENCODING_JSON, Compression: COMPRESSION_NONE}, value, nil
+	}
+
+	if len(value) < frameHeaderLen {
+		return Codec{}, nil, fmt.Errorf("truncated value frame")
+	}
+
+	encoding, ok := nameOf(encodingIDs, value[1])
+	if !ok {
+		return Codec{}, nil, fmt.Errorf("unknown encoding ID %d", value[1])
+	}
+
+	compression, ok := nameOf(compressionIDs, value[2])
+	if !ok {
+		return Codec{}, nil, fmt.Errorf("unknown compression ID %d", value[2])
+	}
+
+	body := value[frameHeaderLen:]
+	switch compression {
+	case COMPRESSION_SNAPPY:
+		decoded, err := snappy.Decode(nil, body)
+		if err != nil {
+			return Codec{}, nil, fmt.Errorf("error decompressing snappy value: %v", err)
+		}
+		body = decoded
+	case COMPRESSION_ZSTD:
+		decoded, err := zstdDecoder.DecodeAll(body, nil)
+		if err != nil {
+			return Codec{}, nil, fmt.Errorf("error decompressing zstd value: %v", err)
+		}
+		body = decoded
+	}
+
+	return Codec{Encoding: encoding, Compression: compression}, body, nil
+}
+
+func nameOf(ids map[string]byte, id byte) (string, bool) {
+	for name, v := range ids {
+		if v == id {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func toRLPLog(log *types.Log) *rlpLog {
+	return &rlpLog{
+		Address:     log.Address,
+		Topics:      log.Topics,
+		Data:        log.Data,
+		BlockNumber: log.BlockNumber,
+		TxHash:      log.TxHash,
+		TxIndex:     log.TxIndex,
+		BlockHash:   log.BlockHash,
+		Index:       log.Index,
+		Removed:     log.Removed,
+	}
+}
+
+func fromRLPLog(log *rlpLog) *types.Log {
+	return &types.Log{
+		Address:     log.Address,
+		Topics:      log.Topics,
+		Data:        log.Data,
+		BlockNumber: log.BlockNumber,
+		TxHash:      log.TxHash,
+		TxIndex:     log.TxIndex,
+		BlockHash:   log.BlockHash,
+		Index:       log.Index,
+		Removed:     log.Removed,
+	}
+}
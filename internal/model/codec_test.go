@@ -0,0 +1,189 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"ethereum-data-service/pkg/enum"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var testCodecs = []Codec{
+	{Encoding: ENCODING_JSON, Compression: COMPRESSION_NONE},
+	{Encoding: ENCODING_JSON, Compression: COMPRESSION_SNAPPY},
+	{Encoding: ENCODING_RLP, Compression: COMPRESSION_NONE},
+	{Encoding: ENCODING_RLP, Compression: COMPRESSION_SNAPPY},
+	{Encoding: ENCODING_RLP, Compression: COMPRESSION_ZSTD},
+}
+
+// testCodecBlocks returns block data covering the header and block fields which some blocks leave out.
+func testCodecBlocks() map[string]*Data {
+	traced := testBlockData(44, 2, true)
+	traced.Block.Detail = enum.TRACES
+	traced.Block.Filtered = true
+	for _, tx := range traced.Block.Body.Transactions {
+		traced.Block.Receipts = append(traced.Block.Receipts, &Receipt{TxHash: tx.Hash(), Status: 1, GasUsed: 21000, EffectiveGasPrice: big.NewInt(1e9)})
+		traced.Block.Traces = append(traced.Block.Traces, &Trace{TxHash: tx.Hash(), Result: json.RawMessage(`{"type":"CALL"}`)})
+	}
+
+	hashes := testBlockData(45, 0, true)
+	hashes.Block.Detail = enum.TX_HASHES
+	hashes.Block.Body = nil
+	hashes.Block.TxHashes = []common.Hash{common.HexToHash("0x0a"), common.HexToHash("0x0b")}
+
+	return map[string]*Data{
+		"without optional header fields": testBlockData(42, 2, false),
+		"with optional header fields":    testBlockData(43, 2, true),
+		"traces detail level":            traced,
+		"tx_hashes detail level":         hashes,
+		"empty block":                    testBlockData(46, 0, false),
+	}
+}
+
+// mustJSON marshals v to JSON, which compares block data field by field, transactions and events included.
+func mustJSON(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestCodecDataRoundTrip(t *testing.T) {
+	for _, codec := range testCodecs {
+		for name, data := range testCodecBlocks() {
+			t.Run(codec.String()+"/"+name, func(t *testing.T) {
+				value, err := codec.MarshalData(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				decoded, err := UnmarshalData(value)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if decoded.Block.Header.Hash() != data.Block.Header.Hash() {
+					t.Errorf("decoded header %s, want %s", decoded.Block.Header.Hash(), data.Block.Header.Hash())
+				}
+				if got, want := mustJSON(t, decoded), mustJSON(t, data); !bytes.Equal(got, want) {
+					t.Errorf("decoded data differs:\ngot  %s\nwant %s", got, want)
+				}
+
+				if _, err := codec.UnmarshalData(value); err != nil {
+					t.Errorf("UnmarshalData with the writing codec: %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestCodecUnmarshalDataRejectsOtherCodec(t *testing.T) {
+	data := testBlockData(42, 1, true)
+	for _, codec := range testCodecs {
+		value, err := codec.MarshalData(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, other := range testCodecs {
+			if other == codec {
+				continue
+			}
+			if _, err := other.UnmarshalData(value); err == nil {
+				t.Errorf("%s decoded a value written with %s", other, codec)
+			}
+		}
+	}
+}
+
+func TestCodecBlockTxLogRoundTrip(t *testing.T) {
+	data := testBlockData(43, 1, true)
+	tx := data.Block.Body.Transactions[0]
+	log := data.Events[tx.Hash().Hex()][0]
+
+	for _, codec := range testCodecs {
+		t.Run(codec.String(), func(t *testing.T) {
+			value, err := codec.MarshalBlock(&data.Block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			block, err := UnmarshalBlock(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := mustJSON(t, block), mustJSON(t, &data.Block); !bytes.Equal(got, want) {
+				t.Errorf("decoded block differs:\ngot  %s\nwant %s", got, want)
+			}
+
+			if value, err = codec.MarshalTx(tx); err != nil {
+				t.Fatal(err)
+			}
+			decodedTx, err := UnmarshalTx(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decodedTx.Hash() != tx.Hash() {
+				t.Errorf("decoded transaction %s, want %s", decodedTx.Hash(), tx.Hash())
+			}
+
+			if value, err = codec.MarshalLog(log); err != nil {
+				t.Fatal(err)
+			}
+			decodedLog, err := UnmarshalLog(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := mustJSON(t, decodedLog), mustJSON(t, log); !bytes.Equal(got, want) {
+				t.Errorf("decoded log differs:\ngot  %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+func TestCodecDecodesLegacyJSON(t *testing.T) {
+	// Values written before codecs existed are unframed encoding/json output
+	data := testBlockData(42, 2, false)
+	legacy := mustJSON(t, data)
+
+	decoded, err := UnmarshalData(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mustJSON(t, decoded); !bytes.Equal(got, legacy) {
+		t.Errorf("decoded legacy data differs:\ngot  %s\nwant %s", got, legacy)
+	}
+
+	block, err := UnmarshalBlock(mustJSON(t, &data.Block))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := mustJSON(t, block), mustJSON(t, &data.Block); !bytes.Equal(got, want) {
+		t.Errorf("decoded legacy block differs:\ngot  %s\nwant %s", got, want)
+	}
+
+	// Uncompressed JSON is still written unframed
+	value, err := Codec{Encoding: ENCODING_JSON, Compression: COMPRESSION_NONE}.MarshalData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, legacy) {
+		t.Error("uncompressed JSON is not written in the legacy layout")
+	}
+}
+
+func TestParseCodecName(t *testing.T) {
+	for _, codec := range testCodecs {
+		parsed, err := ParseCodecName(codec.String())
+		if err != nil || parsed != codec {
+			t.Errorf("ParseCodecName(%q) = %v, %v", codec.String(), parsed, err)
+		}
+	}
+
+	for _, name := range []string{"", "protobuf", "rlp+gzip", "json+"} {
+		if _, err := ParseCodecName(name); err == nil {
+			t.Errorf("ParseCodecName(%q) accepted an unknown codec", name)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package model
+
+import (
+	"encoding/json"
+	"ethereum-data-service/pkg/enum"
+	"fmt"
+	"math/big"
+	"strings"
+
+	eth_err "ethereum-data-service/pkg/err"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// DETAIL_LEVELS lists the ingestion detail levels, from the least to the most detailed. Each level holds
+// everything the previous ones do:
+//   - `headers`: the block header only.
+//   - `tx_hashes`: the hashes of the transactions as well.
+//   - `full`: the block body, the transactions and their events.
+//   - `traces`: the transaction receipts and call traces as well.
+var DETAIL_LEVELS = []enum.DetailLevel{enum.HEADERS, enum.TX_HASHES, enum.FULL, enum.TRACES}
+
+// Detail selects what of every block is ingested: the detail level, and the allowlists limiting the
+// transactions and events ingested at the `full` and `traces` levels. An empty allowlist lets everything
+// through. The zero Detail ingests everything at the `full` level.
+type Detail struct {
+	Level     enum.DetailLevel
+	Addresses map[common.Address]bool // Addresses allowlists the contracts whose events are kept, and the senders and recipients of the transactions kept.
+	Topics    map[common.Hash]bool    // Topics allowlists the event signatures (first topic) of the events kept.
+}
+
+// Receipt is the outcome of a transaction as per its receipt, without its logs, which are stored as events.
+type Receipt struct {
+	TxHash            common.Hash    `json:"transactionHash"`
+	Type              uint8          `json:"type"`
+	Status            uint64         `json:"status"`
+	CumulativeGasUsed uint64         `json:"cumulativeGasUsed"`
+	GasUsed           uint64         `json:"gasUsed"`
+	EffectiveGasPrice *big.Int       `json:"effectiveGasPrice"`
+	ContractAddress   common.Address `json:"contractAddress"`
+}
+
+// Trace is the call trace of a transaction, as returned by the `callTracer` of the node.
+type Trace struct {
+	TxHash common.Hash     `json:"txHash"`
+	Result json.RawMessage `json:"result"`
+}
+
+// ParseDetail parses a detail level and the comma-separated address and topic allowlists. An empty level
+// is `full`.
+func ParseDetail(level, addresses, topics string) (Detail, error) {
+	detail := Detail{Level: enum.DetailLevel(strings.ToLower(level))}
+	if detail.Level == "" {
+		detail.Level = enum.FULL
+	}
+	if rank(detail.Level) < 0 {
+		return Detail{}, fmt.Errorf("%w: %s", eth_err.ErrInvalidDetailLevel, level)
+	}
+
+	for _, address := range splitList(addresses) {
+		if !common.IsHexAddress(address) {
+			return Detail{}, fmt.Errorf("invalid allowlisted address %q", address)
+		}
+		if detail.Addresses == nil {
+			detail.Addresses = make(map[common.Address]bool)
+		}
+		detail.Addresses[common.HexToAddress(address)] = true
+	}
+
+	for _, topic := range splitList(topics) {
+		if len(topic) != 2+2*common.HashLength || !strings.HasPrefix(topic, "0x") {
+			return Detail{}, fmt.Errorf("invalid allowlisted topic %q", topic)
+		}
+		if detail.Topics == nil {
+			detail.Topics = make(map[common.Hash]bool)
+		}
+		detail.Topics[common.HexToHash(topic)] = true
+	}
+
+	if detail.Filters() && !detail.Includes(enum.FULL) {
+		return Detail{}, fmt.Errorf("allowlists only apply to the %s and %s detail levels", enum.FULL, enum.TRACES)
+	}
+
+	return detail, nil
+}
+
+// String returns the detail level, followed by the number of allowlisted addresses and topics if any.
+func (d Detail) String() string {
+	if !d.Filters() {
+		return string(d.level())
+	}
+	return fmt.Sprintf("%s (%d allowlisted addresses, %d allowlisted topics)", d.level(), len(d.Addresses), len(d.Topics))
+}
+
+// Includes reports whether what the given level ingests is ingested at this detail.
+func (d Detail) Includes(level enum.DetailLevel) bool {
+	return IncludesLevel(d.level(), level)
+}
+
+// Filters reports whether the allowlists leave transactions and events out.
+func (d Detail) Filters() bool {
+	return len(d.Addresses) > 0 || len(d.Topics) > 0
+}
+
+// AllowsAddress reports whether the events of the contract with the given address are ingested.
+func (d Detail) AllowsAddress(address common.Address) bool {
+	return len(d.Addresses) == 0 || d.Addresses[address]
+}
+
+// AllowsTopic reports whether the events with the given signature (first topic) are ingested.
+func (d Detail) AllowsTopic(topic common.Hash) bool {
+	return len(d.Topics) == 0 || d.Topics[topic]
+}
+
+// KeepsLog reports whether an event passes the allowlists.
+func (d Detail) KeepsLog(log *types.Log) bool {
+	if !d.AllowsAddress(log.Address) {
+		return false
+	}
+	return len(d.Topics) == 0 || (len(log.Topics) > 0 && d.Topics[log.Topics[0]])
+}
+
+// keepsTransaction reports whether a transaction passes the allowlists, given its events which do: it has
+// to emit one of them, or to be sent from or to an allowlisted address.
+func (d Detail) keepsTransaction(tx *types.Transaction, logs []*types.Log) bool {
+	if !d.Filters() || len(logs) > 0 {
+		return true
+	}
+	if len(d.Addresses) == 0 {
+		return false
+	}
+
+	if to := tx.To(); to != nil && d.Addresses[*to] {
+		return true
+	}
+	from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+	return err == nil && d.Addresses[from]
+}
+
+func (d Detail) level() enum.DetailLevel {
+	if d.Level == "" {
+		return enum.FULL
+	}
+	return d.Level
+}
+
+// IncludesLevel reports whether what the given level ingests is ingested at the level of a stored block, an
+// empty one being `full`, as blocks stored before detail levels existed are.
+func IncludesLevel(blockLevel, level enum.DetailLevel) bool {
+	if blockLevel == "" {
+		blockLevel = enum.FULL
+	}
+	return rank(blockLevel) >= rank(level)
+}
+
+// rank returns the position of a level in DETAIL_LEVELS, -1 if it is not a detail level.
+func rank(level enum.DetailLevel) int {
+	for i, l := range DETAIL_LEVELS {
+		if l == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// splitList splits a comma-separated list, an empty one being an empty list.
+func splitList(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
@@ -0,0 +1,238 @@
+package model
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	// ENVELOPE_VERSION is the envelope schema version written by this build. Bump it whenever the
+	// envelope or the payload layout changes in a way older subscribers cannot read. Version 1 envelopes
+	// were JSON documents carrying the payload in base64, version 2 ones are binary.
+	ENVELOPE_VERSION = 2
+
+	// CONTENT_TYPE_BLOCK_DATA marks a payload holding a single block formatted as per `model.Data`.
+	CONTENT_TYPE_BLOCK_DATA = "application/vnd.eth-data-service.block-data"
+
+	// checksumPrefix names the hash algorithm used for the payload checksum.
+	checksumPrefix = "sha256:"
+
+	// envelopeMagic starts every binary envelope. JSON envelopes and bare payloads start with `{`.
+	envelopeMagic byte = 0xe8
+	// envelopePrefixLen is the length of the fixed prefix of a binary envelope: the magic and the big-endian
+	// length of the JSON metadata which follows it, the raw payload making up the rest.
+	envelopePrefixLen = 5
+)
+
+// Envelope wraps a block payload on its way from the notifier to the subscribers. It carries enough
+// metadata for a subscriber to detect a payload written by an incompatible producer, or one which
+// got corrupted or truncated on the way. It is written as a fixed prefix, the metadata as JSON and the
+// payload as is, so that the compact codecs are not inflated by base64.
+type Envelope struct {
+	Version      int    `json:"version"`                 // Version is the envelope schema version.
+	ChainID      uint64 `json:"chain_id"`                // ChainID is the ID of the chain the block belongs to.
+	BlockNumber  uint64 `json:"block_number"`            // BlockNumber is the number of the wrapped block.
+	BlockHash    string `json:"block_hash"`              // BlockHash is the hash of the wrapped block header.
+	ProducerID   string `json:"producer_id"`             // ProducerID identifies the notifier instance which produced the envelope.
+	Timestamp    int64  `json:"timestamp"`               // Timestamp is the production time in Unix milliseconds.
+	ContentType  string `json:"content_type"`            // ContentType describes what the payload holds.
+	Encoding     string `json:"encoding"`                // Encoding is the serialization format of the payload.
+	Checksum     string `json:"checksum"`                // Checksum is the SHA-256 of the payload.
+	FencingToken int64  `json:"fencing_token,omitempty"` // FencingToken is the notifier lease token of the producer, 0 if unknown.
+	Payload      []byte `json:"payload,omitempty"`       // Payload is the serialized block data, only part of the JSON of version 1 envelopes.
+}
+
+// WrapBlockData wraps a block payload formatted with codec into an envelope and marshals the envelope into bytes.
+// fencingToken is the fencing token of the notifier lease held while producing it.
+func WrapBlockData(payload []byte, codec Codec, header *types.Header, chainID *big.Int, producerID string, fencingToken int64) ([]byte, error) {
+	env := Envelope{
+		Version:      ENVELOPE_VERSION,
+		ChainID:      chainID.Uint64(),
+		BlockNumber:  header.Number.Uint64(),
+		BlockHash:    header.Hash().Hex(),
+		ProducerID:   producerID,
+		Timestamp:    time.Now().UnixMilli(),
+		ContentType:  CONTENT_TYPE_BLOCK_DATA,
+		Encoding:     codec.String(),
+		Checksum:     checksum(payload),
+		FencingToken: fencingToken,
+	}
+
+	metadata, err := json.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make([]byte, envelopePrefixLen, envelopePrefixLen+len(metadata)+len(payload))
+	raw[0] = envelopeMagic
+	binary.BigEndian.PutUint32(raw[1:envelopePrefixLen], uint32(len(metadata)))
+	raw = append(raw, metadata...)
+	return append(raw, payload...), nil
+}
+
+// OpenEnvelope unmarshals and validates an envelope and returns it along with the block data it carries.
+// Version 1 JSON envelopes are still read, and payloads from producers which predate the envelope (a bare
+// `model.Data` JSON blob) are upgraded to the current version, while envelopes of an unknown version are
+// rejected.
+func OpenEnvelope(raw []byte) (*Envelope, *Data, error) {
+	if len(raw) > 0 && raw[0] == envelopeMagic {
+		return openBinaryEnvelope(raw)
+	}
+
+	var probe struct {
+		Version *int            `json:"version"`
+		Block   json.RawMessage `json:"block"`
+	}
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return nil, nil, fmt.Errorf("error unmarshalling envelope: %v", err)
+	}
+
+	var env Envelope
+	switch {
+	case probe.Version == nil && probe.Block != nil:
+		// Version 0: bare `model.Data` written by a notifier without envelope support
+		upgraded, err := upgradeLegacyPayload(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		env = *upgraded
+	case probe.Version == nil:
+		return nil, nil, fmt.Errorf("envelope version missing")
+	case *probe.Version > ENVELOPE_VERSION:
+		return nil, nil, fmt.Errorf("unsupported envelope version %d (max supported %d)", *probe.Version, ENVELOPE_VERSION)
+	default:
+		if err := json.Unmarshal(raw, &env); err != nil {
+			return nil, nil, fmt.Errorf("error unmarshalling envelope: %v", err)
+		}
+	}
+
+	data, err := env.validate()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &env, data, nil
+}
+
+// openBinaryEnvelope reads an envelope written by WrapBlockData.
+func openBinaryEnvelope(raw []byte) (*Envelope, *Data, error) {
+	if len(raw) < envelopePrefixLen {
+		return nil, nil, fmt.Errorf("error unmarshalling envelope: truncated prefix")
+	}
+	metadataLen := uint64(binary.BigEndian.Uint32(raw[1:envelopePrefixLen]))
+	if uint64(len(raw)-envelopePrefixLen) < metadataLen {
+		return nil, nil, fmt.Errorf("error unmarshalling envelope: truncated metadata")
+	}
+
+	var env Envelope
+	if err := json.Unmarshal(raw[envelopePrefixLen:envelopePrefixLen+metadataLen], &env); err != nil {
+		return nil, nil, fmt.Errorf("error unmarshalling envelope: %v", err)
+	}
+	switch {
+	case env.Version == 0:
+		return nil, nil, fmt.Errorf("envelope version missing")
+	case env.Version > ENVELOPE_VERSION:
+		return nil, nil, fmt.Errorf("unsupported envelope version %d (max supported %d)", env.Version, ENVELOPE_VERSION)
+	}
+	env.Payload = raw[envelopePrefixLen+metadataLen:]
+
+	data, err := env.validate()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &env, data, nil
+}
+
+// validate checks that the envelope describes a block payload this build can read, and that the
+// payload matches its checksum and the advertised block. It returns the decoded payload.
+func (env *Envelope) validate() (*Data, error) {
+	if env.ContentType != CONTENT_TYPE_BLOCK_DATA {
+		return nil, fmt.Errorf("unsupported content type %q", env.ContentType)
+	}
+
+	codec, err := ParseCodecName(env.Encoding)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported payload encoding: %v", err)
+	}
+
+	if sum := checksum(env.Payload); sum != env.Checksum {
+		return nil, fmt.Errorf("payload checksum mismatch: got %s, envelope says %s", sum, env.Checksum)
+	}
+
+	data, err := codec.UnmarshalData(env.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s payload: %v", env.Encoding, err)
+	}
+
+	header := data.Block.Header
+	if header == nil {
+		return nil, fmt.Errorf("payload holds no block header")
+	}
+
+	if header.Number.Uint64() != env.BlockNumber || header.Hash().Hex() != env.BlockHash {
+		return nil, fmt.Errorf("payload holds block %d (%s), envelope says %d (%s)",
+			header.Number, header.Hash().Hex(), env.BlockNumber, env.BlockHash)
+	}
+
+	return data, nil
+}
+
+// upgradeLegacyPayload wraps a bare `model.Data` payload into a current-version envelope.
+func upgradeLegacyPayload(raw []byte) (*Envelope, error) {
+	header, err := payloadHeader(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := bytes.Clone(raw)
+	return &Envelope{
+		Version:     ENVELOPE_VERSION,
+		BlockNumber: header.Number.Uint64(),
+		BlockHash:   header.Hash().Hex(),
+		ContentType: CONTENT_TYPE_BLOCK_DATA,
+		Encoding:    ENCODING_JSON,
+		Checksum:    checksum(payload),
+		Payload:     payload,
+	}, nil
+}
+
+// payloadHeader extracts the block header from a `model.Data` payload without decoding the block body.
+func payloadHeader(payload []byte) (*types.Header, error) {
+	var data struct {
+		Block struct {
+			Header *types.Header `json:"header"`
+		} `json:"block"`
+	}
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return nil, fmt.Errorf("error unmarshalling payload header: %v", err)
+	}
+
+	if data.Block.Header == nil {
+		return nil, fmt.Errorf("payload holds no block header")
+	}
+
+	return data.Block.Header, nil
+}
+
+func checksum(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return checksumPrefix + hex.EncodeToString(sum[:])
+}
+
+// CheckChain verifies that the envelope belongs to the expected chain. A zero expected chain ID, or an
+// envelope upgraded from a payload which carried no chain ID, passes the check.
+func (env *Envelope) CheckChain(expected uint64) error {
+	if expected != 0 && env.ChainID != 0 && env.ChainID != expected {
+		return fmt.Errorf("envelope is for chain %d, expected chain %d", env.ChainID, expected)
+	}
+	return nil
+}
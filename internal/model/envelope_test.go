@@ -0,0 +1,319 @@
+package model
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// testBlockData returns block data with txCount transactions emitting one event each. The body lists are empty
+// rather than nil, as in blocks fetched from a node. With post-London header fields, the header carries the base
+// fee, withdrawals, blob gas and beacon root fields, and the body withdrawals.
+func testBlockData(number int64, txCount int, postLondon bool) *Data {
+	header := &types.Header{
+		ParentHash: common.HexToHash("0x01"),
+		Number:     big.NewInt(number),
+		Difficulty: big.NewInt(2),
+		GasLimit:   30_000_000,
+		Time:       1_700_000_000 + uint64(number)*12,
+		Extra:      []byte("test"),
+	}
+	body := &types.Body{Transactions: []*types.Transaction{}, Uncles: []*types.Header{}}
+	if postLondon {
+		blobGasUsed, excessBlobGas := uint64(131072), uint64(0)
+		withdrawalsHash, beaconRoot := types.EmptyWithdrawalsHash, common.HexToHash("0xbeac")
+		header.Difficulty = big.NewInt(0)
+		header.BaseFee = big.NewInt(7)
+		header.WithdrawalsHash = &withdrawalsHash
+		header.BlobGasUsed = &blobGasUsed
+		header.ExcessBlobGas = &excessBlobGas
+		header.ParentBeaconRoot = &beaconRoot
+		body.Withdrawals = []*types.Withdrawal{{Index: 1, Validator: 2, Address: common.HexToAddress("0x03"), Amount: 4}}
+	}
+
+	data := &Data{
+		TransactionHashes: make(map[string]*types.Transaction),
+		Events:            make(map[string][]*types.Log),
+	}
+	for i := 0; i < txCount; i++ {
+		to := common.BigToAddress(big.NewInt(int64(i + 1)))
+		tx := types.NewTx(&types.LegacyTx{
+			Nonce:    uint64(number)<<16 | uint64(i),
+			GasPrice: big.NewInt(1e9),
+			Gas:      21000,
+			To:       &to,
+			Value:    big.NewInt(1),
+			Data:     []byte(strings.Repeat("a", 64)),
+		})
+		body.Transactions = append(body.Transactions, tx)
+		data.TransactionHashes[tx.Hash().Hex()] = tx
+		data.Events[tx.Hash().Hex()] = []*types.Log{{
+			Address:     to,
+			Topics:      []common.Hash{common.HexToHash("0xddf252ad")},
+			Data:        make([]byte, 32),
+			BlockNumber: uint64(number),
+			TxHash:      tx.Hash(),
+			TxIndex:     uint(i),
+			Index:       uint(i),
+		}}
+	}
+
+	data.Block = Block{Header: header, Body: body}
+	return data
+}
+
+// wrap marshals data with codec and wraps it for chain 1.
+func wrap(t *testing.T, data *Data, codec Codec) []byte {
+	t.Helper()
+	payload, err := codec.MarshalData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := WrapBlockData(payload, codec, data.Block.Header, big.NewInt(1), "pub-1", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+// rewrap splits a binary envelope, lets edit change its metadata, along with the payload under "payload", and
+// joins it again.
+func rewrap(t *testing.T, raw []byte, edit func(env map[string]interface{})) []byte {
+	t.Helper()
+	metadataLen := binary.BigEndian.Uint32(raw[1:envelopePrefixLen])
+	var env map[string]interface{}
+	if err := json.Unmarshal(raw[envelopePrefixLen:envelopePrefixLen+metadataLen], &env); err != nil {
+		t.Fatal(err)
+	}
+	env["payload"] = raw[envelopePrefixLen+metadataLen:]
+	edit(env)
+
+	payload := env["payload"].([]byte)
+	delete(env, "payload")
+	metadata, err := json.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(append(binary.BigEndian.AppendUint32([]byte{envelopeMagic}, uint32(len(metadata))), metadata...), payload...)
+}
+
+func TestOpenEnvelope(t *testing.T) {
+	data := testBlockData(42, 2, true)
+	codec := Codec{Encoding: ENCODING_RLP, Compression: COMPRESSION_ZSTD}
+
+	env, opened, err := OpenEnvelope(wrap(t, data, codec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Version != ENVELOPE_VERSION || env.ChainID != 1 || env.BlockNumber != 42 || env.ProducerID != "pub-1" || env.Encoding != "rlp+zstd" || env.FencingToken != 3 {
+		t.Errorf("unexpected envelope %+v", env)
+	}
+	if opened.Block.Header.Hash() != data.Block.Header.Hash() || len(opened.TransactionHashes) != 2 {
+		t.Errorf("opened block %s with %d transactions, want %s with 2", opened.Block.Header.Hash(), len(opened.TransactionHashes), data.Block.Header.Hash())
+	}
+}
+
+func TestOpenEnvelopeRejects(t *testing.T) {
+	data := testBlockData(42, 2, true)
+	codec := Codec{Encoding: ENCODING_JSON, Compression: COMPRESSION_SNAPPY}
+	raw := wrap(t, data, codec)
+
+	otherPayload, err := codec.MarshalData(testBlockData(43, 1, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name string
+		raw  []byte
+		want string
+	}{
+		{
+			name: "checksum mismatch",
+			raw: rewrap(t, raw, func(env map[string]interface{}) {
+				env["checksum"] = "sha256:" + strings.Repeat("0", 64)
+			}),
+			want: "checksum mismatch",
+		},
+		{
+			name: "corrupted payload",
+			raw: rewrap(t, raw, func(env map[string]interface{}) {
+				env["payload"] = otherPayload
+			}),
+			want: "checksum mismatch",
+		},
+		{
+			name: "unknown version",
+			raw: rewrap(t, raw, func(env map[string]interface{}) {
+				env["version"] = ENVELOPE_VERSION + 1
+			}),
+			want: "unsupported envelope version",
+		},
+		{
+			name: "missing version",
+			raw: rewrap(t, raw, func(env map[string]interface{}) {
+				delete(env, "version")
+			}),
+			want: "envelope version missing",
+		},
+		{
+			name: "unknown content type",
+			raw: rewrap(t, raw, func(env map[string]interface{}) {
+				env["content_type"] = "application/json"
+			}),
+			want: "unsupported content type",
+		},
+		{
+			name: "unknown encoding",
+			raw: rewrap(t, raw, func(env map[string]interface{}) {
+				env["encoding"] = "protobuf"
+			}),
+			want: "unsupported payload encoding",
+		},
+		{
+			name: "encoding mismatch",
+			raw: rewrap(t, raw, func(env map[string]interface{}) {
+				env["encoding"] = "rlp+snappy"
+			}),
+			want: "encoded with json+snappy, expected rlp+snappy",
+		},
+		{
+			name: "other block",
+			raw: rewrap(t, raw, func(env map[string]interface{}) {
+				env["block_number"] = 43
+			}),
+			want: "envelope says 43",
+		},
+		{
+			name: "not JSON",
+			raw:  []byte("not an envelope"),
+			want: "error unmarshalling envelope",
+		},
+		{
+			name: "truncated metadata",
+			raw:  raw[:envelopePrefixLen+10],
+			want: "truncated metadata",
+		},
+		{
+			name: "truncated payload",
+			raw:  raw[:len(raw)-10],
+			want: "checksum mismatch",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := OpenEnvelope(test.raw)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("OpenEnvelope() error = %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestWrapBlockDataKeepsPayloadRaw(t *testing.T) {
+	data := testBlockData(42, 2, true)
+	codec := Codec{Encoding: ENCODING_RLP, Compression: COMPRESSION_ZSTD}
+	payload, err := codec.MarshalData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The payload follows the metadata as is, rather than base64-encoded within it. It is wrapped as marshalled
+	// here, since compressing it again need not give the same bytes.
+	raw, err := WrapBlockData(payload, codec, data.Block.Header, big.NewInt(1), "pub-1", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(raw, payload) || len(raw)-len(payload) > 512 {
+		t.Errorf("envelope of %d bytes around a payload of %d bytes", len(raw), len(payload))
+	}
+}
+
+func TestOpenEnvelopeReadsVersion1(t *testing.T) {
+	data := testBlockData(42, 2, true)
+	codec := Codec{Encoding: ENCODING_JSON, Compression: COMPRESSION_SNAPPY}
+	payload, err := codec.MarshalData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Version 1 envelopes were JSON documents carrying the payload in base64
+	v1, err := json.Marshal(Envelope{
+		Version:     1,
+		ChainID:     1,
+		BlockNumber: 42,
+		BlockHash:   data.Block.Header.Hash().Hex(),
+		ContentType: CONTENT_TYPE_BLOCK_DATA,
+		Encoding:    codec.String(),
+		Checksum:    checksum(payload),
+		Payload:     payload,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, opened, err := OpenEnvelope(v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Version != 1 || opened.Block.Header.Hash() != data.Block.Header.Hash() {
+		t.Errorf("opened version %d envelope of block %s, want version 1 of %s", env.Version, opened.Block.Header.Hash(), data.Block.Header.Hash())
+	}
+}
+
+func TestOpenEnvelopeUpgradesLegacyPayload(t *testing.T) {
+	data := testBlockData(42, 2, false)
+
+	// Version 0 notifiers published the bare JSON block data
+	legacy, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, opened, err := OpenEnvelope(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Version != ENVELOPE_VERSION || env.Encoding != ENCODING_JSON || env.ContentType != CONTENT_TYPE_BLOCK_DATA {
+		t.Errorf("legacy payload upgraded to %+v", env)
+	}
+	if env.BlockNumber != 42 || env.BlockHash != data.Block.Header.Hash().Hex() {
+		t.Errorf("upgraded envelope describes block %d (%s), want 42 (%s)", env.BlockNumber, env.BlockHash, data.Block.Header.Hash().Hex())
+	}
+	if env.ChainID != 0 {
+		t.Errorf("upgraded envelope has chain ID %d, want none", env.ChainID)
+	}
+	if opened.Block.Header.Hash() != data.Block.Header.Hash() || len(opened.Events) != 2 {
+		t.Errorf("opened block %s with %d events, want %s with 2", opened.Block.Header.Hash(), len(opened.Events), data.Block.Header.Hash())
+	}
+
+	// A legacy payload carries no chain ID, so it passes any chain check
+	if err := env.CheckChain(5); err != nil {
+		t.Errorf("CheckChain of an upgraded envelope: %v", err)
+	}
+}
+
+func TestCheckChain(t *testing.T) {
+	env, _, err := OpenEnvelope(wrap(t, testBlockData(42, 1, true), Codec{Encoding: ENCODING_JSON, Compression: COMPRESSION_NONE}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		expected uint64
+		ok       bool
+	}{
+		{expected: 1, ok: true},
+		{expected: 0, ok: true}, // No expected chain
+		{expected: 5, ok: false},
+	} {
+		if err := env.CheckChain(test.expected); (err == nil) != test.ok {
+			t.Errorf("CheckChain(%d) of an envelope for chain 1 = %v, want ok %v", test.expected, err, test.ok)
+		}
+	}
+}
@@ -2,16 +2,22 @@ package model
 
 import (
 	"context"
-	"encoding/json"
+	"ethereum-data-service/pkg/enum"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 )
 
 // Block represents an Ethereum block, containing both header and body information.
 type Block struct {
-	Header *types.Header `json:"header"` // Header contains metadata about the block.
-	Body   *types.Body   `json:"body"`   // Body contains the transactions and uncles of the block.
+	Header   *types.Header    `json:"header"`              // Header contains metadata about the block.
+	Body     *types.Body      `json:"body"`                // Body contains the transactions and uncles of the block, nil below the `full` detail level.
+	Detail   enum.DetailLevel `json:"detail,omitempty"`    // Detail is the level the block was ingested at, empty for blocks ingested before levels existed, which are `full`.
+	Filtered bool             `json:"filtered,omitempty"`  // Filtered tells that the ingestion allowlists left transactions or events of the block out.
+	TxHashes []common.Hash    `json:"tx_hashes,omitempty"` // TxHashes holds the transaction hashes at the `tx_hashes` detail level.
+	Receipts []*Receipt       `json:"receipts,omitempty"`  // Receipts holds the receipts of the transactions at the `traces` detail level.
+	Traces   []*Trace         `json:"traces,omitempty"`    // Traces holds the call traces of the transactions at the `traces` detail level.
 }
 
 // Data represents storage data in Redis DB for Ethereum-related information.
@@ -25,35 +31,115 @@ type Data struct {
 }
 
 // FormatBlockData: Extracts the data from Ethereum Block and format the data
-// as per model.Data and then marshalls the result into bytes.
-func FormatBlockData(client *ethclient.Client, block *types.Block) ([]byte, error) {
+// as per model.Data and then marshalls the result into bytes with the given codec.
+func FormatBlockData(client *ethclient.Client, block *types.Block, codec Codec, detail Detail) ([]byte, error) {
+	blockData, err := NewBlockData(client, block, detail)
+	if err != nil {
+		return nil, err
+	}
+
+	return codec.MarshalData(blockData)
+}
+
+// NewBlockData: Extracts the data from Ethereum Block and format the data as per model.Data, keeping only
+// what the detail level and allowlists select. Receipts are only fetched from `full` up, and call traces
+// at the `traces` level, which needs a node serving the `debug` namespace.
+func NewBlockData(client *ethclient.Client, block *types.Block, detail Detail) (*Data, error) {
 
 	blockData := Data{
-		Block:             Block{Header: block.Header(), Body: block.Body()},
+		Block:             Block{Header: block.Header(), Detail: detail.level()},
 		TransactionHashes: make(map[string]*types.Transaction),
 		Events:            make(map[string][]*types.Log),
 	}
 
+	if !detail.Includes(enum.FULL) {
+		if detail.Includes(enum.TX_HASHES) {
+			for _, tx := range block.Transactions() {
+				blockData.Block.TxHashes = append(blockData.Block.TxHashes, tx.Hash())
+			}
+		}
+		return &blockData, nil
+	}
+
 	// Get transaction hashes and all events related to each transaction in each block
+	body := block.Body()
+	body.Transactions = make([]*types.Transaction, 0, len(block.Transactions()))
 	for _, tx := range block.Transactions() {
-		blockData.TransactionHashes[tx.Hash().Hex()] = tx
-
 		// Fetch events for each transaction
 		receipt, err := client.TransactionReceipt(context.Background(), tx.Hash())
 		if err != nil {
 			return nil, err
 		}
 
+		// Keep the events, and the transaction, passing the allowlists
+		logs := make([]*types.Log, 0, len(receipt.Logs))
+		for _, log := range receipt.Logs {
+			if detail.KeepsLog(log) {
+				logs = append(logs, log)
+			}
+		}
+		if len(logs) < len(receipt.Logs) {
+			blockData.Block.Filtered = true
+		}
+		if !detail.keepsTransaction(tx, logs) {
+			blockData.Block.Filtered = true
+			continue
+		}
+
+		body.Transactions = append(body.Transactions, tx)
+		blockData.TransactionHashes[tx.Hash().Hex()] = tx
 		// Store all events related to a particular transaction
-		blockData.Events[receipt.TxHash.Hex()] = receipt.Logs
+		blockData.Events[receipt.TxHash.Hex()] = logs
 
+		if detail.Includes(enum.TRACES) {
+			blockData.Block.Receipts = append(blockData.Block.Receipts, newReceipt(receipt))
+		}
+	}
+	blockData.Block.Body = body
+
+	if detail.Includes(enum.TRACES) {
+		traces, err := traceBlock(client, block)
+		if err != nil {
+			return nil, err
+		}
+		for _, trace := range traces {
+			if blockData.TransactionHashes[trace.TxHash.Hex()] != nil {
+				blockData.Block.Traces = append(blockData.Block.Traces, trace)
+			}
+		}
 	}
 
-	// Marshal BlockData to bytes
-	blockDataJSON, err := json.Marshal(blockData)
+	return &blockData, nil
+}
+
+// newReceipt keeps the fields of a receipt which are not stored elsewhere.
+func newReceipt(receipt *types.Receipt) *Receipt {
+	return &Receipt{
+		TxHash:            receipt.TxHash,
+		Type:              receipt.Type,
+		Status:            receipt.Status,
+		CumulativeGasUsed: receipt.CumulativeGasUsed,
+		GasUsed:           receipt.GasUsed,
+		EffectiveGasPrice: receipt.EffectiveGasPrice,
+		ContractAddress:   receipt.ContractAddress,
+	}
+}
+
+// traceBlock fetches the call traces of the transactions of a block with `debug_traceBlockByHash`. Nodes
+// which leave the transaction hash out of the results list them in transaction order.
+func traceBlock(client *ethclient.Client, block *types.Block) ([]*Trace, error) {
+	var traces []*Trace
+	err := client.Client().CallContext(context.Background(), &traces, "debug_traceBlockByHash", block.Hash(), map[string]string{"tracer": "callTracer"})
 	if err != nil {
 		return nil, err
 	}
 
-	return blockDataJSON, nil
+	txs := block.Transactions()
+	for i, trace := range traces {
+		if trace.TxHash == (common.Hash{}) && i < len(txs) {
+			trace.TxHash = txs[i].Hash()
+		}
+	}
+
+	return traces, nil
 }
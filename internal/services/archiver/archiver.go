@@ -0,0 +1,130 @@
+package archiver
+
+import (
+	"context"
+	"errors"
+	"ethereum-data-service/internal/archive"
+	"ethereum-data-service/internal/client"
+	"ethereum-data-service/internal/config"
+	"ethereum-data-service/internal/storage"
+	"ethereum-data-service/pkg/util"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	eth_err "ethereum-data-service/pkg/err"
+)
+
+// pollInterval is how often the archiver looks for newly confirmed blocks.
+const pollInterval = 5 * time.Second
+
+// RunBlockArchiverSvc: Archives the confirmed blocks of storage to `ARCHIVE_DIR` before they are evicted.
+func RunBlockArchiverSvc(client *client.Client, cfg *config.Config, shutdown chan struct{}) {
+	// Create a common context instance
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Handle OS signals for graceful shutdown
+	go util.HandleGracefulShutdown(cancel, shutdown)
+
+	err := Run(ctx, client.STORE, cfg, func() {})
+	if err != nil {
+		log.Printf("error in block archiver: %v\n", err)
+	}
+
+	log.Println("Shutting down BlockArchiver service...")
+}
+
+// Run archives every block once it is `ARCHIVE_CONFIRMATIONS` below the watermark, in height order, and prunes
+// the archive as per `ARCHIVE_RETENTION_DAYS`, until ctx is cancelled. It calls ready once the archive is open.
+// Blocks are archived well before `RETENTION_BLOCKS` evicts them (or their keys expire), so that archival does
+// not depend on the eviction path of each storage backend.
+func Run(ctx context.Context, store storage.Store, cfg *config.Config, ready func()) error {
+	if cfg.RETENTION_BLOCKS > 0 && cfg.ARCHIVE_CONFIRMATIONS >= cfg.RETENTION_BLOCKS {
+		return fmt.Errorf("ARCHIVE_CONFIRMATIONS (%d) is not below RETENTION_BLOCKS (%d): blocks would be evicted before they are archived",
+			cfg.ARCHIVE_CONFIRMATIONS, cfg.RETENTION_BLOCKS)
+	}
+
+	archiver, err := archive.NewArchiver(cfg)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Archiving blocks to %s from block %d\n", cfg.ARCHIVE_DIR, archiver.LastArchived()+1)
+	ready()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := archiveConfirmed(ctx, store, archiver, cfg); err != nil {
+			log.Printf("error archiving blocks: %v\n", err)
+		}
+
+		pruned, err := archiver.Prune(time.Now())
+		if err != nil {
+			log.Printf("error pruning archive: %v\n", err)
+		} else if pruned > 0 {
+			log.Printf("Pruned %d archived files older than %d days\n", pruned, cfg.ARCHIVE_RETENTION_DAYS)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// archiveConfirmed archives the blocks from the last archived one up to `ARCHIVE_CONFIRMATIONS` below the watermark.
+// Heights evicted from storage are skipped, and a block missing within the window is retried on the next call.
+func archiveConfirmed(ctx context.Context, store storage.Store, archiver *archive.Archiver, cfg *config.Config) error {
+	watermark, err := store.GetWatermark(ctx)
+	if err != nil {
+		return err
+	}
+	if watermark <= uint64(cfg.ARCHIVE_CONFIRMATIONS) {
+		return nil
+	}
+	confirmed := watermark - uint64(cfg.ARCHIVE_CONFIRMATIONS)
+
+	next := archiver.LastArchived() + 1
+	if archiver.LastArchived() == 0 {
+		oldest, err := store.EarliestBlock(ctx)
+		if errors.Is(err, eth_err.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		next = oldest
+	}
+
+	for number := next; number <= confirmed && ctx.Err() == nil; number++ {
+		blockData, err := store.GetBlockData(ctx, strconv.FormatUint(number, 10))
+		if errors.Is(err, eth_err.ErrNotFound) {
+			oldest, err := store.EarliestBlock(ctx)
+			if err != nil && !errors.Is(err, eth_err.ErrNotFound) {
+				return err
+			}
+			if err == nil && oldest > number {
+				log.Printf("Blocks %d to %d were evicted before being archived\n", number, oldest-1)
+				number = oldest - 1
+				continue
+			}
+
+			// Still within the window, for ex. removed mid-reorg: archive it on the next tick once it is stored again
+			return fmt.Errorf("block %d is missing from storage: %w", number, eth_err.ErrNotFound)
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := archiver.Archive(blockData); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package auditor
+
+import (
+	"context"
+	"errors"
+	"ethereum-data-service/internal/client"
+	"ethereum-data-service/internal/config"
+	"ethereum-data-service/internal/storage"
+	"ethereum-data-service/internal/verify"
+	"ethereum-data-service/pkg/util"
+	"log"
+	"time"
+
+	eth_err "ethereum-data-service/pkg/err"
+)
+
+// RunBlockAuditorSvc: Verifies the stored blocks against their headers every `AUDIT_INTERVAL`.
+func RunBlockAuditorSvc(client *client.Client, cfg *config.Config, shutdown chan struct{}) {
+	// Create a common context instance
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Handle OS signals for graceful shutdown
+	go util.HandleGracefulShutdown(cancel, shutdown)
+
+	err := Run(ctx, client, cfg, func() {})
+	if err != nil {
+		log.Printf("error in block auditor: %v\n", err)
+	}
+
+	log.Println("Shutting down BlockAuditor service...")
+}
+
+// Run verifies the stored blocks against their headers, then the blocks stored since every `AUDIT_INTERVAL`, until
+// ctx is cancelled. Mismatches are logged, and the blocks re-fetched from the node when `AUDIT_REFETCH` is set. It
+// calls ready before the first pass.
+func Run(ctx context.Context, client *client.Client, cfg *config.Config, ready func()) error {
+	verifier := verify.New(client.STORE, client.ETH_HTTPS, verify.Options{Receipts: cfg.AUDIT_RECEIPTS, Refetch: cfg.AUDIT_REFETCH, Detail: cfg.INGEST_DETAIL})
+
+	log.Printf("Auditing stored blocks every %v\n", cfg.AUDIT_INTERVAL)
+	ready()
+
+	ticker := time.NewTicker(cfg.AUDIT_INTERVAL)
+	defer ticker.Stop()
+
+	var audited uint64
+	for {
+		audited = audit(ctx, verifier, client.STORE, audited)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// audit verifies the stored blocks above the last audited one and returns the new last audited one. It stops below
+// the latest stored block, whose hash is checked against the parent hash of the next one once it is stored. A store
+// rewound below the last audited block, as after a restore, is audited again from its oldest block.
+func audit(ctx context.Context, verifier *verify.Verifier, store storage.Store, audited uint64) uint64 {
+	latest, err := store.LatestBlock(ctx)
+	if errors.Is(err, eth_err.ErrNotFound) {
+		return audited
+	}
+	if err != nil {
+		log.Printf("error auditing blocks: %v\n", err)
+		return audited
+	}
+
+	if latest < audited {
+		audited = 0
+	}
+	if latest <= audited+1 {
+		return audited
+	}
+
+	var from uint64
+	if audited > 0 {
+		from = audited + 1
+	}
+	summary, err := verifier.Range(ctx, from, latest-1, func(r *verify.Report) {
+		verify.LogMismatches(r)
+		audited = r.BlockNumber
+	})
+	if err != nil {
+		log.Printf("error auditing blocks: %v\n", err)
+	}
+	if summary.Mismatched > 0 {
+		log.Printf("Audited %d blocks: %d failed verification, %d re-fetched\n", summary.Verified, summary.Mismatched, summary.Refetched)
+	}
+
+	return audited
+}
@@ -2,6 +2,7 @@ package bootstrapper
 
 import (
 	"context"
+	"errors"
 	"ethereum-data-service/internal/client"
 	"ethereum-data-service/internal/config"
 	"ethereum-data-service/internal/model"
@@ -12,39 +13,50 @@ import (
 	"os"
 	"time"
 
+	eth_err "ethereum-data-service/pkg/err"
+
 	"github.com/ethereum/go-ethereum/ethclient"
-	"github.com/redis/go-redis/v9"
 )
 
 // RunBootstrapSvc initializes the Bootstrap Service, which fetches the most recent blocks from Ethereum and stores them in Redis.
 // It creates a context for the operations, handles OS signals for graceful shutdown, calculates execution time, and shuts down automatically after completion.
 func RunBootstrapSvc(client *client.Client, cfg *config.Config) {
-	ethClient, rdb := client.ETH_HTTPS, client.REDIS
+	err := Bootstrap(context.Background(), client, cfg)
+	if err != nil {
+		log.Printf("error running bootstrapper service: %v", err)
+	}
+
+	// Once the bootstrapper finished loading 50 blocks, it shuts down succesfully
+	log.Println("Shutting down bootstraper gracefully...")
+	os.Exit(0)
+
+}
+
+// Bootstrap fetches the most recent blocks from Ethereum and stores them in the configured store, giving up after `BOOTSTRAP_TIMEOUT`.
+// It loads every block up to the chain head at the time it starts, so a notifier subscribed to new heads before
+// Bootstrap is called covers all the later blocks without a gap.
+func Bootstrap(ctx context.Context, client *client.Client, cfg *config.Config) error {
+	ethClient, store := client.ETH_HTTPS, client.STORE
 
 	// Create a common context instance with a timeout
-	ctx, cancel := context.WithTimeout(context.Background(), cfg.BOOTSTRAP_TIMEOUT)
+	ctx, cancel := context.WithTimeout(ctx, cfg.BOOTSTRAP_TIMEOUT)
 	defer cancel()
 
 	startTime := time.Now()
 
-	err := loadRecentBlockData(ctx, ethClient, rdb, cfg)
-	if err != nil {
-		log.Printf("error running bootstrapper service: %v", err)
+	if err := loadRecentBlockData(ctx, ethClient, store, cfg); err != nil {
+		return err
 	}
 
 	// Calculate and log total execution time
 	totalTime := time.Since(startTime)
 	log.Printf("Bootstrapper successfully completed in %s", totalTime)
-
-	// Once the bootstrapper finished loading 50 blocks, it shuts down succesfully
-	log.Println("Shutting down bootstraper gracefully...")
-	os.Exit(0)
-
+	return nil
 }
 
-// loadRecentBlockData fetches the most recent blocks from Ethereum and loads them into the Redis server.
-// It retrieves the latest block number, iterates over the number of blocks to sync, and stores each block in Redis with a calculated expiration time.
-func loadRecentBlockData(ctx context.Context, ethClient *ethclient.Client, rdb *redis.Client, cfg *config.Config) error {
+// loadRecentBlockData fetches the most recent blocks from Ethereum and loads them into the store.
+// It retrieves the latest block number, iterates over the number of blocks to sync, and stores each block.
+func loadRecentBlockData(ctx context.Context, ethClient *ethclient.Client, store storage.Store, cfg *config.Config) error {
 
 	latestBlockNumber, err := ethClient.BlockNumber(ctx)
 	if err != nil {
@@ -52,29 +64,58 @@ func loadRecentBlockData(ctx context.Context, ethClient *ethclient.Client, rdb *
 	}
 	latestBlockBigInt := new(big.Int).SetUint64(latestBlockNumber)
 
-	log.Printf("Fetching the last %d Ethereum blocks (least-to-most recent) and loading them to Redis...\n", cfg.NUM_BLOCKS_TO_SYNC)
+	log.Printf("Fetching the last %d Ethereum blocks (least-to-most recent) and loading them to %s at detail %s...\n", cfg.NUM_BLOCKS_TO_SYNC, cfg.STORAGE_BACKEND, cfg.INGEST_DETAIL)
 	log.Printf("Latest Block height is: %d \n", latestBlockBigInt.Int64())
 
 	// Start loading from the oldest block i.e. `latestBlock - 50`
 	n := cfg.NUM_BLOCKS_TO_SYNC
 	for i := n; i >= 0; i-- {
 		blockNumber := new(big.Int).Sub(latestBlockBigInt, big.NewInt(int64(i)))
+
+		// Blocks already stored (for ex. imported from a snapshot) are only fetched again if the chain moved on
+		stored, err := isStored(ctx, ethClient, store, blockNumber)
+		if err != nil {
+			return err
+		}
+		if stored {
+			continue
+		}
+
 		block, err := ethClient.BlockByNumber(ctx, blockNumber)
 		if err != nil {
 			return err
 		}
 
-		blockDataInBytes, err := model.FormatBlockData(ethClient, block)
+		blockData, err := model.NewBlockData(ethClient, block, cfg.INGEST_DETAIL)
 		if err != nil {
 			return err
 		}
 
-		err = storage.AddBlockDataToDB(ctx, rdb, blockDataInBytes, cfg.REDIS_KEY_EXPIRY_TIME)
+		_, err = store.ApplyBlockData(ctx, blockData)
 		if err != nil {
 			return err
 		}
 	}
 
-	log.Printf("Successfully loaded %d blocks to Redis", cfg.NUM_BLOCKS_TO_SYNC)
+	log.Printf("Successfully loaded %d blocks to %s", cfg.NUM_BLOCKS_TO_SYNC, cfg.STORAGE_BACKEND)
 	return nil
 }
+
+// isStored reports whether the block with the given number is stored with the hash of the canonical block, which
+// only costs the node a header lookup instead of the block and its receipts.
+func isStored(ctx context.Context, ethClient *ethclient.Client, store storage.Store, blockNumber *big.Int) (bool, error) {
+	block, err := store.GetBlockByNumber(ctx, blockNumber.String())
+	if errors.Is(err, eth_err.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	header, err := ethClient.HeaderByNumber(ctx, blockNumber)
+	if err != nil {
+		return false, err
+	}
+
+	return block.Header.Hash() == header.Hash(), nil
+}
@@ -0,0 +1,213 @@
+package pub
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"ethereum-data-service/internal/config"
+	"ethereum-data-service/pkg/enum"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// LEADER_KEY holds the ID of the notifier currently allowed to publish. It expires unless renewed.
+	LEADER_KEY = "pub:leader"
+	// FENCING_TOKEN_KEY holds the fencing token of the latest lease, incremented on every acquisition.
+	FENCING_TOKEN_KEY = "pub:leader:token"
+	// LAST_PUBLISHED_KEY holds the highest block number published by a leader, from which a new leader catches up.
+	LAST_PUBLISHED_KEY = "pub:last_published"
+)
+
+// errFenced is returned when a newer leader took over the lease, meaning this notifier must stop publishing.
+var errFenced = errors.New("notifier lease lost to a newer leader")
+
+var (
+	// acquireLease takes the lease if it is free and returns the new fencing token, or 0 if another notifier holds it.
+	acquireLease = redis.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("INCR", KEYS[2])
+end
+return 0
+`)
+
+	// renewLease extends the lease if it is still held by the caller.
+	renewLease = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+	// releaseLease deletes the lease if it is still held by the caller.
+	releaseLease = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+	// recordPublished records the highest published block number, unless the fencing token has been superseded.
+	recordPublished = redis.NewScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+local current = tonumber(redis.call("GET", KEYS[2]) or "0")
+if tonumber(ARGV[2]) > current then
+	redis.call("SET", KEYS[2], ARGV[2])
+end
+return 1
+`)
+)
+
+// lease is a Redis based leader lease. The notifier holding it publishes new blocks while the others stand by,
+// trying to take it over every third of `LEADER_LEASE_TTL`. The leader renews the lease at the same pace and
+// stops publishing when it fails to do so before the lease expires. Every acquisition comes with a higher
+// fencing token, which the leader presents when recording its progress, so that a paused leader which lost the
+// lease without noticing is fenced off as soon as it tries to publish again.
+//
+// With the in-process `memory` transport there is a single notifier by construction, so the lease is local:
+// it is always acquired, never lost, and progress is recorded in memory without touching Redis.
+type lease struct {
+	rdb   redis.UniversalClient
+	id    string
+	ttl   time.Duration
+	local bool
+
+	tag string // tag is the Redis Cluster hash tag prefixing the lease keys, so that the scripts touch a single slot.
+
+	published uint64 // published is the last block recorded by a local lease.
+
+	token int64              // token is the fencing token of the lease currently held, 0 when standing by.
+	lost  context.CancelFunc // lost cancels the leader context when the lease is lost.
+}
+
+// newLease returns a lease identified by the producer ID and a random suffix, which tells replicas sharing
+// a producer ID apart.
+func newLease(rdb redis.UniversalClient, cfg *config.Config) *lease {
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+
+	return &lease{
+		rdb:   rdb,
+		id:    cfg.PRODUCER_ID + "-" + hex.EncodeToString(suffix),
+		ttl:   cfg.LEADER_LEASE_TTL,
+		local: enum.Transport(cfg.TRANSPORT) == enum.MEMORY,
+		tag:   cfg.REDIS_HASH_TAG,
+	}
+}
+
+// acquire tries to take the lease. On success it returns a context which is cancelled once the lease is
+// lost, and keeps renewing the lease in the background until then.
+func (l *lease) acquire(ctx context.Context) (context.Context, bool, error) {
+	if l.local {
+		l.token = 1
+		leaderCtx, lost := context.WithCancel(ctx)
+		l.lost = lost
+		return leaderCtx, true, nil
+	}
+
+	token, err := acquireLease.Run(ctx, l.rdb, []string{l.tag + LEADER_KEY, l.tag + FENCING_TOKEN_KEY}, l.id, l.ttl.Milliseconds()).Int64()
+	if err != nil || token == 0 {
+		return nil, false, err
+	}
+
+	l.token = token
+	leaderCtx, lost := context.WithCancel(ctx)
+	l.lost = lost
+	go l.renew(leaderCtx, lost)
+
+	return leaderCtx, true, nil
+}
+
+// renew extends the lease every third of its TTL until the leader context is done, calling lost, the cancel
+// func of that term, when the lease is considered lost: when it has been taken over, or when it could not be
+// renewed for a whole TTL. l.lost belongs to the caller and moves on to the next term when the lease is
+// acquired again, so it is not touched here.
+func (l *lease) renew(ctx context.Context, lost context.CancelFunc) {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	renewed := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ok, err := renewLease.Run(ctx, l.rdb, []string{l.tag + LEADER_KEY}, l.id, l.ttl.Milliseconds()).Int64()
+			switch {
+			case err == nil && ok == 1:
+				renewed = time.Now()
+			case err == nil:
+				log.Println("Notifier lease taken over by another notifier")
+				lost()
+				return
+			case time.Since(renewed) >= l.ttl:
+				log.Printf("error renewing notifier lease, giving it up: %v\n", err)
+				lost()
+				return
+			}
+		}
+	}
+}
+
+// release gives the lease up so that a standby takes over right away.
+func (l *lease) release() {
+	if l.lost != nil {
+		l.lost()
+	}
+	if l.local {
+		l.token = 0
+		return
+	}
+
+	if err := releaseLease.Run(context.Background(), l.rdb, []string{l.tag + LEADER_KEY}, l.id).Err(); err != nil {
+		log.Printf("error releasing notifier lease: %v\n", err)
+	}
+	l.token = 0
+}
+
+// checkpoint records blockNumber as published. It returns errFenced, and cancels the leader context, if a
+// newer leader has taken over in the meantime.
+func (l *lease) checkpoint(ctx context.Context, blockNumber uint64) error {
+	if l.local {
+		l.published = max(l.published, blockNumber)
+		return nil
+	}
+
+	ok, err := recordPublished.Run(ctx, l.rdb, []string{l.tag + FENCING_TOKEN_KEY, l.tag + LAST_PUBLISHED_KEY}, strconv.FormatInt(l.token, 10), blockNumber).Int64()
+	if err != nil {
+		return err
+	}
+
+	if ok == 0 {
+		l.lost()
+		return errFenced
+	}
+	return nil
+}
+
+// verify checks that the lease has not been superseded, without recording any progress.
+func (l *lease) verify(ctx context.Context) error {
+	return l.checkpoint(ctx, 0)
+}
+
+// lastPublished returns the highest block number published by any leader, or 0 if none was published yet.
+func (l *lease) lastPublished(ctx context.Context) (uint64, error) {
+	if l.local {
+		return l.published, nil
+	}
+
+	value, err := l.rdb.Get(ctx, l.tag+LAST_PUBLISHED_KEY).Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
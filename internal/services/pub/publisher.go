@@ -4,36 +4,102 @@ import (
 	"context"
 	"ethereum-data-service/internal/client"
 	"ethereum-data-service/internal/config"
+	"ethereum-data-service/internal/dlq"
 	"ethereum-data-service/internal/model"
+	"ethereum-data-service/internal/storage"
+	"ethereum-data-service/internal/transport"
 	"ethereum-data-service/pkg/util"
+	"fmt"
 	"log"
+	"math/big"
+	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
-	"github.com/redis/go-redis/v9"
 )
 
 // RunBlockNotifierSvc: Listens for new incoming blocks real-time from the Ethereum blockchain,
-// extracts and formats the block as per the required format, and then publishes it to the Redis channel.
+// extracts and formats the block as per the required format, and then publishes it through the configured transport.
 func RunBlockNotifierSvc(client *client.Client, cfg *config.Config, shutdown chan struct{}) {
-
-	ethClient, rdb := client.ETH_WSS, client.REDIS
-
 	// Create a common context instance
 	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	// Handle OS signals for graceful shutdown
 	go util.HandleGracefulShutdown(cancel, shutdown)
 
-	log.Println("Listening for new blocks from the Ethereum Blockchain...")
-	err := listenForBlocks(ctx, ethClient, rdb, cfg, shutdown)
+	tr, err := transport.New(cfg, client.REDIS)
+	if err != nil {
+		log.Printf("error initializing %s transport: %v", cfg.TRANSPORT, err)
+		return
+	}
+	defer tr.Close()
+
+	err = Run(ctx, client, cfg, tr, func() {})
 	if err != nil {
 		// Prefered not to throw Fatalf to keep retrying in case of connection timeout
 		log.Printf("error in block listener: %v", err)
 	}
 }
 
-func listenForBlocks(ctx context.Context, ethClient *ethclient.Client, rdb *redis.Client, cfg *config.Config, shutdown chan struct{}) error {
+// Run publishes every new block through tr until ctx is cancelled, as long as it holds the notifier lease.
+// Without the lease it stands by and takes over once the leader is gone, first catching up on the blocks
+// published since the last one the previous leader recorded. It calls ready once it is subscribed to new block
+// headers, from which point on no new block is missed, or once it stands by behind another leader.
+func Run(ctx context.Context, client *client.Client, cfg *config.Config, tr transport.Transport, ready func()) error {
+	ethClient := client.ETH_WSS
+
+	dl, err := dlq.Open(client)
+	if err != nil {
+		return err
+	}
+
+	chainID, err := ethClient.ChainID(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching chain ID: %v", err)
+	}
+
+	ready = sync.OnceFunc(ready)
+	l := newLease(client.REDIS, cfg)
+	standingBy := false
+	for ctx.Err() == nil {
+		leaderCtx, acquired, err := l.acquire(ctx)
+		if err != nil {
+			log.Printf("error acquiring notifier lease: %v\n", err)
+		}
+
+		if !acquired {
+			if !standingBy {
+				log.Println("Another notifier holds the lease, standing by...")
+				standingBy = true
+			}
+			ready()
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(cfg.LEADER_LEASE_TTL / 3):
+			}
+			continue
+		}
+
+		log.Printf("Acquired notifier lease with fencing token %d\n", l.token)
+		standingBy = false
+
+		log.Println("Listening for new blocks from the Ethereum Blockchain...")
+		err = listenForBlocks(leaderCtx, ethClient, dl, tr, cfg, chainID, l, ready)
+		l.release()
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+	}
+
+	log.Println("Shutting down BlockNotifier service...")
+	return nil
+}
+
+// listenForBlocks publishes new blocks until ctx is cancelled, which happens on shutdown or when the lease is lost.
+func listenForBlocks(ctx context.Context, ethClient *ethclient.Client, dl storage.DeadLetters, tr transport.Transport, cfg *config.Config, chainID *big.Int, l *lease, ready func()) error {
 	headers := make(chan *types.Header)
 	sub, err := ethClient.SubscribeNewHead(ctx, headers)
 	if err != nil {
@@ -41,42 +107,114 @@ func listenForBlocks(ctx context.Context, ethClient *ethclient.Client, rdb *redi
 	}
 	defer sub.Unsubscribe()
 
+	if err := catchUp(ctx, ethClient, dl, tr, cfg, chainID, l); err != nil {
+		return err
+	}
+
+	ready()
+
 	for {
 		select {
+		case <-ctx.Done():
+			return nil
 		case err := <-sub.Err():
 			return err
 		case header := <-headers:
-			select {
-			case <-shutdown:
-				log.Println("Shutting down BlockNotifier service...")
-				return nil
-			default:
-				err := handleNewHeader(ctx, ethClient, rdb, cfg, header)
-				if err != nil {
-					log.Printf("error handling new block header: %v", err)
-				}
-			}
+			publish(ctx, ethClient, dl, tr, cfg, chainID, l, header.Number)
 		}
 	}
 }
 
-func handleNewHeader(ctx context.Context, ethClient *ethclient.Client, rdb *redis.Client, cfg *config.Config, header *types.Header) error {
-	blockNumber := header.Number
+// catchUp publishes the blocks between the last one recorded by a leader and the chain head, which a previous
+// leader may have missed before it lost the lease. Blocks older than the `RETENTION_BLOCKS` window are not
+// caught up on, since they would be evicted right away.
+func catchUp(ctx context.Context, ethClient *ethclient.Client, dl storage.DeadLetters, tr transport.Transport, cfg *config.Config, chainID *big.Int, l *lease) error {
+	last, err := l.lastPublished(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching last published block: %v", err)
+	}
+	if last == 0 {
+		return nil
+	}
+
+	head, err := ethClient.BlockNumber(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching latest block number: %v", err)
+	}
+
+	from := last + 1
+	if window := uint64(cfg.RETENTION_BLOCKS); window > 0 && head > window && from < head-window {
+		from = head - window
+	}
+	if from > head {
+		return nil
+	}
+
+	log.Printf("Catching up on blocks %d to %d\n", from, head)
+	for n := from; n <= head && ctx.Err() == nil; n++ {
+		publish(ctx, ethClient, dl, tr, cfg, chainID, l, new(big.Int).SetUint64(n))
+	}
+	return nil
+}
+
+// publish publishes a single block, retrying with backoff, and dead-letters it if it still fails.
+func publish(ctx context.Context, ethClient *ethclient.Client, dl storage.DeadLetters, tr transport.Transport, cfg *config.Config, chainID *big.Int, l *lease, blockNumber *big.Int) {
+	attempts, err := util.Retry(ctx, cfg.RETRY_ATTEMPTS, cfg.RETRY_BASE_DELAY, func() error {
+		return handleNewBlock(ctx, ethClient, tr, cfg, chainID, l, blockNumber)
+	})
+	// A block interrupted by a shutdown or a lost lease is left to the next leader's catch-up
+	if err != nil && ctx.Err() == nil {
+		log.Printf("error handling new block %d: %v", blockNumber, err)
+		deadLetter(ctx, dl, blockNumber.Uint64(), attempts, err)
+	}
+}
+
+func handleNewBlock(ctx context.Context, ethClient *ethclient.Client, tr transport.Transport, cfg *config.Config, chainID *big.Int, l *lease, blockNumber *big.Int) error {
 	block, err := ethClient.BlockByNumber(ctx, blockNumber)
 	if err != nil {
 		return err
 	}
 
-	blockDataInBytes, err := model.FormatBlockData(ethClient, block)
+	blockDataInBytes, err := model.FormatBlockData(ethClient, block, cfg.PAYLOAD_CODEC, cfg.INGEST_DETAIL)
+	if err != nil {
+		return err
+	}
+
+	// Wrap the formatted block data into a versioned envelope, by which subscribers fence off a superseded leader
+	envelope, err := model.WrapBlockData(blockDataInBytes, cfg.PAYLOAD_CODEC, block.Header(), chainID, cfg.PRODUCER_ID, l.token)
 	if err != nil {
 		return err
 	}
 
-	// Publish the formatted block data to the Redis channel
-	if err := rdb.Publish(ctx, cfg.REDIS_PUBSUB_CH, blockDataInBytes).Err(); err != nil {
+	// Make sure no newer leader took over while the block was being formatted. A takeover between this check and
+	// the publication is caught by the subscribers through the fencing token of the envelope.
+	if err := l.verify(ctx); err != nil {
+		return err
+	}
+
+	// Publish the envelope through the transport
+	if err := tr.Publish(ctx, envelope); err != nil {
+		return err
+	}
+
+	if err := l.checkpoint(ctx, blockNumber.Uint64()); err != nil {
 		return err
 	}
 
-	log.Printf("Published new Block %d to Redis channel: %s\n", blockNumber, cfg.REDIS_PUBSUB_CH)
+	log.Printf("Published new Block %d via %s transport\n", blockNumber, cfg.TRANSPORT)
 	return nil
 }
+
+// deadLetter records a block which could not be published in the dead-letter store, so that it can be replayed
+// with `dlq replay`.
+func deadLetter(ctx context.Context, dl storage.DeadLetters, blockNumber uint64, attempts int, reason error) {
+	err := dlq.Add(ctx, dl, &dlq.Entry{
+		Source:      dlq.SOURCE_PUB,
+		BlockNumber: blockNumber,
+		Error:       reason.Error(),
+		Attempts:    attempts,
+	})
+	if err != nil {
+		log.Printf("error dead-lettering block %d: %v", blockNumber, err)
+	}
+}
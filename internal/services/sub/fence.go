@@ -0,0 +1,38 @@
+package sub
+
+import (
+	"ethereum-data-service/internal/model"
+	"ethereum-data-service/internal/transport"
+	"sync"
+)
+
+// fence drops the blocks published by a notifier which lost its lease without noticing. The notifier checks
+// the lease before publishing, but a leader paused between the check and the publication still publishes
+// afterwards. Every envelope therefore carries the fencing token of the lease of its producer, and a block
+// published with a lower token than the highest this subscriber has seen comes from a superseded leader. The
+// leader which took over publishes those blocks itself, catching up from the last block recorded under a
+// valid token.
+//
+// Only first deliveries are fenced: a redelivered message may have been published before the takeover, and
+// a duplicate it would store is a no-op anyway.
+type fence struct {
+	mu      sync.Mutex
+	highest int64 // highest is the highest fencing token seen.
+}
+
+// admit reports whether the block of the envelope may be stored, raising the highest token seen.
+func (f *fence) admit(env *model.Envelope, msg *transport.Message) bool {
+	// Envelopes from notifiers without a lease token are not fenced
+	if env.FencingToken == 0 {
+		return true
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if env.FencingToken >= f.highest {
+		f.highest = env.FencingToken
+		return true
+	}
+	return msg.Attempts > 1
+}
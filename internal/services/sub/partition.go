@@ -0,0 +1,195 @@
+package sub
+
+import (
+	"context"
+	"ethereum-data-service/internal/config"
+	"ethereum-data-service/internal/transport"
+	"ethereum-data-service/pkg/enum"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	eth_err "ethereum-data-service/pkg/err"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// MEMBERS_KEY is the Redis sorted set in which live subscribers register, scored by their last heartbeat (Unix milliseconds).
+const MEMBERS_KEY = "sub:members"
+
+// membership keeps this subscriber registered among the live subscribers and tells which blocks it owns.
+// Members are ranked by ID, and in `modulo` mode a member owns the blocks whose number modulo the number of
+// live members equals its rank. The partition is rebalanced whenever a member joins, leaves, or misses its
+// heartbeats for `MEMBER_TTL`. While a rebalance propagates a block may be stored by two members, which the
+// hash check in storage turns into a no-op, or by none, which the sequencer backfills.
+//
+// In `modulo` mode every member reads through a consumer of its own (see transportConfig), which is removed when
+// the member leaves, or by the member which evicts it when it stopped sending heartbeats.
+//
+// With the in-process `memory` transport this subscriber is the only one by construction, so it owns every
+// block and does not register in Redis.
+type membership struct {
+	rdb       redis.UniversalClient
+	cfg       *config.Config
+	id        string
+	mode      enum.PartitionMode
+	ttl       time.Duration
+	local     bool
+	consumers transport.ConsumerRemover // consumers removes the consumers of the members, nil if they share one.
+
+	mu      sync.RWMutex
+	members []string // members holds the IDs of the live members, sorted.
+	rank    int      // rank is the position of this member in members.
+}
+
+// newMembership validates the partition mode and registers this subscriber among the live ones, which read
+// through tr.
+func newMembership(ctx context.Context, rdb redis.UniversalClient, cfg *config.Config, tr transport.Transport) (*membership, error) {
+	mode := enum.PartitionMode(cfg.PARTITION_MODE)
+	if mode != enum.GROUP && mode != enum.MODULO {
+		return nil, eth_err.ErrInvalidPartitionMode
+	}
+	if mode == enum.GROUP && enum.Transport(cfg.TRANSPORT) == enum.REDIS_PUBSUB {
+		return nil, eth_err.ErrPubSubGroupPartition
+	}
+
+	m := &membership{
+		rdb:     rdb,
+		cfg:     cfg,
+		id:      cfg.SUBSCRIBER_ID,
+		mode:    mode,
+		ttl:     cfg.MEMBER_TTL,
+		local:   enum.Transport(cfg.TRANSPORT) == enum.MEMORY,
+		members: []string{cfg.SUBSCRIBER_ID},
+	}
+	if remover, ok := tr.(transport.ConsumerRemover); ok && mode == enum.MODULO {
+		m.consumers = remover
+	}
+	if m.local {
+		return m, nil
+	}
+
+	if err := m.heartbeat(ctx); err != nil {
+		return nil, fmt.Errorf("error joining subscribers: %v", err)
+	}
+
+	return m, nil
+}
+
+// run sends heartbeats until ctx is cancelled, then deregisters this subscriber so that the others take
+// over its partition right away.
+func (m *membership) run(ctx context.Context) {
+	if m.local {
+		return
+	}
+
+	ticker := time.NewTicker(m.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := m.rdb.ZRem(context.Background(), MEMBERS_KEY, m.id).Err(); err != nil {
+				log.Printf("error leaving subscribers: %v\n", err)
+			}
+			return
+		case <-ticker.C:
+			if err := m.heartbeat(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("error sending subscriber heartbeat: %v\n", err)
+			}
+		}
+	}
+}
+
+// heartbeat refreshes this member's registration, evicts the members whose heartbeat expired and
+// rebalances the partition if the set of live members changed.
+func (m *membership) heartbeat(ctx context.Context) error {
+	now := time.Now()
+	expired := strconv.FormatInt(now.Add(-m.ttl).UnixMilli(), 10)
+
+	var evicted, live *redis.StringSliceCmd
+	_, err := m.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, MEMBERS_KEY, redis.Z{Score: float64(now.UnixMilli()), Member: m.id})
+		evicted = pipe.ZRangeByScore(ctx, MEMBERS_KEY, &redis.ZRangeBy{Min: "-inf", Max: "(" + expired})
+		pipe.ZRemRangeByScore(ctx, MEMBERS_KEY, "-inf", "("+expired)
+		live = pipe.ZRange(ctx, MEMBERS_KEY, 0, -1)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Only the member whose transaction evicted a member removes its consumer
+	for _, id := range evicted.Val() {
+		m.removeConsumer(ctx, id)
+	}
+
+	members := live.Val()
+	slices.Sort(members)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if slices.Equal(members, m.members) {
+		return nil
+	}
+
+	m.members = members
+	m.rank = slices.Index(members, m.id)
+	if m.mode == enum.MODULO {
+		log.Printf("Partition rebalanced: member %d of %d (%s)\n", m.rank+1, len(members), strings.Join(members, ", "))
+	} else {
+		log.Printf("Subscribers changed: %d live (%s)\n", len(members), strings.Join(members, ", "))
+	}
+	return nil
+}
+
+// removeConsumer removes the consumer of the member with the given ID, if the members read through their own.
+func (m *membership) removeConsumer(ctx context.Context, id string) {
+	if m.consumers == nil {
+		return
+	}
+
+	memberCfg := *m.cfg
+	memberCfg.SUBSCRIBER_ID = id
+	if err := m.consumers.RemoveConsumer(ctx, transportConfig(&memberCfg)); err != nil {
+		log.Printf("error removing the consumer of subscriber %s: %v\n", id, err)
+	}
+}
+
+// owns reports whether this member is responsible for storing the block with the given number.
+func (m *membership) owns(blockNumber uint64) bool {
+	if m.mode != enum.MODULO {
+		return true
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return blockNumber%uint64(len(m.members)) == uint64(m.rank)
+}
+
+// size returns the number of live members.
+func (m *membership) size() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.members)
+}
+
+// transportConfig returns the transport settings for this member. In `modulo` mode every member must
+// receive all blocks, so it reads the Redis stream through a consumer group and the NATS stream through
+// a durable consumer of its own instead of the shared ones.
+func transportConfig(cfg *config.Config) *config.Config {
+	if enum.PartitionMode(cfg.PARTITION_MODE) != enum.MODULO {
+		return cfg
+	}
+
+	memberCfg := *cfg
+	memberCfg.REDIS_CONSUMER_GROUP = cfg.REDIS_CONSUMER_GROUP + ":" + cfg.SUBSCRIBER_ID
+	// NATS consumer names may not contain dots, which hostnames often do
+	memberCfg.NATS_CONSUMER = cfg.NATS_CONSUMER + "-" + strings.ReplaceAll(cfg.SUBSCRIBER_ID, ".", "_")
+	return &memberCfg
+}
@@ -0,0 +1,343 @@
+package sub
+
+import (
+	"context"
+	"ethereum-data-service/internal/config"
+	"ethereum-data-service/internal/model"
+	"ethereum-data-service/internal/storage"
+	"ethereum-data-service/internal/transport"
+	"ethereum-data-service/pkg/util"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// sequencer applies blocks in height order. It tracks the contiguous watermark, i.e. the highest block up to
+// which every block is stored, holds blocks arriving ahead of a gap for up to `REORDER_WINDOW`, and then
+// backfills the missing heights from the node through the HTTPS client. The watermark is shared through
+// storage, so blocks stored by other subscribers (or by the bootstrapper) also move it forward.
+//
+// The message of a buffered block is only acknowledged once the block is applied, or dead-lettered when it
+// cannot be, so that a subscriber stopping with blocks in its buffer gets them redelivered.
+//
+// When several subscribers share the work, each one only sees its own partition and the heights in between
+// are stored by its peers, so blocks are applied as they arrive rather than buffered, and the sequencer only
+// backfills the heights which are still missing after `REORDER_WINDOW`.
+type sequencer struct {
+	store     storage.Store
+	ethClient *ethclient.Client
+	cfg       *config.Config
+	members   *membership
+	dl        storage.DeadLetters
+
+	// work serializes the passes moving the watermark, which apply blocks and query the store and the node. mu
+	// only guards the fields below and is never held across those calls, so that a backfill or the retry backoff
+	// does not hold up incoming blocks.
+	work sync.Mutex
+
+	mu        sync.Mutex
+	watermark uint64                   // watermark is 0 until the first block has been sequenced.
+	highest   uint64                   // highest is the highest block number received.
+	pending   map[uint64]*pendingBlock // pending holds the blocks received ahead of the watermark.
+	gapSince  time.Time                // gapSince is when the oldest unresolved gap was detected.
+}
+
+// pendingBlock is a block received ahead of the watermark, along with the message which carried it.
+type pendingBlock struct {
+	data *model.Data
+	msg  *transport.Message
+}
+
+// newSequencer returns a sequencer resuming from the stored watermark, which dead-letters to dl the buffered
+// blocks it cannot apply.
+func newSequencer(ctx context.Context, store storage.Store, ethClient *ethclient.Client, cfg *config.Config, members *membership, dl storage.DeadLetters) (*sequencer, error) {
+	watermark, err := store.GetWatermark(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching the contiguous watermark: %v", err)
+	}
+
+	return &sequencer{
+		store:     store,
+		ethClient: ethClient,
+		cfg:       cfg,
+		members:   members,
+		dl:        dl,
+		watermark: watermark,
+		pending:   make(map[uint64]*pendingBlock),
+	}, nil
+}
+
+// submit applies a block which extends the watermark, or is at or below it (redeliveries and reorgs), right
+// away, and buffers a block arriving ahead of a gap, returning `transport.ErrDeferred` so that its message is
+// settled once the block is applied. Blocks outside this subscriber's partition are not applied but still move
+// the watermark once their owner has stored them. It returns the number of attempts made to apply the block,
+// which is 0 when the block has been buffered or skipped.
+func (s *sequencer) submit(ctx context.Context, msg *transport.Message, blockData *model.Data) (int, error) {
+	n := blockData.Block.Header.Number.Uint64()
+	owned := s.members.owns(n)
+
+	s.mu.Lock()
+	// The very first block starts the contiguous range
+	if s.watermark == 0 && n > 0 {
+		s.watermark = n - 1
+	}
+	if n > s.highest {
+		s.highest = n
+	}
+
+	// A redelivered block replaces the buffered one, its message settling both
+	buffered := owned && n > s.watermark+1 && s.members.size() == 1
+	if buffered {
+		s.pending[n] = &pendingBlock{data: blockData, msg: msg}
+	}
+	overflow := len(s.pending) > s.cfg.REORDER_BUFFER_SIZE
+	s.mu.Unlock()
+
+	if !owned {
+		s.tryAdvance(ctx)
+		return 0, nil
+	}
+
+	if buffered {
+		if overflow && s.work.TryLock() {
+			s.backfill(ctx)
+			s.work.Unlock()
+		} else {
+			s.tryAdvance(ctx)
+		}
+		return 0, transport.ErrDeferred
+	}
+
+	attempts, err := s.apply(ctx, blockData)
+	if err != nil {
+		return attempts, err
+	}
+
+	s.tryAdvance(ctx)
+	return attempts, nil
+}
+
+// watch follows the open gaps until ctx is cancelled: the watermark moves on as soon as the missing blocks are
+// stored by a peer, and the gaps which are still open after `REORDER_WINDOW` are backfilled.
+func (s *sequencer) watch(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			gapSince := s.gapSince
+			s.mu.Unlock()
+			if gapSince.IsZero() {
+				continue
+			}
+
+			s.work.Lock()
+			if time.Since(gapSince) >= s.cfg.REORDER_WINDOW {
+				s.backfill(ctx)
+			} else {
+				s.advance(ctx)
+			}
+			s.work.Unlock()
+		}
+	}
+}
+
+// tryAdvance advances the watermark unless another pass is running, which moves it on anyway: a block it
+// misses leaves a gap, which watch closes at its next tick.
+func (s *sequencer) tryAdvance(ctx context.Context) {
+	if !s.work.TryLock() {
+		return
+	}
+	defer s.work.Unlock()
+
+	s.advance(ctx)
+}
+
+// advance moves the watermark over every following block which is either buffered or already stored, and
+// publishes it. The caller must hold s.work.
+func (s *sequencer) advance(ctx context.Context) {
+	for ctx.Err() == nil {
+		s.mu.Lock()
+		next := s.watermark + 1
+		buffered := s.pending[next]
+		s.mu.Unlock()
+
+		if buffered != nil {
+			if !s.applyPending(ctx, next, buffered) {
+				break
+			}
+		} else {
+			stored, err := s.store.IsBlockStored(ctx, next)
+			if err != nil {
+				log.Printf("error checking whether block %d is stored: %v\n", next, err)
+				break
+			}
+			if !stored {
+				break
+			}
+		}
+
+		s.mu.Lock()
+		s.watermark = max(s.watermark, next)
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	watermark := s.watermark
+	s.mu.Unlock()
+
+	shared, err := s.store.SetWatermark(ctx, watermark)
+	if err != nil {
+		log.Printf("error publishing the contiguous watermark: %v\n", err)
+	} else if shared > watermark {
+		// Another subscriber got further: apply what we buffered below its watermark and resume from there
+		for n, buffered := range s.pendingUpTo(shared) {
+			s.applyPending(ctx, n, buffered)
+		}
+
+		s.mu.Lock()
+		s.watermark = max(s.watermark, shared)
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.highest <= s.watermark {
+		s.gapSince = time.Time{}
+	} else if s.gapSince.IsZero() {
+		s.gapSince = time.Now()
+	}
+}
+
+// pendingUpTo returns the buffered blocks up to the given block number.
+func (s *sequencer) pendingUpTo(blockNumber uint64) map[uint64]*pendingBlock {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blocks := make(map[uint64]*pendingBlock)
+	for n, buffered := range s.pending {
+		if n <= blockNumber {
+			blocks[n] = buffered
+		}
+	}
+	return blocks
+}
+
+// applyPending applies a buffered block, removes it from the buffer and acknowledges its message. A block which
+// still cannot be applied after `RETRY_ATTEMPTS` is dead-lettered and acknowledged, leaving its height to the
+// backfill, while one interrupted by shutdown stays unacknowledged so that it is redelivered. It reports
+// whether the block was applied.
+func (s *sequencer) applyPending(ctx context.Context, blockNumber uint64, buffered *pendingBlock) bool {
+	attempts, err := s.apply(ctx, buffered.data)
+	if err != nil && ctx.Err() != nil {
+		return false
+	}
+
+	s.mu.Lock()
+	if s.pending[blockNumber] == buffered {
+		delete(s.pending, blockNumber)
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		deadLetter(ctx, s.dl, buffered.msg, blockNumber, attempts, fmt.Errorf("error applying buffered block: %v", err))
+	}
+	buffered.msg.Settle(nil)
+	return err == nil
+}
+
+// backfill fetches the blocks missing between the watermark and the highest block received from the node.
+// Heights older than the `RETENTION_BLOCKS` window would be evicted right away, so they are skipped rather
+// than fetched, and the blocks buffered at those heights acknowledged without being applied. The caller must
+// hold s.work.
+func (s *sequencer) backfill(ctx context.Context) {
+	s.mu.Lock()
+	if s.highest <= s.watermark {
+		s.mu.Unlock()
+		return
+	}
+
+	from, highest := s.watermark+1, s.highest
+	var skipped []*pendingBlock
+	if window := uint64(s.cfg.RETENTION_BLOCKS); window > 0 && highest > window && from < highest-window {
+		log.Printf("Skipping blocks %d to %d, which are older than the retained window\n", from, highest-window-1)
+		from = highest - window
+		s.watermark = from - 1
+		for n, buffered := range s.pending {
+			if n < from {
+				skipped = append(skipped, buffered)
+				delete(s.pending, n)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	for _, buffered := range skipped {
+		buffered.msg.Settle(nil)
+	}
+
+	log.Printf("Gap after block %d, backfilling missing blocks up to %d\n", from-1, highest)
+	for n := from; n <= highest && ctx.Err() == nil; n++ {
+		s.mu.Lock()
+		_, buffered := s.pending[n]
+		s.mu.Unlock()
+		if buffered {
+			continue
+		}
+
+		stored, err := s.store.IsBlockStored(ctx, n)
+		if err != nil {
+			log.Printf("error checking whether block %d is stored: %v\n", n, err)
+			break
+		}
+		if stored {
+			continue
+		}
+
+		if err := s.fetchAndApply(ctx, n); err != nil {
+			log.Printf("error backfilling block %d: %v\n", n, err)
+			break
+		}
+		s.store.IncrStat(ctx, storage.STAT_BACKFILLED)
+	}
+
+	// Restart the window so that a failed backfill is retried later rather than on every tick
+	s.mu.Lock()
+	s.gapSince = time.Time{}
+	s.mu.Unlock()
+
+	s.advance(ctx)
+}
+
+// fetchAndApply fetches a block along with its events from the node and applies it.
+func (s *sequencer) fetchAndApply(ctx context.Context, blockNumber uint64) error {
+	block, err := s.ethClient.BlockByNumber(ctx, new(big.Int).SetUint64(blockNumber))
+	if err != nil {
+		return err
+	}
+
+	blockData, err := model.NewBlockData(s.ethClient, block, s.cfg.INGEST_DETAIL)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.apply(ctx, blockData)
+	return err
+}
+
+// apply stores a block, retrying with backoff, and returns the number of attempts made.
+func (s *sequencer) apply(ctx context.Context, blockData *model.Data) (int, error) {
+	return util.Retry(ctx, s.cfg.RETRY_ATTEMPTS, s.cfg.RETRY_BASE_DELAY, func() error {
+		_, err := s.store.ApplyBlockData(ctx, blockData)
+		return err
+	})
+}
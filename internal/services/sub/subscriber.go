@@ -2,42 +2,133 @@ package sub
 
 import (
 	"context"
+	"errors"
+	"ethereum-data-service/internal/client"
 	"ethereum-data-service/internal/config"
+	"ethereum-data-service/internal/dlq"
+	"ethereum-data-service/internal/model"
 	"ethereum-data-service/internal/storage"
+	"ethereum-data-service/internal/transport"
 	"ethereum-data-service/pkg/util"
+	"fmt"
 	"log"
-
-	"github.com/redis/go-redis/v9"
 )
 
-// RunBlockSubscriberSvc: Subscribes to a Redis channel and stores incoming block data to storage.
-func RunBlockSubscriberSvc(rdb *redis.Client, cfg *config.Config, shutdown chan struct{}) {
+// RunBlockSubscriberSvc: Consumes the configured transport and stores incoming block data to storage.
+// With an acknowledging transport (Redis Streams, NATS JetStream) a message is acknowledged only once its
+// block has been stored, so blocks published while the subscriber is down or restarting are delivered
+// when it comes back (at-least-once delivery). Blocks are applied in height order, missing heights being
+// backfilled from the node through the HTTPS client.
+func RunBlockSubscriberSvc(client *client.Client, cfg *config.Config, shutdown chan struct{}) {
 	// Create a common context instance
 	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	// Handle OS signals for graceful shutdown
 	go util.HandleGracefulShutdown(cancel, shutdown)
 
-	log.Printf("Subscribed to Redis channel: %s\n", cfg.REDIS_PUBSUB_CH)
-
-	// Subscribe to the Redis channel
-	subscriber := rdb.Subscribe(ctx, cfg.REDIS_PUBSUB_CH)
-
-	// Channel to receive messages
-	ch := subscriber.Channel()
-
-	for {
-		select {
-		case <-shutdown:
-			log.Println("Shutting down BlockSubscriber service...")
-			subscriber.Close()
-			cancel()
-			return
-		case msg := <-ch:
-			err := storage.AddBlockDataToDB(ctx, rdb, []byte(msg.Payload), cfg.REDIS_KEY_EXPIRY_TIME)
-			if err != nil {
-				log.Printf("error adding block data to storage: %v\n", err)
-			}
+	tr, err := transport.New(transportConfig(cfg), client.REDIS)
+	if err != nil {
+		log.Printf("error initializing %s transport: %v\n", cfg.TRANSPORT, err)
+		return
+	}
+	defer tr.Close()
+
+	err = Run(ctx, client, cfg, tr, func() {})
+	if err != nil {
+		log.Printf("error in block subscriber: %v\n", err)
+	}
+
+	log.Println("Shutting down BlockSubscriber service...")
+}
+
+// Run consumes tr and stores every incoming block until ctx is cancelled. It calls ready once it starts consuming.
+func Run(ctx context.Context, client *client.Client, cfg *config.Config, tr transport.Transport, ready func()) error {
+	dl, err := dlq.Open(client)
+	if err != nil {
+		return err
+	}
+
+	members, err := newMembership(ctx, client.REDIS, cfg, tr)
+	if err != nil {
+		return err
+	}
+	go members.run(ctx)
+
+	seq, err := newSequencer(ctx, client.STORE, client.ETH_HTTPS, cfg, members, dl)
+	if err != nil {
+		return err
+	}
+	go seq.watch(ctx)
+	fenced := &fence{}
+
+	log.Printf("Subscribed to blocks via %s transport\n", cfg.TRANSPORT)
+	ready()
+
+	err = tr.Subscribe(ctx, func(ctx context.Context, msg *transport.Message) error {
+		return handleMessage(ctx, dl, seq, fenced, cfg, msg)
+	})
+
+	// Its partition is rebalanced onto the other members, so the consumer of this member is not read anymore
+	members.removeConsumer(context.Background(), cfg.SUBSCRIBER_ID)
+	return err
+}
+
+// handleMessage validates the envelope of an incoming message and hands the block it carries to the sequencer,
+// which applies it in order, retrying with backoff. Blocks which are already stored are recognised by their hash
+// and skipped. Messages with an invalid envelope, and blocks which still cannot be stored after `RETRY_ATTEMPTS`,
+// are dead-lettered and acknowledged. So are messages which the transport delivered `MAX_DELIVERIES` times without
+// any of the deliveries being processed (the subscriber stopping each time), but these are only acknowledged once
+// dead-lettered. Blocks published by a superseded notifier are dropped (see fence).
+func handleMessage(ctx context.Context, dl storage.DeadLetters, seq *sequencer, fenced *fence, cfg *config.Config, msg *transport.Message) error {
+	env, blockData, err := model.OpenEnvelope(msg.Payload)
+	if err == nil {
+		err = env.CheckChain(cfg.CHAIN_ID)
+	}
+	if err != nil {
+		// Redelivering an invalid envelope cannot make it valid
+		deadLetter(ctx, dl, msg, 0, 1, err)
+		return nil
+	}
+
+	if !fenced.admit(env, msg) {
+		log.Printf("Dropping block %d from a superseded notifier (fencing token %d)\n", env.BlockNumber, env.FencingToken)
+		return nil
+	}
+
+	if msg.Exhausted {
+		return deadLetter(ctx, dl, msg, env.BlockNumber, int(msg.Attempts-1), fmt.Errorf("not processed after %d deliveries", msg.Attempts-1))
+	}
+
+	attempts, err := seq.submit(ctx, msg, blockData)
+	if errors.Is(err, transport.ErrDeferred) {
+		return err
+	}
+	if err != nil {
+		// Leave the message unacknowledged when shutting down so that it is redelivered
+		if ctx.Err() != nil {
+			return err
 		}
+		deadLetter(ctx, dl, msg, env.BlockNumber, attempts, err)
+	}
+
+	return nil
+}
+
+// deadLetter records a message which could not be processed in the dead-letter store. The error is logged
+// and returned.
+func deadLetter(ctx context.Context, dl storage.DeadLetters, msg *transport.Message, blockNumber uint64, attempts int, reason error) error {
+	log.Printf("dead-lettering message %s (block %d) after %d attempt(s): %v\n", msg.ID, blockNumber, attempts, reason)
+
+	err := dlq.Add(ctx, dl, &dlq.Entry{
+		Source:      dlq.SOURCE_SUB,
+		BlockNumber: blockNumber,
+		Payload:     msg.Payload,
+		Error:       reason.Error(),
+		Attempts:    attempts,
+	})
+	if err != nil {
+		log.Printf("error dead-lettering message %s: %v\n", msg.ID, err)
 	}
+	return err
 }
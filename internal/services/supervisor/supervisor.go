@@ -0,0 +1,103 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// minBackoff is the delay before a crashed service is restarted the first time.
+	minBackoff = time.Second
+	// maxBackoff caps the delay between two restarts of a service which keeps crashing.
+	maxBackoff = 30 * time.Second
+	// stableAfter is how long a service has to run before its restart backoff is reset.
+	stableAfter = time.Minute
+)
+
+// Service is a long-running (or one-shot) unit of work managed by the supervisor.
+type Service struct {
+	// Name identifies the service in the logs.
+	Name string
+	// Run runs the service until ctx is cancelled. It calls ready once the services started after it may start.
+	// Returning nil before ctx is cancelled means the service completed its work and is not restarted, while
+	// returning an error (or panicking) means it crashed and is restarted with backoff.
+	Run func(ctx context.Context, ready func()) error
+}
+
+// Run starts the services one after the other, each one only once the previous one called ready or completed,
+// and restarts crashed services with an exponential backoff. It returns when ctx is cancelled and all the services
+// have stopped.
+func Run(ctx context.Context, services []Service) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for _, svc := range services {
+		ready := make(chan struct{})
+
+		wg.Add(1)
+		go func(svc Service) {
+			defer wg.Done()
+			supervise(ctx, svc, ready)
+		}(svc)
+
+		// Start the next service only once this one is ready
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// supervise runs a single service, restarting it whenever it crashes, and closes ready once it is ready.
+func supervise(ctx context.Context, svc Service, ready chan struct{}) {
+	var once sync.Once
+	markReady := func() {
+		once.Do(func() { close(ready) })
+	}
+
+	backoff := minBackoff
+	for {
+		log.Printf("Starting service %s", svc.Name)
+		startTime := time.Now()
+
+		err := runSafely(ctx, svc, markReady)
+		if ctx.Err() != nil {
+			log.Printf("Service %s stopped", svc.Name)
+			return
+		}
+
+		if err == nil {
+			log.Printf("Service %s completed in %s", svc.Name, time.Since(startTime))
+			markReady()
+			return
+		}
+
+		if time.Since(startTime) >= stableAfter {
+			backoff = minBackoff
+		}
+
+		log.Printf("Service %s crashed: %v. Restarting in %s", svc.Name, err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// runSafely runs the service and turns a panic into an error.
+func runSafely(ctx context.Context, svc Service, ready func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return svc.Run(ctx, ready)
+}
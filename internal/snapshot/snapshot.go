@@ -0,0 +1,278 @@
+package snapshot
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"ethereum-data-service/internal/config"
+	"ethereum-data-service/internal/model"
+	"ethereum-data-service/internal/storage"
+	"ethereum-data-service/pkg/enum"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	eth_err "ethereum-data-service/pkg/err"
+)
+
+const (
+	// FORMAT identifies snapshot files in their header.
+	FORMAT = "ethereum-data-service/snapshot"
+	// VERSION is the version of the snapshot format written by this build. Bump it whenever the format changes, and
+	// keep reading the previous versions.
+	VERSION = 1
+
+	// maxLineSize bounds the size of a line of a snapshot, a block with its transactions and events.
+	maxLineSize = 256 << 20
+	// progressInterval is the number of blocks between two progress reports of an export or an import.
+	progressInterval = 1000
+)
+
+// Header is the first line of a snapshot, describing the window it holds.
+type Header struct {
+	Format    string `json:"format"`
+	Version   int    `json:"version"`
+	ChainID   uint64 `json:"chain_id"`
+	CreatedAt int64  `json:"created_at"` // CreatedAt is the time (Unix milliseconds) the export started.
+	FromBlock uint64 `json:"from_block"`
+	ToBlock   uint64 `json:"to_block"`
+	Watermark uint64 `json:"watermark"`
+}
+
+// Record is a line of a snapshot following the header: a stored block along with its transactions and events.
+type Record struct {
+	ExpiresAt int64       `json:"expires_at,omitempty"` // ExpiresAt is the time (Unix milliseconds) the block expires at, 0 if it does not.
+	Data      *model.Data `json:"data"`
+}
+
+// Result reports the outcome of an export or an import.
+type Result struct {
+	Header  *Header
+	Blocks  int // Blocks is the number of blocks exported or imported.
+	Expired int // Expired is the number of blocks not imported because their original expiry has passed.
+}
+
+// Export writes every block of the store, along with its transactions and events, to a snapshot at path: a
+// gzip-compressed NDJSON file holding a Header, then a Record per block in height order. The file is written
+// through a temporary file and a rename, so that an interrupted export leaves no partial snapshot. Blocks evicted
+// while the export runs are left out.
+func Export(ctx context.Context, store storage.Store, cfg *config.Config, path string) (*Result, error) {
+	keys, err := store.GetAllBlockNumbers(ctx, storage.Page{})
+	if err != nil {
+		return nil, err
+	}
+	numbers := make([]string, 0, len(keys))
+	for _, key := range keys {
+		numbers = append(numbers, strings.TrimPrefix(key, storage.BLOCK_PREFIX))
+	}
+
+	watermark, err := store.GetWatermark(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	header := &Header{Format: FORMAT, Version: VERSION, ChainID: cfg.CHAIN_ID, CreatedAt: time.Now().UnixMilli(), Watermark: watermark}
+	if len(numbers) > 0 {
+		header.FromBlock, _ = strconv.ParseUint(numbers[0], 10, 64)
+		header.ToBlock, _ = strconv.ParseUint(numbers[len(numbers)-1], 10, 64)
+	}
+
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s: %v", tmp, err)
+	}
+	defer os.Remove(tmp)
+	defer file.Close()
+
+	result := &Result{Header: header}
+	zw := gzip.NewWriter(file)
+	enc := json.NewEncoder(zw)
+	if err := enc.Encode(header); err != nil {
+		return nil, fmt.Errorf("error writing snapshot header: %v", err)
+	}
+
+	expiring, _ := store.(storage.Expiring)
+	for _, number := range numbers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		record, err := readRecord(ctx, store, expiring, number)
+		if errors.Is(err, eth_err.ErrNotFound) {
+			log.Printf("Block %s was evicted during the export, leaving it out\n", number)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := enc.Encode(record); err != nil {
+			return nil, fmt.Errorf("error writing block %s to snapshot: %v", number, err)
+		}
+
+		result.Blocks++
+		if result.Blocks%progressInterval == 0 {
+			log.Printf("Exported %d of %d blocks\n", result.Blocks, len(numbers))
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("error writing %s: %v", tmp, err)
+	}
+	if err := file.Sync(); err != nil {
+		return nil, fmt.Errorf("error syncing %s: %v", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, fmt.Errorf("error renaming %s: %v", tmp, err)
+	}
+
+	return result, nil
+}
+
+// readRecord reads a stored block and its expiry, when the store has one.
+func readRecord(ctx context.Context, store storage.Store, expiring storage.Expiring, blockNumber string) (*Record, error) {
+	blockData, err := store.GetBlockData(ctx, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &Record{Data: blockData}
+	if expiring != nil {
+		expiresAt, err := expiring.GetBlockExpiry(ctx, blockNumber)
+		if err != nil {
+			return nil, err
+		}
+		if !expiresAt.IsZero() {
+			record.ExpiresAt = expiresAt.UnixMilli()
+		}
+	}
+
+	return record, nil
+}
+
+// Import restores the snapshot at path into the store, which must be empty, through the regular write path so that
+// every index is rebuilt, and restores the watermark. With the `original` expiry, blocks expire when they would
+// have in the exported store, the ones past their expiry are left out, and the ones which had no expiry (as every
+// block exported from a store without one) keep none. With `refresh`, or when the store has no expiry, blocks get
+// the fresh expiry of the store. The snapshot must come from the configured `CHAIN_ID`, unless either is 0. The
+// services writing to the store must be stopped during the import: a block they store first makes the import
+// skip it, and the watermark restored last would not cover the blocks they stored in between.
+func Import(ctx context.Context, store storage.Store, cfg *config.Config, path string, expiry enum.SnapshotExpiry) (*Result, error) {
+	if expiry != enum.ORIGINAL && expiry != enum.REFRESH {
+		return nil, eth_err.ErrInvalidSnapshotExpiry
+	}
+
+	stored, err := store.GetAllBlockNumbers(ctx, storage.Page{Limit: 1})
+	if err != nil {
+		return nil, err
+	}
+	if len(stored) > 0 {
+		return nil, fmt.Errorf("error importing snapshot: %w, it holds %s", eth_err.ErrStoreNotEmpty, stored[0])
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", path, err)
+	}
+	defer file.Close()
+
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w: %v", path, eth_err.ErrInvalidSnapshot, err)
+	}
+	defer zr.Close()
+
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(nil, maxLineSize)
+
+	header, err := readHeader(scanner, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{Header: header}
+	expiring, _ := store.(storage.Expiring)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil || record.Data == nil || record.Data.Block.Header == nil {
+			return nil, fmt.Errorf("error reading block %d of snapshot: %w", result.Blocks+result.Expired+1, eth_err.ErrInvalidSnapshot)
+		}
+
+		applied, err := importRecord(ctx, store, expiring, &record, expiry)
+		if err != nil {
+			return nil, fmt.Errorf("error importing block %s: %v", record.Data.Block.Header.Number, err)
+		}
+		if !applied {
+			result.Expired++
+			continue
+		}
+
+		result.Blocks++
+		if result.Blocks%progressInterval == 0 {
+			log.Printf("Imported %d blocks\n", result.Blocks)
+		}
+	}
+	// A truncated snapshot fails the gzip checksum here
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %s: %w: %v", path, eth_err.ErrInvalidSnapshot, err)
+	}
+
+	if header.Watermark > 0 {
+		if _, err := store.SetWatermark(ctx, header.Watermark); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// readHeader reads the header of a snapshot and checks that this build can import it.
+func readHeader(scanner *bufio.Scanner, cfg *config.Config) (*Header, error) {
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("error reading snapshot header: %w: %v", eth_err.ErrInvalidSnapshot, scanner.Err())
+	}
+
+	var header Header
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Format != FORMAT {
+		return nil, fmt.Errorf("error reading snapshot header: %w", eth_err.ErrInvalidSnapshot)
+	}
+	if header.Version < 1 || header.Version > VERSION {
+		return nil, fmt.Errorf("%w: version %d, this build reads up to version %d", eth_err.ErrInvalidSnapshot, header.Version, VERSION)
+	}
+	if cfg.CHAIN_ID != 0 && header.ChainID != 0 && header.ChainID != cfg.CHAIN_ID {
+		return nil, fmt.Errorf("%w: snapshot of chain %d, expected chain %d", eth_err.ErrInvalidSnapshot, header.ChainID, cfg.CHAIN_ID)
+	}
+
+	return &header, nil
+}
+
+// importRecord stores the block of a record, with its original expiry if asked and possible. It reports false,
+// storing nothing, when the original expiry has already passed.
+func importRecord(ctx context.Context, store storage.Store, expiring storage.Expiring, record *Record, expiry enum.SnapshotExpiry) (bool, error) {
+	if expiry == enum.REFRESH || expiring == nil {
+		_, err := store.ApplyBlockData(ctx, record.Data)
+		return err == nil, err
+	}
+
+	// The block did not expire in the exported store, so it keeps no expiry
+	if record.ExpiresAt == 0 {
+		_, err := expiring.ApplyBlockDataWithExpiry(ctx, record.Data, 0)
+		return err == nil, err
+	}
+
+	ttl := time.Until(time.UnixMilli(record.ExpiresAt))
+	if ttl <= 0 {
+		return false, nil
+	}
+	_, err := expiring.ApplyBlockDataWithExpiry(ctx, record.Data, ttl)
+	return err == nil, err
+}
@@ -0,0 +1,681 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"ethereum-data-service/internal/config"
+	"ethereum-data-service/internal/model"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	eth_err "ethereum-data-service/pkg/err"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	bolt "go.etcd.io/bbolt"
+)
+
+// BOLT_FILE is the name of the database file of the `bolt` backend within `DATA_DIR`.
+const BOLT_FILE = "ethereum.db"
+
+var (
+	// boltDataBucket holds the values under the very same keys as in Redis (`block:<number>`, `tx:<hash>`, ...).
+	boltDataBucket = []byte("data")
+	// boltBlocksBucket indexes the block keys by big-endian block number, so that a cursor walks them in height order.
+	boltBlocksBucket = []byte(BLOCKS_INDEX_KEY)
+	// boltAddressBucket indexes the event keys by `<address>/<block number><log index>`, both big-endian, so that
+	// the events of an address are a range of keys ordered as in the Redis index.
+	boltAddressBucket = []byte("idx:addr")
+	// boltTopicBucket indexes the event keys by `<topic position>:<topic>/<block number><log index>`, as the
+	// address index does.
+	boltTopicBucket = []byte("idx:topic")
+	// boltManifestBucket holds a nested bucket per block number, listing every entry written for the block as
+	// `<bucket>\x00<key>`, so that the block can be evicted as a whole.
+	boltManifestBucket = []byte("manifest")
+	// boltStatsBucket holds the ingestion counters, as the `stats:sub` hash does in Redis.
+	boltStatsBucket = []byte(STATS_KEY)
+	// boltMetaBucket holds single values such as the contiguous watermark.
+	boltMetaBucket = []byte("meta")
+	// boltDeadLetterBucket holds the dead-lettered entries by ID, as the `dlq:entries` hash does in Redis, its
+	// sequence numbering them.
+	boltDeadLetterBucket = []byte("dlq")
+
+	boltBuckets = [][]byte{boltDataBucket, boltBlocksBucket, boltAddressBucket, boltTopicBucket, boltManifestBucket, boltStatsBucket, boltMetaBucket, boltDeadLetterBucket}
+)
+
+// BoltStore is the embedded implementation of Store, keeping block data in a single bbolt file within `DATA_DIR`.
+// It follows the Redis key layout, with range scans over ordered buckets in place of sorted sets. Every block is
+// written, and the blocks falling out of the `RETENTION_BLOCKS` window are evicted, in one read-write transaction.
+// There is no TTL: a window of 0 keeps every block. The file is locked by the process which opens it, so the
+// backend is meant for single-process deployments (`go run main.go all`).
+type BoltStore struct {
+	db              *bolt.DB
+	codec           model.Codec
+	retentionBlocks int
+}
+
+// OpenBoltStore opens (creating it if needed) the database in `DATA_DIR`, waiting up to `DEFAULT_TIMEOUT` for
+// another process to release it.
+func OpenBoltStore(cfg *config.Config) (*BoltStore, error) {
+	if err := os.MkdirAll(cfg.DATA_DIR, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating data directory: %v", err)
+	}
+
+	path := filepath.Join(cfg.DATA_DIR, BOLT_FILE)
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: cfg.DEFAULT_TIMEOUT})
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range boltBuckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating buckets in %s: %v", path, err)
+	}
+
+	return &BoltStore{
+		db:              db,
+		codec:           cfg.STORAGE_CODEC,
+		retentionBlocks: cfg.RETENTION_BLOCKS,
+	}, nil
+}
+
+// ApplyBlockData stores the block data in a single transaction, skipping duplicates and replacing conflicting
+// blocks as the Redis backend does (see `storage.ApplyBlockData`).
+func (s *BoltStore) ApplyBlockData(ctx context.Context, blockData *model.Data) (ApplyResult, error) {
+	header := blockData.Block.Header
+	number, hash := header.Number.Uint64(), header.Hash().Hex()
+
+	result := APPLIED
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		storedHash := tx.Bucket(boltDataBucket).Get([]byte(fmt.Sprint(BLOCK_HASH_PREFIX, number)))
+		switch {
+		case storedHash == nil:
+			// First time we see this block number
+		case string(storedHash) == hash:
+			log.Printf("Skipping duplicate block %d (%s)\n", number, hash)
+			result = DUPLICATE
+			return incrBoltStat(tx, STAT_DUPLICATES)
+		default:
+			log.Printf("Block %d conflicts with the stored one: %s replaces %s\n", number, hash, storedHash)
+			if err := removeBoltBlock(tx, number); err != nil {
+				return err
+			}
+			if err := incrBoltStat(tx, STAT_CONFLICTS); err != nil {
+				return err
+			}
+			result = REORGED
+		}
+
+		if err := s.putBlockData(tx, blockData); err != nil {
+			return err
+		}
+
+		if err := s.evict(tx, number); err != nil {
+			return err
+		}
+
+		return incrBoltStat(tx, STAT_APPLIED)
+	})
+	if err != nil {
+		return APPLIED, fmt.Errorf("error storing block %d in %s: %v", number, s.db.Path(), err)
+	}
+
+	if result != DUPLICATE {
+		log.Printf("Stored block %d in %s\n", number, s.db.Path())
+	}
+	return result, nil
+}
+
+// putBlockData writes the block, its hash, its transactions and its events along with their index entries,
+// recording every entry in the block manifest.
+func (s *BoltStore) putBlockData(tx *bolt.Tx, blockData *model.Data) error {
+	header := blockData.Block.Header
+	number := header.Number.Uint64()
+
+	manifest, err := tx.Bucket(boltManifestBucket).CreateBucketIfNotExists(heightKey(number))
+	if err != nil {
+		return err
+	}
+
+	put := func(bucket, key, value []byte) error {
+		if err := tx.Bucket(bucket).Put(key, value); err != nil {
+			return err
+		}
+		return manifest.Put(manifestEntry(bucket, key), []byte{})
+	}
+
+	blockKey := fmt.Sprint(BLOCK_PREFIX, number)
+	blockValue, err := s.codec.MarshalBlock(&blockData.Block)
+	if err != nil {
+		return fmt.Errorf("error marshalling block data: %v", err)
+	}
+	if err := put(boltDataBucket, []byte(blockKey), blockValue); err != nil {
+		return err
+	}
+	if err := put(boltBlocksBucket, heightKey(number), []byte(blockKey)); err != nil {
+		return err
+	}
+	if err := put(boltDataBucket, []byte(fmt.Sprint(BLOCK_HASH_PREFIX, number)), []byte(header.Hash().Hex())); err != nil {
+		return err
+	}
+	if err := put(boltDataBucket, []byte(BLOCK_NUMBER_PREFIX+strings.ToLower(header.Hash().Hex())), []byte(header.Number.String())); err != nil {
+		return err
+	}
+
+	for txHash, t := range blockData.TransactionHashes {
+		value, err := s.codec.MarshalTx(t)
+		if err != nil {
+			return fmt.Errorf("error marshalling transaction %s: %v", txHash, err)
+		}
+		if err := put(boltDataBucket, []byte(TX_PREFIX+txHash), value); err != nil {
+			return err
+		}
+	}
+
+	for txHash, events := range blockData.Events {
+		for _, event := range events {
+			value, err := s.codec.MarshalLog(event)
+			if err != nil {
+				return fmt.Errorf("error marshalling event %+v: %v", event, err)
+			}
+
+			address := strings.ToLower(event.Address.Hex())
+			eventKey := fmt.Sprint(EVENT_PREFIX, address, "_", event.BlockNumber, "_", txHash, "_", event.Index)
+			if err := put(boltDataBucket, []byte(eventKey), value); err != nil {
+				return err
+			}
+			if err := put(boltAddressBucket, eventIndexKey(address, event.BlockNumber, event.Index), []byte(eventKey)); err != nil {
+				return err
+			}
+			for position, topic := range event.Topics {
+				if position >= MAX_TOPICS {
+					break
+				}
+				topicKey := eventIndexKey(fmt.Sprint(position, ":", strings.ToLower(topic.Hex())), event.BlockNumber, event.Index)
+				if err := put(boltTopicBucket, topicKey, []byte(eventKey)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// evict removes the blocks which fall out of the retention window once the block with the given number is
+// committed, the window being counted from the highest stored block as in `retentionEvictions`.
+func (s *BoltStore) evict(tx *bolt.Tx, blockNumber uint64) error {
+	if s.retentionBlocks <= 0 {
+		return nil
+	}
+
+	head := blockNumber
+	c := tx.Bucket(boltBlocksBucket).Cursor()
+	if k, _ := c.Last(); k != nil && binary.BigEndian.Uint64(k) > head {
+		head = binary.BigEndian.Uint64(k)
+	}
+	if head < uint64(s.retentionBlocks) {
+		return nil
+	}
+	cutoff := head - uint64(s.retentionBlocks)
+
+	// Collect first, a cursor must not be used while its bucket is modified
+	var evicted []uint64
+	for k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k) <= cutoff; k, _ = c.Next() {
+		evicted = append(evicted, binary.BigEndian.Uint64(k))
+	}
+
+	for _, n := range evicted {
+		if err := removeBoltBlock(tx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeBoltBlock deletes every entry listed in the manifest of the block with the given number, then the manifest.
+func removeBoltBlock(tx *bolt.Tx, blockNumber uint64) error {
+	manifests := tx.Bucket(boltManifestBucket)
+	manifest := manifests.Bucket(heightKey(blockNumber))
+	if manifest == nil {
+		return nil
+	}
+
+	err := manifest.ForEach(func(entry, _ []byte) error {
+		bucket, key, _ := bytes.Cut(entry, []byte{0})
+		return tx.Bucket(bucket).Delete(key)
+	})
+	if err != nil {
+		return fmt.Errorf("error removing block %d: %v", blockNumber, err)
+	}
+
+	return manifests.DeleteBucket(heightKey(blockNumber))
+}
+
+func (s *BoltStore) RemoveBlockData(ctx context.Context, blockNumber string) error {
+	number, err := strconv.ParseUint(blockNumber, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid block number %q: %v", blockNumber, err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return removeBoltBlock(tx, number)
+	})
+}
+
+func (s *BoltStore) IsBlockStored(ctx context.Context, blockNumber uint64) (bool, error) {
+	stored := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		stored = tx.Bucket(boltBlocksBucket).Get(heightKey(blockNumber)) != nil
+		return nil
+	})
+	return stored, err
+}
+
+func (s *BoltStore) GetEventsByAddress(ctx context.Context, address string, page Page) ([]*types.Log, error) {
+	events := make([]*types.Log, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltDataBucket)
+		prefix := []byte(address + "/")
+
+		// Seek to the cursor, which is skipped if it is still indexed
+		start, after := prefix, []byte(nil)
+		if page.After != nil {
+			after = eventIndexKey(address, page.After.BlockNumber, page.After.LogIndex)
+			start = after
+		}
+
+		c := tx.Bucket(boltAddressBucket).Cursor()
+		for k, eventKey := c.Seek(start); k != nil && bytes.HasPrefix(k, prefix) && !page.full(len(events)); k, eventKey = c.Next() {
+			if bytes.Equal(k, after) {
+				continue
+			}
+			value := data.Get(eventKey)
+			if value == nil {
+				continue
+			}
+
+			event, err := model.UnmarshalLog(bytes.Clone(value))
+			if err != nil {
+				return fmt.Errorf("error unmarshalling event: %v", err)
+			}
+			events = append(events, event)
+		}
+		return nil
+	})
+	return events, err
+}
+
+func (s *BoltStore) GetLogs(ctx context.Context, filter *LogFilter, page Page) ([]*types.Log, error) {
+	events := make([]*types.Log, 0)
+	filter = page.filter(filter)
+	if filter.FromBlock > filter.ToBlock {
+		return events, nil
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltDataBucket)
+		for _, eventKey := range boltFilteredEventKeys(tx, filter) {
+			value := data.Get(eventKey)
+			if value == nil {
+				continue
+			}
+
+			event, err := model.UnmarshalLog(bytes.Clone(value))
+			if err != nil {
+				return fmt.Errorf("error unmarshalling event: %v", err)
+			}
+			if filter.Matches(event) {
+				events = append(events, event)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return page.logs(sortLogs(events)), nil
+}
+
+// boltFilteredEventKeys returns the keys of the events within the block range of the smallest index set the
+// filter can be served from, or of every event of the blocks in range, as `filteredEventKeys` does in Redis.
+// The keys are only valid within tx.
+func boltFilteredEventKeys(tx *bolt.Tx, filter *LogFilter) [][]byte {
+	options := filter.indexOptions("")
+	if len(options) == 0 {
+		var keys [][]byte
+		manifests := tx.Bucket(boltManifestBucket)
+		prefix := manifestEntry(boltDataBucket, []byte(EVENT_PREFIX))
+
+		c := tx.Bucket(boltBlocksBucket).Cursor()
+		for k, _ := c.Seek(heightKey(filter.FromBlock)); k != nil && binary.BigEndian.Uint64(k) <= filter.ToBlock; k, _ = c.Next() {
+			manifest := manifests.Bucket(k)
+			if manifest == nil {
+				continue
+			}
+			mc := manifest.Cursor()
+			for entry, _ := mc.Seek(prefix); entry != nil && bytes.HasPrefix(entry, prefix); entry, _ = mc.Next() {
+				_, key, _ := bytes.Cut(entry, []byte{0})
+				keys = append(keys, key)
+			}
+		}
+		return keys
+	}
+
+	var best [][]byte
+	for i, indexKeys := range options {
+		var keys [][]byte
+		for _, indexKey := range indexKeys {
+			// The options name the Redis indexes, which map to a range of the address or topic bucket
+			bucket, prefix := boltAddressBucket, strings.TrimPrefix(indexKey, ADDRESS_INDEX_PREFIX)
+			if strings.HasPrefix(indexKey, TOPIC_INDEX_PREFIX) {
+				bucket, prefix = boltTopicBucket, strings.TrimPrefix(indexKey, TOPIC_INDEX_PREFIX)
+			}
+
+			start := eventIndexKey(prefix, filter.FromBlock, 0)
+			end := eventIndexKey(prefix, filter.ToBlock, 1<<logIndexBits-1)
+			c := tx.Bucket(bucket).Cursor()
+			for k, eventKey := c.Seek(start); k != nil && bytes.Compare(k, end) <= 0; k, eventKey = c.Next() {
+				keys = append(keys, eventKey)
+			}
+		}
+		if i == 0 || len(keys) < len(best) {
+			best = keys
+		}
+	}
+
+	return best
+}
+
+func (s *BoltStore) GetBlockByNumber(ctx context.Context, blockNumber string) (*model.Block, error) {
+	value, err := s.get(BLOCK_PREFIX + blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, fmt.Errorf("block %s: %w", blockNumber, eth_err.ErrNotFound)
+	}
+
+	return model.UnmarshalBlock(value)
+}
+
+func (s *BoltStore) GetBlockByHash(ctx context.Context, blockHash string) (*model.Block, error) {
+	blockNumber, err := s.get(BLOCK_NUMBER_PREFIX + strings.ToLower(blockHash))
+	if err != nil {
+		return nil, err
+	}
+	if blockNumber == nil {
+		return nil, fmt.Errorf("block %s: %w", blockHash, eth_err.ErrNotFound)
+	}
+
+	return s.GetBlockByNumber(ctx, string(blockNumber))
+}
+
+func (s *BoltStore) GetTransactionByHash(ctx context.Context, txHash string) (*types.Transaction, error) {
+	value, err := s.get(TX_PREFIX + txHash)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, fmt.Errorf("transaction %s: %w", txHash, eth_err.ErrNotFound)
+	}
+
+	return model.UnmarshalTx(value)
+}
+
+func (s *BoltStore) GetBlockData(ctx context.Context, blockNumber string) (*model.Data, error) {
+	number, err := strconv.ParseUint(blockNumber, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("block %s: %w", blockNumber, eth_err.ErrNotFound)
+	}
+
+	var blockValue []byte
+	var eventValues [][]byte
+	err = s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltDataBucket)
+		blockValue = bytes.Clone(data.Get([]byte(BLOCK_PREFIX + blockNumber)))
+
+		manifest := tx.Bucket(boltManifestBucket).Bucket(heightKey(number))
+		if blockValue == nil || manifest == nil {
+			return nil
+		}
+
+		// Event keys are listed in the manifest as entries of the data bucket
+		prefix := manifestEntry(boltDataBucket, []byte(EVENT_PREFIX))
+		c := manifest.Cursor()
+		for entry, _ := c.Seek(prefix); entry != nil && bytes.HasPrefix(entry, prefix); entry, _ = c.Next() {
+			_, key, _ := bytes.Cut(entry, []byte{0})
+			if value := data.Get(key); value != nil {
+				eventValues = append(eventValues, bytes.Clone(value))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if blockValue == nil {
+		return nil, fmt.Errorf("block %s: %w", blockNumber, eth_err.ErrNotFound)
+	}
+
+	block, err := model.UnmarshalBlock(blockValue)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]*types.Log, 0, len(eventValues))
+	for _, value := range eventValues {
+		event, err := model.UnmarshalLog(value)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshalling event: %v", err)
+		}
+		events = append(events, event)
+	}
+
+	return newBlockData(block, events), nil
+}
+
+func (s *BoltStore) GetAllBlockNumbers(ctx context.Context, page Page) ([]string, error) {
+	blocks := make([]string, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBlocksBucket).Cursor()
+		k, blockKey := c.First()
+		if page.After != nil {
+			k, blockKey = c.Seek(heightKey(page.After.BlockNumber))
+			if k != nil && binary.BigEndian.Uint64(k) == page.After.BlockNumber {
+				k, blockKey = c.Next()
+			}
+		}
+
+		for ; k != nil && !page.full(len(blocks)); k, blockKey = c.Next() {
+			blocks = append(blocks, string(blockKey))
+		}
+		return nil
+	})
+	return blocks, err
+}
+
+func (s *BoltStore) EarliestBlock(ctx context.Context) (uint64, error) {
+	return s.blockBound((*bolt.Cursor).First)
+}
+
+func (s *BoltStore) LatestBlock(ctx context.Context) (uint64, error) {
+	return s.blockBound((*bolt.Cursor).Last)
+}
+
+// blockBound returns the number of the block the given cursor move lands on in the blocks bucket.
+func (s *BoltStore) blockBound(move func(*bolt.Cursor) ([]byte, []byte)) (uint64, error) {
+	var number uint64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		k, _ := move(tx.Bucket(boltBlocksBucket).Cursor())
+		if k == nil {
+			return fmt.Errorf("no block stored: %w", eth_err.ErrNotFound)
+		}
+		number = binary.BigEndian.Uint64(k)
+		return nil
+	})
+	return number, err
+}
+
+func (s *BoltStore) IncrStat(ctx context.Context, stat string) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return incrBoltStat(tx, stat)
+	})
+	if err != nil {
+		log.Printf("error incrementing %s counter: %v\n", stat, err)
+	}
+}
+
+func (s *BoltStore) GetStats(ctx context.Context) (map[string]int64, error) {
+	stats := map[string]int64{STAT_APPLIED: 0, STAT_DUPLICATES: 0, STAT_CONFLICTS: 0, STAT_BACKFILLED: 0}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltStatsBucket).ForEach(func(stat, value []byte) error {
+			stats[string(stat)] = int64(binary.BigEndian.Uint64(value))
+			return nil
+		})
+	})
+	return stats, err
+}
+
+func (s *BoltStore) GetWatermark(ctx context.Context) (uint64, error) {
+	var watermark uint64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if value := tx.Bucket(boltMetaBucket).Get([]byte(watermarkMetaKey)); value != nil {
+			watermark = binary.BigEndian.Uint64(value)
+		}
+		return nil
+	})
+	return watermark, err
+}
+
+func (s *BoltStore) SetWatermark(ctx context.Context, blockNumber uint64) (uint64, error) {
+	watermark := blockNumber
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(boltMetaBucket)
+		if value := meta.Get([]byte(watermarkMetaKey)); value != nil && binary.BigEndian.Uint64(value) > watermark {
+			watermark = binary.BigEndian.Uint64(value)
+			return nil
+		}
+		return meta.Put([]byte(watermarkMetaKey), heightKey(watermark))
+	})
+	return watermark, err
+}
+
+func (s *BoltStore) NextDeadLetterID(ctx context.Context) (int64, error) {
+	var id uint64
+	err := s.db.Update(func(tx *bolt.Tx) (err error) {
+		id, err = tx.Bucket(boltDeadLetterBucket).NextSequence()
+		return err
+	})
+	return int64(id), err
+}
+
+func (s *BoltStore) PutDeadLetter(ctx context.Context, id string, entry []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltDeadLetterBucket).Put([]byte(id), entry)
+	})
+}
+
+func (s *BoltStore) GetDeadLetter(ctx context.Context, id string) ([]byte, error) {
+	var entry []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		entry = bytes.Clone(tx.Bucket(boltDeadLetterBucket).Get([]byte(id)))
+		return nil
+	})
+	if err == nil && entry == nil {
+		return nil, fmt.Errorf("dead-letter entry %s: %w", id, eth_err.ErrNotFound)
+	}
+	return entry, err
+}
+
+func (s *BoltStore) ListDeadLetters(ctx context.Context) (map[string][]byte, error) {
+	entries := make(map[string][]byte)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltDeadLetterBucket).ForEach(func(id, entry []byte) error {
+			entries[string(id)] = bytes.Clone(entry)
+			return nil
+		})
+	})
+	return entries, err
+}
+
+func (s *BoltStore) RemoveDeadLetter(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		entries := tx.Bucket(boltDeadLetterBucket)
+		if entries.Get([]byte(id)) == nil {
+			return fmt.Errorf("dead-letter entry %s: %w", id, eth_err.ErrNotFound)
+		}
+		return entries.Delete([]byte(id))
+	})
+}
+
+// PurgeDeadLetters deletes all entries, keeping the sequence so that IDs are not reused.
+func (s *BoltStore) PurgeDeadLetters(ctx context.Context) (int64, error) {
+	var n int64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltDeadLetterBucket).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Close closes the database file, releasing its lock.
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// get returns a copy of the value of a key of the data bucket, nil if the key does not exist.
+func (s *BoltStore) get(key string) ([]byte, error) {
+	var value []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value = bytes.Clone(tx.Bucket(boltDataBucket).Get([]byte(key)))
+		return nil
+	})
+	return value, err
+}
+
+// incrBoltStat increments an ingestion counter within tx.
+func incrBoltStat(tx *bolt.Tx, stat string) error {
+	stats := tx.Bucket(boltStatsBucket)
+
+	var n uint64
+	if value := stats.Get([]byte(stat)); value != nil {
+		n = binary.BigEndian.Uint64(value)
+	}
+	return stats.Put([]byte(stat), binary.BigEndian.AppendUint64(nil, n+1))
+}
+
+// heightKey encodes n big-endian, so that keys sort in numeric order.
+func heightKey(n uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, n)
+}
+
+// eventIndexKey returns the key of an event in the index of an address, or of a topic at a position.
+func eventIndexKey(prefix string, blockNumber uint64, logIndex uint) []byte {
+	key := append([]byte(prefix+"/"), heightKey(blockNumber)...)
+	return binary.BigEndian.AppendUint64(key, uint64(logIndex))
+}
+
+// manifestEntry returns the manifest entry of a key of the given bucket.
+func manifestEntry(bucket, key []byte) []byte {
+	return append(append(bytes.Clone(bucket), 0), key...)
+}
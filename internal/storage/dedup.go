@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"ethereum-data-service/internal/model"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ApplyResult tells how ApplyBlockData handled a block.
+type ApplyResult int
+
+const (
+	// APPLIED means the block was not stored yet and has been stored.
+	APPLIED ApplyResult = iota
+	// DUPLICATE means the very same block (same number and hash) was already stored and has been skipped.
+	DUPLICATE
+	// REORGED means a different block with the same number was stored. It has been replaced by the new one.
+	REORGED
+)
+
+// ApplyBlockData stores the block data unless the same block is already stored, which makes applying a block
+// idempotent: duplicates (for ex. a block written by both the bootstrapper and the notifier, or redelivered by
+// the transport) are skipped without rewriting the keys or refreshing their TTL. A stored block with the same
+// number but a different hash is a conflict, which is handed to reorg handling: the stored block is removed
+// along with its transactions and events, in the same transaction which stores the new one. Duplicates and
+// conflicts are counted in the `stats:sub` hash. Blocks falling out of the `retentionBlocks` window are evicted
+// as per AddBlockDataToDB.
+func ApplyBlockData(ctx context.Context, rdb redis.UniversalClient, ks Keyspace, blockData *model.Data, codec model.Codec, expiryTime time.Duration, retentionBlocks int) (ApplyResult, error) {
+	header := blockData.Block.Header
+	number, hash := header.Number.String(), header.Hash().Hex()
+
+	result := APPLIED
+	var staleKeys, staleEntries []string
+	storedHash, err := rdb.Get(ctx, ks.Key(BLOCK_HASH_PREFIX)+number).Result()
+	switch {
+	case errors.Is(err, redis.Nil):
+		// First time we see this block number
+	case err != nil:
+		return APPLIED, fmt.Errorf("error fetching stored hash of block %s: %v", number, err)
+	case storedHash == hash:
+		log.Printf("Skipping duplicate block %s (%s)\n", number, hash)
+		IncrStat(ctx, rdb, ks, STAT_DUPLICATES)
+		return DUPLICATE, nil
+	default:
+		log.Printf("Block %s conflicts with the stored one: %s replaces %s\n", number, hash, storedHash)
+		IncrStat(ctx, rdb, ks, STAT_CONFLICTS)
+		staleKeys, staleEntries, err = handleReorg(ctx, rdb, ks, number)
+		if err != nil {
+			return APPLIED, err
+		}
+		result = REORGED
+	}
+
+	if err := replaceBlockData(ctx, rdb, ks, staleKeys, staleEntries, blockData, codec, expiryTime, retentionBlocks); err != nil {
+		return APPLIED, err
+	}
+
+	IncrStat(ctx, rdb, ks, STAT_APPLIED)
+	return result, nil
+}
+
+// handleReorg returns the keys and index entries of the stored block with the given number, which has been
+// replaced on chain.
+func handleReorg(ctx context.Context, rdb redis.UniversalClient, ks Keyspace, blockNumber string) ([]string, []string, error) {
+	keys, entries, err := blockManifest(ctx, rdb, ks, blockNumber)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error collecting keys of reorged block %s: %v", blockNumber, err)
+	}
+	return keys, entries, nil
+}
+
+// RemoveBlockData deletes the block with the given number along with its transactions, events and index entries.
+func RemoveBlockData(ctx context.Context, rdb redis.UniversalClient, ks Keyspace, blockNumber string) error {
+	keys, entries, err := blockManifest(ctx, rdb, ks, blockNumber)
+	if err != nil {
+		return err
+	}
+	_, err = rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		queueRemoval(ctx, pipe, ks, keys, entries)
+		return nil
+	})
+	return err
+}
+
+// blockDataKeys looks up the keys of the block with the given number and of its transactions and events, for
+// blocks stored without a manifest.
+func blockDataKeys(ctx context.Context, rdb redis.UniversalClient, ks Keyspace, blockNumber string) ([]string, error) {
+	keys := []string{ks.Key(BLOCK_PREFIX) + blockNumber, ks.Key(BLOCK_HASH_PREFIX) + blockNumber}
+
+	block, err := GetBlockByNumber(rdb, ks, blockNumber)
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	if block != nil {
+		keys = append(keys, ks.Key(BLOCK_NUMBER_PREFIX)+strings.ToLower(block.Header.Hash().Hex()))
+	}
+	if block != nil && block.Body != nil {
+		for _, tx := range block.Body.Transactions {
+			keys = append(keys, ks.Key(TX_PREFIX)+tx.Hash().Hex())
+		}
+	}
+
+	// Event keys embed the block number between the address and the transaction hash
+	eventKeys, err := scanKeys(ctx, rdb, fmt.Sprint(ks.Key(EVENT_PREFIX), "*_", blockNumber, "_*"), 0)
+	if err != nil {
+		return nil, err
+	}
+	keys = append(keys, eventKeys...)
+
+	return keys, nil
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// TOPIC_INDEX_PREFIX prefixes the sorted sets of the event keys of a topic at a given position
+// (`idx:topic:<position>:<topic>`), scored by block number and log index as the address indexes are.
+var TOPIC_INDEX_PREFIX = "idx:topic:"
+
+// MAX_TOPICS is the number of topic positions an event can have, and a filter can constrain.
+const MAX_TOPICS = 4
+
+// LogFilter selects events with the semantics of `eth_getLogs`: an event matches if it lies within the block
+// range, was emitted by one of the addresses, and has, at every position of Topics, one of the topics listed
+// there. An empty address list or topic set matches anything, and so does a position beyond Topics.
+// Addresses and topics are lower case.
+type LogFilter struct {
+	FromBlock uint64
+	ToBlock   uint64
+	Addresses []string
+	Topics    [][]string
+}
+
+// Matches reports whether the event matches the filter.
+func (f *LogFilter) Matches(event *types.Log) bool {
+	if event.BlockNumber < f.FromBlock || event.BlockNumber > f.ToBlock {
+		return false
+	}
+
+	if len(f.Addresses) > 0 && !contains(f.Addresses, strings.ToLower(event.Address.Hex())) {
+		return false
+	}
+
+	for position, topics := range f.Topics {
+		if len(topics) == 0 {
+			continue
+		}
+		if position >= len(event.Topics) || !contains(topics, strings.ToLower(event.Topics[position].Hex())) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// indexOptions returns the alternative sets of indexes the events matching the filter can be read from: the
+// indexes of its addresses, and the indexes of the topics at each constrained position. The events of every
+// set are a superset of the matching events, so the smallest set is read and its events filtered. No option
+// means the filter only constrains the block range.
+func (f *LogFilter) indexOptions(ks Keyspace) [][]string {
+	var options [][]string
+	if len(f.Addresses) > 0 {
+		var keys []string
+		for _, address := range f.Addresses {
+			keys = append(keys, ks.Key(ADDRESS_INDEX_PREFIX)+address)
+		}
+		options = append(options, keys)
+	}
+
+	for position, topics := range f.Topics {
+		if len(topics) == 0 {
+			continue
+		}
+		var keys []string
+		for _, topic := range topics {
+			keys = append(keys, ks.topicIndexKey(position, topic))
+		}
+		options = append(options, keys)
+	}
+
+	return options
+}
+
+// topicIndexKey returns the index key of a topic (lower case) at the given position.
+func (ks Keyspace) topicIndexKey(position int, topic string) string {
+	return fmt.Sprint(ks.Key(TOPIC_INDEX_PREFIX), position, ":", topic)
+}
+
+// sortLogs orders events by block number and log index, dropping the events listed twice.
+func sortLogs(events []*types.Log) []*types.Log {
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].BlockNumber != events[j].BlockNumber {
+			return events[i].BlockNumber < events[j].BlockNumber
+		}
+		return events[i].Index < events[j].Index
+	})
+
+	sorted := events[:0]
+	for i, event := range events {
+		if i > 0 && event.BlockNumber == events[i-1].BlockNumber && event.Index == events[i-1].Index {
+			continue
+		}
+		sorted = append(sorted, event)
+	}
+
+	return sorted
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
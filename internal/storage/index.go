@@ -2,7 +2,6 @@ package storage
 
 import (
 	"context"
-	"encoding/json"
 	"ethereum-data-service/internal/model"
 	"fmt"
 	"strings"
@@ -11,39 +10,77 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
-// IdxBlockAndStore: Indexes the block data by its block number and stores in Redis.
-func IdxBlockAndStore(ctx context.Context, rdb *redis.Client, blockData *model.Data, expiryTime time.Duration) error {
-	blockKey := fmt.Sprint(BLOCK_PREFIX, blockData.Block.Header.Number)
-	blockDataJSON, err := json.Marshal(blockData.Block)
+// IndexWriter is the part of redis.Pipeliner the `Idx*` functions queue their writes on. A Redis pipeline and the
+// memory store both implement it, so that a command one of them lacks is a compile error rather than a panic.
+type IndexWriter interface {
+	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd
+	Expire(ctx context.Context, key string, expiration time.Duration) *redis.BoolCmd
+	SAdd(ctx context.Context, key string, members ...interface{}) *redis.IntCmd
+	ZAdd(ctx context.Context, key string, members ...redis.Z) *redis.IntCmd
+}
+
+var (
+	_ IndexWriter = redis.Pipeliner(nil)
+	_ IndexWriter = (*memoryPipe)(nil)
+)
+
+// IdxBlockAndStore: Indexes the block data and its hash by its block number, and its number by its hash (lower case),
+// and queues their storage on pipe, along with the addition of the block to the blocks index and of the keys to the
+// block manifest.
+func IdxBlockAndStore(ctx context.Context, pipe IndexWriter, ks Keyspace, blockData *model.Data, codec model.Codec, expiryTime time.Duration) error {
+	blockKey := fmt.Sprint(ks.Key(BLOCK_PREFIX), blockData.Block.Header.Number)
+	blockDataJSON, err := codec.MarshalBlock(&blockData.Block)
 	if err != nil {
 		return fmt.Errorf("error marshalling block data: %v", err)
 	}
-	if err := rdb.Set(ctx, blockKey, blockDataJSON, expiryTime).Err(); err != nil {
-		return fmt.Errorf("error storing block data in Redis: %v", err)
+	manifest := ks.manifestKey(blockData.Block.Header.Number)
+	pipe.Set(ctx, blockKey, blockDataJSON, expiryTime)
+	addToIndex(ctx, pipe, ks, manifest, ks.Key(BLOCKS_INDEX_KEY), blockKey, float64(blockData.Block.Header.Number.Uint64()), expiryTime)
+
+	// Keep the block hash next to the block so that duplicates and conflicts can be detected without decoding it
+	hashKey := fmt.Sprint(ks.Key(BLOCK_HASH_PREFIX), blockData.Block.Header.Number)
+	pipe.Set(ctx, hashKey, blockData.Block.Header.Hash().Hex(), expiryTime)
+
+	numberKey := ks.Key(BLOCK_NUMBER_PREFIX) + strings.ToLower(blockData.Block.Header.Hash().Hex())
+	pipe.Set(ctx, numberKey, blockData.Block.Header.Number.String(), expiryTime)
+
+	pipe.SAdd(ctx, manifest, blockKey, hashKey, numberKey)
+	// EXPIRE with no TTL would delete the manifest rather than keep it
+	if expiryTime > 0 {
+		pipe.Expire(ctx, manifest, expiryTime)
 	}
 	return nil
 }
 
-// IdxTxAndStore: Indexes each transaction data against its hash and stores in Redis.
-func IdxTxAndStore(ctx context.Context, rdb *redis.Client, blockData *model.Data, expiryTime time.Duration) error {
+// IdxTxAndStore: Indexes each transaction data against its hash and queues its storage on pipe, along with its
+// addition to the block manifest.
+func IdxTxAndStore(ctx context.Context, pipe IndexWriter, ks Keyspace, blockData *model.Data, codec model.Codec, expiryTime time.Duration) error {
+	var keys []interface{}
 	for txHash, tx := range blockData.TransactionHashes {
-		txKey := fmt.Sprint(TX_PREFIX, txHash)
-		txJSON, err := json.Marshal(tx)
+		txKey := fmt.Sprint(ks.Key(TX_PREFIX), txHash)
+		txJSON, err := codec.MarshalTx(tx)
 		if err != nil {
 			return fmt.Errorf("error marshalling transaction %s: %v", txHash, err)
 		}
-		if err := rdb.Set(ctx, txKey, txJSON, expiryTime).Err(); err != nil {
-			return fmt.Errorf("error storing transaction %s in Redis: %v", txHash, err)
-		}
+		pipe.Set(ctx, txKey, txJSON, expiryTime)
+		keys = append(keys, txKey)
+	}
+
+	if len(keys) > 0 {
+		pipe.SAdd(ctx, ks.manifestKey(blockData.Block.Header.Number), keys...)
 	}
 	return nil
 }
 
-// IdxEventsAndStore: Indexes each event by its address, blocknumber, tx_hash, and tx_idx stores in Redis.
-func IdxEventsAndStore(ctx context.Context, rdb *redis.Client, blockData *model.Data, expiryTime time.Duration) error {
+// IdxEventsAndStore: Indexes each event by its address, blocknumber, tx_hash, and tx_idx and queues its storage on pipe,
+// along with the addition of the event to the index of its address, to the index of each of its topics at its
+// position, and to the block manifest.
+func IdxEventsAndStore(ctx context.Context, pipe IndexWriter, ks Keyspace, blockData *model.Data, codec model.Codec, expiryTime time.Duration) error {
+	manifest := ks.manifestKey(blockData.Block.Header.Number)
+	var keys []interface{}
 	for txHash, events := range blockData.Events {
 		for _, event := range events {
-			eventJSON, err := json.Marshal(event)
+			eventJSON, err := codec.MarshalLog(event)
 			if err != nil {
 				return fmt.Errorf("error marshalling event %+v: %v", event, err)
 			}
@@ -51,11 +88,22 @@ func IdxEventsAndStore(ctx context.Context, rdb *redis.Client, blockData *model.
 			// This is done to keep the key associated with every event unique. To eliminate any case senstivity, wrt to address we convert all address
 			// to lower case before indexing. For ex: Addr `0x0C04fF41b11065EEd8c9EDA4d461BA6611591395` and `0x0C04ff41b11065eed8c9eda4d461ba6611591395`
 			// all point to the same account. We do the same in the API call as well.
-			addressKey := fmt.Sprint(EVENT_PREFIX, strings.ToLower(event.Address.Hex()), "_", event.BlockNumber, "_", txHash, "_", event.Index)
-			if err := rdb.Set(ctx, addressKey, eventJSON, expiryTime).Err(); err != nil {
-				return fmt.Errorf("error storing event %s_%d in Redis: %v", event.Address, event.TxIndex, err)
+			address := strings.ToLower(event.Address.Hex())
+			addressKey := fmt.Sprint(ks.Key(EVENT_PREFIX), address, "_", event.BlockNumber, "_", txHash, "_", event.Index)
+			pipe.Set(ctx, addressKey, eventJSON, expiryTime)
+			score := eventScore(event.BlockNumber, event.Index)
+			addToIndex(ctx, pipe, ks, manifest, ks.Key(ADDRESS_INDEX_PREFIX)+address, addressKey, score, expiryTime)
+			for position, topic := range event.Topics {
+				if position < MAX_TOPICS {
+					addToIndex(ctx, pipe, ks, manifest, ks.topicIndexKey(position, strings.ToLower(topic.Hex())), addressKey, score, expiryTime)
+				}
 			}
+			keys = append(keys, addressKey)
 		}
 	}
+
+	if len(keys) > 0 {
+		pipe.SAdd(ctx, manifest, keys...)
+	}
 	return nil
 }
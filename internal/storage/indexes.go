@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// BLOCKS_INDEX_KEY is the sorted set of the stored block keys, scored by block number.
+	BLOCKS_INDEX_KEY = "idx:blocks"
+	// ADDRESS_INDEX_PREFIX prefixes the sorted sets of the event keys of an address, scored by block number and log index.
+	ADDRESS_INDEX_PREFIX = "idx:addr:"
+	// INDEX_EXPIRY_KEY is the sorted set of the index entries, scored by the time (Unix milliseconds) the key they
+	// point to expires at. It lets index entries be removed in step with the keys they point to.
+	INDEX_EXPIRY_KEY = "idx:expiry"
+)
+
+const (
+	// indexEntrySeparator separates the index key from the member in the entries of INDEX_EXPIRY_KEY.
+	indexEntrySeparator = "|"
+	// logIndexBits is the number of low bits of an event score holding the log index, which leaves room for
+	// block numbers up to 2^33 within the 53 bits a sorted set score represents exactly.
+	logIndexBits = 20
+	// pruneBatchSize bounds the number of expired index entries removed per round trip.
+	pruneBatchSize = 1000
+)
+
+// eventScore orders the events of an address by block number, then log index.
+func eventScore(blockNumber uint64, logIndex uint) float64 {
+	return float64(blockNumber<<logIndexBits | uint64(logIndex))
+}
+
+// addToIndex queues the addition of member to the index on pipe, along with its expiry entry and its record
+// in the given block manifest. Members whose keys do not expire get no expiry entry, and only leave the index
+// with their block.
+func addToIndex(ctx context.Context, pipe IndexWriter, ks Keyspace, manifest, indexKey, member string, score float64, expiryTime time.Duration) {
+	entry := indexKey + indexEntrySeparator + member
+	pipe.ZAdd(ctx, indexKey, redis.Z{Score: score, Member: member})
+	if expiryTime > 0 {
+		pipe.ZAdd(ctx, ks.Key(INDEX_EXPIRY_KEY), redis.Z{
+			Score:  float64(time.Now().Add(expiryTime).UnixMilli()),
+			Member: entry,
+		})
+	}
+	pipe.SAdd(ctx, manifest, entry)
+}
+
+// PruneIndexes removes the index entries whose key has expired and returns the number of entries removed.
+// Blocks are normally evicted along with their index entries as they leave the retention window, so this only
+// catches up on keys which expired through their safety-net TTL. It runs after every block is stored and
+// before every index lookup, so the indexes never point to more than the keys which expired since.
+func PruneIndexes(ctx context.Context, rdb redis.UniversalClient, ks Keyspace) (int, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+
+	pruned := 0
+	for {
+		entries, err := rdb.ZRangeByScore(ctx, ks.Key(INDEX_EXPIRY_KEY), &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   now,
+			Count: pruneBatchSize,
+		}).Result()
+		if err != nil {
+			return pruned, fmt.Errorf("error fetching expired index entries: %v", err)
+		}
+		if len(entries) == 0 {
+			return pruned, nil
+		}
+
+		_, err = rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, entry := range entries {
+				indexKey, member, _ := strings.Cut(entry, indexEntrySeparator)
+				pipe.ZRem(ctx, indexKey, member)
+			}
+			pipe.ZRem(ctx, ks.Key(INDEX_EXPIRY_KEY), toInterfaces(entries)...)
+			return nil
+		})
+		if err != nil {
+			return pruned, fmt.Errorf("error pruning expired index entries: %v", err)
+		}
+
+		pruned += len(entries)
+		if len(entries) < pruneBatchSize {
+			return pruned, nil
+		}
+	}
+}
+
+func toInterfaces(values []string) []interface{} {
+	out := make([]interface{}, len(values))
+	for i, v := range values {
+		out[i] = v
+	}
+	return out
+}
@@ -0,0 +1,579 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"ethereum-data-service/internal/config"
+	"ethereum-data-service/internal/model"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	eth_err "ethereum-data-service/pkg/err"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/redis/go-redis/v9"
+)
+
+// MemoryStore is the in-memory implementation of Store, for tests and throwaway runs. It mirrors the Redis
+// layout: blocks are written by the same `Idx*` functions, through a pipeline which applies their commands to
+// in-memory strings, sets and sorted sets, so keys, indexes, manifests, TTLs and height-based eviction behave
+// as in Redis. Expired keys are dropped lazily, and the index entries pointing to them are pruned before every
+// write. It also keeps the dead-letter queue. It is safe for concurrent use. Nothing is persisted, and the data
+// is only shared within the process.
+type MemoryStore struct {
+	codec           model.Codec
+	expiryTime      time.Duration
+	retentionBlocks int
+	now             func() time.Time
+
+	mu        sync.RWMutex
+	values    map[string][]byte             // values holds the string keys.
+	sets      map[string]map[string]bool    // sets holds the block manifests.
+	zsets     map[string]map[string]float64 // zsets holds the indexes, member to score.
+	expiries  map[string]time.Time          // expiries holds the expiry time of the keys with a TTL.
+	stats     map[string]int64
+	watermark uint64
+
+	deadLetters  map[string][]byte // deadLetters holds the dead-lettered entries by ID.
+	deadLetterID int64             // deadLetterID is the last dead-letter entry ID drawn.
+}
+
+// NewMemoryStore returns an empty MemoryStore configured from cfg.
+func NewMemoryStore(cfg *config.Config) *MemoryStore {
+	return &MemoryStore{
+		codec:           cfg.STORAGE_CODEC,
+		expiryTime:      cfg.REDIS_KEY_EXPIRY_TIME,
+		retentionBlocks: cfg.RETENTION_BLOCKS,
+		now:             time.Now,
+		values:          make(map[string][]byte),
+		sets:            make(map[string]map[string]bool),
+		zsets:           make(map[string]map[string]float64),
+		expiries:        make(map[string]time.Time),
+		stats:           make(map[string]int64),
+		deadLetters:     make(map[string][]byte),
+	}
+}
+
+// ApplyBlockData stores the block data, skipping duplicates and replacing conflicting blocks as the Redis backend
+// does (see `storage.ApplyBlockData`).
+func (s *MemoryStore) ApplyBlockData(ctx context.Context, blockData *model.Data) (ApplyResult, error) {
+	return s.ApplyBlockDataWithExpiry(ctx, blockData, s.expiryTime)
+}
+
+func (s *MemoryStore) ApplyBlockDataWithExpiry(ctx context.Context, blockData *model.Data, expiryTime time.Duration) (ApplyResult, error) {
+	header := blockData.Block.Header
+	number, hash := header.Number.String(), header.Hash().Hex()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expireKeys()
+
+	result := APPLIED
+	storedHash, ok := s.values[BLOCK_HASH_PREFIX+number]
+	switch {
+	case !ok:
+		// First time we see this block number
+	case string(storedHash) == hash:
+		log.Printf("Skipping duplicate block %s (%s)\n", number, hash)
+		s.stats[STAT_DUPLICATES]++
+		return DUPLICATE, nil
+	default:
+		log.Printf("Block %s conflicts with the stored one: %s replaces %s\n", number, hash, storedHash)
+		s.stats[STAT_CONFLICTS]++
+		s.removeBlock(number)
+		result = REORGED
+	}
+
+	s.evict(header.Number.Uint64())
+
+	if err := s.putBlockData(ctx, blockData, expiryTime); err != nil {
+		// Drop what was written so far, as a failed MULTI/EXEC would
+		s.removeBlock(number)
+		return APPLIED, fmt.Errorf("error storing block %s in memory: %v", number, err)
+	}
+
+	s.stats[STAT_APPLIED]++
+	log.Printf("Stored block %s in memory\n", number)
+	return result, nil
+}
+
+// putBlockData writes the block data through the `Idx*` functions, with keys expiring after expiryTime.
+func (s *MemoryStore) putBlockData(ctx context.Context, blockData *model.Data, expiryTime time.Duration) error {
+	pipe := &memoryPipe{store: s}
+	if err := IdxBlockAndStore(ctx, pipe, "", blockData, s.codec, expiryTime); err != nil {
+		return err
+	}
+	if err := IdxTxAndStore(ctx, pipe, "", blockData, s.codec, expiryTime); err != nil {
+		return err
+	}
+	return IdxEventsAndStore(ctx, pipe, "", blockData, s.codec, expiryTime)
+}
+
+// evict removes the blocks which fall out of the retention window once the block with the given number is
+// committed, as per `retentionEvictions`.
+func (s *MemoryStore) evict(blockNumber uint64) {
+	if s.retentionBlocks <= 0 {
+		return
+	}
+
+	head := blockNumber
+	for _, score := range s.zsets[BLOCKS_INDEX_KEY] {
+		head = max(head, uint64(score))
+	}
+	if head < uint64(s.retentionBlocks) {
+		return
+	}
+	cutoff := head - uint64(s.retentionBlocks)
+
+	for blockKey, score := range s.zsets[BLOCKS_INDEX_KEY] {
+		if uint64(score) <= cutoff {
+			s.removeBlock(strings.TrimPrefix(blockKey, BLOCK_PREFIX))
+		}
+	}
+}
+
+// removeBlock deletes the keys and index entries listed in the manifest of the block with the given number,
+// and the manifest itself.
+func (s *MemoryStore) removeBlock(blockNumber string) {
+	manifest := MANIFEST_PREFIX + blockNumber
+	for member := range s.sets[manifest] {
+		indexKey, indexMember, isEntry := strings.Cut(member, indexEntrySeparator)
+		if isEntry {
+			s.zrem(indexKey, indexMember)
+			s.zrem(INDEX_EXPIRY_KEY, member)
+		} else {
+			s.del(member)
+		}
+	}
+	s.del(manifest)
+}
+
+// expireKeys deletes the keys whose TTL has passed, and prunes the index entries pointing to them as
+// `PruneIndexes` does.
+func (s *MemoryStore) expireKeys() {
+	now := s.now()
+	for key, expiry := range s.expiries {
+		if !now.Before(expiry) {
+			s.del(key)
+		}
+	}
+
+	cutoff := float64(now.UnixMilli())
+	for entry, score := range s.zsets[INDEX_EXPIRY_KEY] {
+		if score <= cutoff {
+			indexKey, member, _ := strings.Cut(entry, indexEntrySeparator)
+			s.zrem(indexKey, member)
+			s.zrem(INDEX_EXPIRY_KEY, entry)
+		}
+	}
+}
+
+func (s *MemoryStore) del(key string) {
+	delete(s.values, key)
+	delete(s.sets, key)
+	delete(s.zsets, key)
+	delete(s.expiries, key)
+}
+
+func (s *MemoryStore) zrem(key, member string) {
+	delete(s.zsets[key], member)
+	if len(s.zsets[key]) == 0 {
+		delete(s.zsets, key)
+	}
+}
+
+// get returns the value of a string key, unless it does not exist or has expired.
+func (s *MemoryStore) get(key string) ([]byte, bool) {
+	if expiry, ok := s.expiries[key]; ok && !s.now().Before(expiry) {
+		return nil, false
+	}
+	value, ok := s.values[key]
+	return value, ok
+}
+
+// indexMembers returns up to limit (0 for all) members of the index scored above after whose key has not
+// expired, in ascending score then member, as ZRANGEBYSCORE does.
+func (s *MemoryStore) indexMembers(indexKey string, after float64, limit int) []string {
+	index := s.zsets[indexKey]
+	members := make([]string, 0, len(index))
+	for member, score := range index {
+		if _, ok := s.get(member); ok && score > after {
+			members = append(members, member)
+		}
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		if index[members[i]] != index[members[j]] {
+			return index[members[i]] < index[members[j]]
+		}
+		return members[i] < members[j]
+	})
+	if limit > 0 && len(members) > limit {
+		members = members[:limit]
+	}
+	return members
+}
+
+func (s *MemoryStore) RemoveBlockData(ctx context.Context, blockNumber string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeBlock(blockNumber)
+	return nil
+}
+
+func (s *MemoryStore) IsBlockStored(ctx context.Context, blockNumber uint64) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.get(fmt.Sprint(BLOCK_HASH_PREFIX, blockNumber))
+	return ok, nil
+}
+
+func (s *MemoryStore) GetEventsByAddress(ctx context.Context, address string, page Page) ([]*types.Log, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := s.indexMembers(ADDRESS_INDEX_PREFIX+address, page.afterEventScore(), page.Limit)
+	events := make([]*types.Log, 0, len(keys))
+	for _, key := range keys {
+		value, _ := s.get(key)
+		event, err := model.UnmarshalLog(value)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshalling event: %v", err)
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+func (s *MemoryStore) GetLogs(ctx context.Context, filter *LogFilter, page Page) ([]*types.Log, error) {
+	events := make([]*types.Log, 0)
+	filter = page.filter(filter)
+	if filter.FromBlock > filter.ToBlock {
+		return events, nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, key := range s.filteredEventKeys(filter) {
+		value, ok := s.get(key)
+		if !ok {
+			continue
+		}
+
+		event, err := model.UnmarshalLog(value)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshalling event: %v", err)
+		}
+		if filter.Matches(event) {
+			events = append(events, event)
+		}
+	}
+
+	return page.logs(sortLogs(events)), nil
+}
+
+// filteredEventKeys returns the keys of the events within the block range of the smallest index set the filter
+// can be served from, or of every event of the blocks in range, as `filteredEventKeys` does in Redis.
+func (s *MemoryStore) filteredEventKeys(filter *LogFilter) []string {
+	min, max := eventScore(filter.FromBlock, 0), eventScore(filter.ToBlock, 1<<logIndexBits-1)
+
+	options := filter.indexOptions("")
+	if len(options) == 0 {
+		var keys []string
+		for blockKey, number := range s.zsets[BLOCKS_INDEX_KEY] {
+			if number < float64(filter.FromBlock) || number > float64(filter.ToBlock) {
+				continue
+			}
+			for member := range s.sets[MANIFEST_PREFIX+strings.TrimPrefix(blockKey, BLOCK_PREFIX)] {
+				if strings.HasPrefix(member, EVENT_PREFIX) {
+					keys = append(keys, member)
+				}
+			}
+		}
+		return keys
+	}
+
+	var best []string
+	for i, indexKeys := range options {
+		var keys []string
+		for _, indexKey := range indexKeys {
+			for member, score := range s.zsets[indexKey] {
+				if score >= min && score <= max {
+					keys = append(keys, member)
+				}
+			}
+		}
+		if i == 0 || len(keys) < len(best) {
+			best = keys
+		}
+	}
+
+	return best
+}
+
+func (s *MemoryStore) GetBlockByNumber(ctx context.Context, blockNumber string) (*model.Block, error) {
+	s.mu.RLock()
+	value, ok := s.get(BLOCK_PREFIX + blockNumber)
+	s.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("block %s: %w", blockNumber, eth_err.ErrNotFound)
+	}
+	return model.UnmarshalBlock(value)
+}
+
+func (s *MemoryStore) GetBlockByHash(ctx context.Context, blockHash string) (*model.Block, error) {
+	s.mu.RLock()
+	blockNumber, ok := s.get(BLOCK_NUMBER_PREFIX + strings.ToLower(blockHash))
+	s.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("block %s: %w", blockHash, eth_err.ErrNotFound)
+	}
+	return s.GetBlockByNumber(ctx, string(blockNumber))
+}
+
+func (s *MemoryStore) GetTransactionByHash(ctx context.Context, txHash string) (*types.Transaction, error) {
+	s.mu.RLock()
+	value, ok := s.get(TX_PREFIX + txHash)
+	s.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("transaction %s: %w", txHash, eth_err.ErrNotFound)
+	}
+	return model.UnmarshalTx(value)
+}
+
+func (s *MemoryStore) GetBlockData(ctx context.Context, blockNumber string) (*model.Data, error) {
+	s.mu.RLock()
+	blockValue, ok := s.get(BLOCK_PREFIX + blockNumber)
+	var eventValues [][]byte
+	for member := range s.sets[MANIFEST_PREFIX+blockNumber] {
+		if !strings.HasPrefix(member, EVENT_PREFIX) {
+			continue
+		}
+		if value, ok := s.get(member); ok {
+			eventValues = append(eventValues, value)
+		}
+	}
+	s.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("block %s: %w", blockNumber, eth_err.ErrNotFound)
+	}
+
+	block, err := model.UnmarshalBlock(blockValue)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]*types.Log, 0, len(eventValues))
+	for _, value := range eventValues {
+		event, err := model.UnmarshalLog(value)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshalling event: %v", err)
+		}
+		events = append(events, event)
+	}
+
+	return newBlockData(block, events), nil
+}
+
+func (s *MemoryStore) GetBlockExpiry(ctx context.Context, blockNumber string) (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.get(BLOCK_PREFIX + blockNumber); !ok {
+		return time.Time{}, fmt.Errorf("block %s: %w", blockNumber, eth_err.ErrNotFound)
+	}
+	return s.expiries[BLOCK_PREFIX+blockNumber], nil
+}
+
+func (s *MemoryStore) GetAllBlockNumbers(ctx context.Context, page Page) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.indexMembers(BLOCKS_INDEX_KEY, page.afterBlockScore(), page.Limit), nil
+}
+
+func (s *MemoryStore) EarliestBlock(ctx context.Context) (uint64, error) {
+	return s.blockBound(func(score, bound float64) bool { return score < bound })
+}
+
+func (s *MemoryStore) LatestBlock(ctx context.Context) (uint64, error) {
+	return s.blockBound(func(score, bound float64) bool { return score > bound })
+}
+
+// blockBound returns the number of the stored block whose score comes first as per before.
+func (s *MemoryStore) blockBound(before func(score, bound float64) bool) (uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	bound, found := 0.0, false
+	for blockKey, score := range s.zsets[BLOCKS_INDEX_KEY] {
+		if _, ok := s.get(blockKey); ok && (!found || before(score, bound)) {
+			bound, found = score, true
+		}
+	}
+	if !found {
+		return 0, fmt.Errorf("no block stored: %w", eth_err.ErrNotFound)
+	}
+	return uint64(bound), nil
+}
+
+func (s *MemoryStore) IncrStat(ctx context.Context, stat string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats[stat]++
+}
+
+func (s *MemoryStore) GetStats(ctx context.Context) (map[string]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := map[string]int64{STAT_APPLIED: 0, STAT_DUPLICATES: 0, STAT_CONFLICTS: 0, STAT_BACKFILLED: 0}
+	for stat, value := range s.stats {
+		stats[stat] = value
+	}
+	return stats, nil
+}
+
+func (s *MemoryStore) GetWatermark(ctx context.Context) (uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.watermark, nil
+}
+
+func (s *MemoryStore) SetWatermark(ctx context.Context, blockNumber uint64) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.watermark = max(s.watermark, blockNumber)
+	return s.watermark, nil
+}
+
+// Close is a no-op, the data is dropped along with the store.
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+func (s *MemoryStore) NextDeadLetterID(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deadLetterID++
+	return s.deadLetterID, nil
+}
+
+func (s *MemoryStore) PutDeadLetter(ctx context.Context, id string, entry []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deadLetters[id] = bytes.Clone(entry)
+	return nil
+}
+
+func (s *MemoryStore) GetDeadLetter(ctx context.Context, id string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.deadLetters[id]
+	if !ok {
+		return nil, fmt.Errorf("dead-letter entry %s: %w", id, eth_err.ErrNotFound)
+	}
+	return bytes.Clone(entry), nil
+}
+
+func (s *MemoryStore) ListDeadLetters(ctx context.Context) (map[string][]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := make(map[string][]byte, len(s.deadLetters))
+	for id, entry := range s.deadLetters {
+		entries[id] = bytes.Clone(entry)
+	}
+	return entries, nil
+}
+
+func (s *MemoryStore) RemoveDeadLetter(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.deadLetters[id]; !ok {
+		return fmt.Errorf("dead-letter entry %s: %w", id, eth_err.ErrNotFound)
+	}
+	delete(s.deadLetters, id)
+	return nil
+}
+
+// PurgeDeadLetters deletes all entries, keeping the sequence so that IDs are not reused.
+func (s *MemoryStore) PurgeDeadLetters(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := int64(len(s.deadLetters))
+	clear(s.deadLetters)
+	return n, nil
+}
+
+// memoryPipe applies the commands queued by the `Idx*` functions to a MemoryStore, whose lock the caller holds.
+type memoryPipe struct {
+	store *MemoryStore
+}
+
+func (p *memoryPipe) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	switch v := value.(type) {
+	case []byte:
+		p.store.values[key] = v
+	case string:
+		p.store.values[key] = []byte(v)
+	default:
+		p.store.values[key] = []byte(fmt.Sprint(v))
+	}
+
+	delete(p.store.expiries, key)
+	p.Expire(ctx, key, expiration)
+	return redis.NewStatusCmd(ctx)
+}
+
+func (p *memoryPipe) Expire(ctx context.Context, key string, expiration time.Duration) *redis.BoolCmd {
+	if expiration > 0 {
+		p.store.expiries[key] = p.store.now().Add(expiration)
+	}
+	return redis.NewBoolCmd(ctx)
+}
+
+func (p *memoryPipe) SAdd(ctx context.Context, key string, members ...interface{}) *redis.IntCmd {
+	set, ok := p.store.sets[key]
+	if !ok {
+		set = make(map[string]bool)
+		p.store.sets[key] = set
+	}
+	for _, member := range members {
+		set[fmt.Sprint(member)] = true
+	}
+	return redis.NewIntCmd(ctx)
+}
+
+func (p *memoryPipe) ZAdd(ctx context.Context, key string, members ...redis.Z) *redis.IntCmd {
+	zset, ok := p.store.zsets[key]
+	if !ok {
+		zset = make(map[string]float64)
+		p.store.zsets[key] = zset
+	}
+	for _, member := range members {
+		zset[fmt.Sprint(member.Member)] = member.Score
+	}
+	return redis.NewIntCmd(ctx)
+}
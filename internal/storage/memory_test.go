@@ -0,0 +1,723 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"ethereum-data-service/internal/config"
+	"ethereum-data-service/internal/model"
+	"ethereum-data-service/pkg/enum"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	eth_err "ethereum-data-service/pkg/err"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestMemoryStore returns a MemoryStore keeping retentionBlocks blocks, with keys expiring after expiryTime,
+// and a clock which only moves when the returned function is called.
+func newTestMemoryStore(t *testing.T, retentionBlocks int, expiryTime time.Duration) (*MemoryStore, func(time.Duration)) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	s := NewMemoryStore(&config.Config{
+		STORAGE_CODEC:         model.Codec{Encoding: model.ENCODING_JSON, Compression: model.COMPRESSION_NONE},
+		REDIS_KEY_EXPIRY_TIME: expiryTime,
+		RETENTION_BLOCKS:      retentionBlocks,
+	})
+
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	return s, func(d time.Duration) { now = now.Add(d) }
+}
+
+// testStores returns a MemoryStore, a RedisStore backed by an in-process Redis and a BoltStore in a temporary
+// directory, all keeping retentionBlocks blocks, so that the tests below check that the backends behave the same.
+func testStores(t *testing.T, retentionBlocks int) map[string]Store {
+	memory, _ := newTestMemoryStore(t, retentionBlocks, time.Hour)
+
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	bolt, err := OpenBoltStore(&config.Config{
+		STORAGE_CODEC:    memory.codec,
+		RETENTION_BLOCKS: retentionBlocks,
+		DATA_DIR:         t.TempDir(),
+		DEFAULT_TIMEOUT:  time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bolt.Close() })
+
+	return map[string]Store{
+		"bolt":   bolt,
+		"memory": memory,
+		"redis": NewRedisStore(rdb, &config.Config{
+			STORAGE_CODEC:         memory.codec,
+			REDIS_KEY_EXPIRY_TIME: time.Hour,
+			RETENTION_BLOCKS:      retentionBlocks,
+		}),
+	}
+}
+
+func mustApply(t *testing.T, s Store, blockData *model.Data, want ApplyResult) {
+	t.Helper()
+	got, err := s.ApplyBlockData(context.Background(), blockData)
+	if err != nil {
+		t.Fatalf("applying block %d: %v", blockData.Block.Header.Number, err)
+	}
+	if got != want {
+		t.Fatalf("applying block %d: got result %d, want %d", blockData.Block.Header.Number, got, want)
+	}
+}
+
+func blockKeys(t *testing.T, s Store) []string {
+	t.Helper()
+	blocks, err := s.GetAllBlockNumbers(context.Background(), Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blocks
+}
+
+// testAddress returns the lower case address of the i-th transaction of testBlockData.
+func testAddress(i int64) string {
+	return strings.ToLower(common.BigToAddress(big.NewInt(i)).Hex())
+}
+
+func TestIdxKeyLayout(t *testing.T) {
+	s, _ := newTestMemoryStore(t, 0, time.Minute)
+	blockData := testBlockData(7, 2, 2)
+	mustApply(t, s, blockData, APPLIED)
+
+	hash := blockData.Block.Header.Hash().Hex()
+	if got := string(s.values["blockhash:7"]); got != hash {
+		t.Errorf("blockhash:7 = %q, want %q", got, hash)
+	}
+
+	if got := string(s.values["blocknumber:"+strings.ToLower(hash)]); got != "7" {
+		t.Errorf("blocknumber:%s = %q, want %q", strings.ToLower(hash), got, "7")
+	}
+
+	want := []string{"block:7", "blockhash:7", "blocknumber:" + strings.ToLower(hash)}
+	entries := []string{"idx:blocks|block:7"}
+	for txHash, events := range blockData.Events {
+		want = append(want, "tx:"+txHash)
+		for _, event := range events {
+			address := strings.ToLower(event.Address.Hex())
+			eventKey := fmt.Sprintf("event:%s_7_%s_%d", address, txHash, event.Index)
+			want = append(want, eventKey)
+			entries = append(entries, "idx:addr:"+address+"|"+eventKey)
+			for position, topic := range event.Topics {
+				entries = append(entries, fmt.Sprintf("idx:topic:%d:%s|%s", position, strings.ToLower(topic.Hex()), eventKey))
+			}
+
+			if score := s.zsets["idx:addr:"+address][eventKey]; score != eventScore(7, event.Index) {
+				t.Errorf("score of %s = %v, want %v", eventKey, score, eventScore(7, event.Index))
+			}
+		}
+	}
+
+	for _, key := range want {
+		if _, ok := s.values[key]; !ok {
+			t.Errorf("key %s not stored", key)
+		}
+		if _, ok := s.expiries[key]; !ok {
+			t.Errorf("key %s stored without TTL", key)
+		}
+	}
+	if score, ok := s.zsets[BLOCKS_INDEX_KEY]["block:7"]; !ok || score != 7 {
+		t.Errorf("block:7 in blocks index with score %v (%t), want 7", score, ok)
+	}
+	for _, entry := range entries {
+		if _, ok := s.zsets[INDEX_EXPIRY_KEY][entry]; !ok {
+			t.Errorf("index entry %s has no expiry", entry)
+		}
+	}
+
+	var manifest []string
+	for member := range s.sets["manifest:7"] {
+		manifest = append(manifest, member)
+	}
+	all := append(want, entries...)
+	slices.Sort(manifest)
+	slices.Sort(all)
+	if !slices.Equal(manifest, all) {
+		t.Errorf("manifest:7 = %v, want %v", manifest, all)
+	}
+}
+
+func TestStoreApplyBlockData(t *testing.T) {
+	for name, s := range testStores(t, 0) {
+		t.Run(name, func(t *testing.T) { testApplyBlockData(t, s) })
+	}
+}
+
+func testApplyBlockData(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	original := testBlockData(5, 2, 1)
+	mustApply(t, s, original, APPLIED)
+	mustApply(t, s, testBlockData(5, 2, 1), DUPLICATE)
+
+	reorged := testBlockData(5, 1, 1)
+	reorged.Block.Header.Extra = []byte("reorg")
+	mustApply(t, s, reorged, REORGED)
+
+	block, err := s.GetBlockByNumber(ctx, "5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Header.Hash() != reorged.Block.Header.Hash() {
+		t.Errorf("block 5 has hash %s, want the reorged one %s", block.Header.Hash(), reorged.Block.Header.Hash())
+	}
+
+	// Transactions and events of the replaced block are gone, unless the new block has them too
+	for txHash := range original.TransactionHashes {
+		_, err := s.GetTransactionByHash(ctx, txHash)
+		if _, kept := reorged.TransactionHashes[txHash]; kept != (err == nil) {
+			t.Errorf("transaction %s: kept = %t, error = %v", txHash, kept, err)
+		}
+	}
+	events, err := s.GetEventsByAddress(ctx, testAddress(1), Page{})
+	if err != nil || len(events) != 0 {
+		t.Errorf("events of the replaced block = %d (%v), want none", len(events), err)
+	}
+
+	stats, _ := s.GetStats(ctx)
+	want := map[string]int64{STAT_APPLIED: 2, STAT_DUPLICATES: 1, STAT_CONFLICTS: 1, STAT_BACKFILLED: 0}
+	for stat, n := range want {
+		if stats[stat] != n {
+			t.Errorf("stat %s = %d, want %d", stat, stats[stat], n)
+		}
+	}
+}
+
+func TestStoreRetention(t *testing.T) {
+	for name, s := range testStores(t, 3) {
+		t.Run(name, func(t *testing.T) { testRetention(t, s) })
+	}
+}
+
+func testRetention(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	for n := int64(1); n <= 6; n++ {
+		mustApply(t, s, testBlockData(n, 1, 1), APPLIED)
+	}
+
+	if got, want := blockKeys(t, s), []string{"block:4", "block:5", "block:6"}; !slices.Equal(got, want) {
+		t.Errorf("blocks = %v, want %v", got, want)
+	}
+	if stored, _ := s.IsBlockStored(ctx, 3); stored {
+		t.Error("block 3 still stored after leaving the retention window")
+	}
+
+	events, _ := s.GetEventsByAddress(ctx, testAddress(0), Page{})
+	if len(events) != 3 {
+		t.Errorf("got %d events, want the 3 of the retained blocks", len(events))
+	}
+
+	if m, ok := s.(*MemoryStore); ok {
+		if _, ok := m.sets["manifest:3"]; ok {
+			t.Error("manifest of evicted block 3 still stored")
+		}
+		for entry := range m.zsets[INDEX_EXPIRY_KEY] {
+			if strings.Contains(entry, "_3_") || strings.HasSuffix(entry, "block:3") {
+				t.Errorf("expiry entry %s of evicted block 3 left behind", entry)
+			}
+		}
+	}
+}
+
+func TestMemoryStoreExpiry(t *testing.T) {
+	ctx := context.Background()
+	s, advance := newTestMemoryStore(t, 0, time.Minute)
+
+	mustApply(t, s, testBlockData(1, 1, 1), APPLIED)
+	advance(2 * time.Minute)
+
+	if blocks := blockKeys(t, s); len(blocks) != 0 {
+		t.Errorf("blocks = %v after expiry, want none", blocks)
+	}
+	if _, err := s.GetBlockByNumber(ctx, "1"); !errors.Is(err, eth_err.ErrNotFound) {
+		t.Errorf("expired block: got error %v, want ErrNotFound", err)
+	}
+	if events, _ := s.GetEventsByAddress(ctx, testAddress(0), Page{}); len(events) != 0 {
+		t.Errorf("got %d events after expiry, want none", len(events))
+	}
+
+	// The next write prunes the index entries of the expired keys
+	mustApply(t, s, testBlockData(2, 1, 1), APPLIED)
+	if len(s.zsets[BLOCKS_INDEX_KEY]) != 1 || len(s.zsets[INDEX_EXPIRY_KEY]) != 3 {
+		t.Errorf("indexes not pruned: blocks %v, expiry %v", s.zsets[BLOCKS_INDEX_KEY], s.zsets[INDEX_EXPIRY_KEY])
+	}
+	if _, ok := s.sets["manifest:1"]; ok {
+		t.Error("manifest of expired block 1 still stored")
+	}
+}
+
+func TestStoreBlockExpiry(t *testing.T) {
+	for name, s := range testStores(t, 0) {
+		// The embedded store has no TTL
+		if s, ok := s.(Expiring); ok {
+			t.Run(name, func(t *testing.T) { testBlockExpiry(t, s) })
+		}
+	}
+}
+
+func testBlockExpiry(t *testing.T, s Expiring) {
+	ctx := context.Background()
+
+	mustApply(t, s.(Store), testBlockData(1, 1, 1), APPLIED)
+	if _, err := s.ApplyBlockDataWithExpiry(ctx, testBlockData(2, 1, 1), 10*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	for blockNumber, want := range map[string]time.Duration{"1": time.Hour, "2": 10 * time.Minute} {
+		expiry, err := s.GetBlockExpiry(ctx, blockNumber)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ttl := time.Until(expiry); ttl > want || ttl < want-time.Minute {
+			t.Errorf("block %s expires in %v, want %v", blockNumber, ttl, want)
+		}
+	}
+
+	// A block stored without an expiry keeps its keys and index entries past the pruning of the next write
+	if _, err := s.ApplyBlockDataWithExpiry(ctx, testBlockData(3, 1, 1), 0); err != nil {
+		t.Fatal(err)
+	}
+	mustApply(t, s.(Store), testBlockData(4, 1, 1), APPLIED)
+	if expiry, err := s.GetBlockExpiry(ctx, "3"); err != nil || !expiry.IsZero() {
+		t.Errorf("block 3 stored without expiry expires at %v (error %v), want never", expiry, err)
+	}
+	if blocks, err := s.(Store).GetAllBlockNumbers(ctx, Page{}); err != nil || len(blocks) != 4 {
+		t.Errorf("blocks = %v (error %v), want 4 blocks", blocks, err)
+	}
+
+	if _, err := s.GetBlockExpiry(ctx, "42"); !errors.Is(err, eth_err.ErrNotFound) {
+		t.Errorf("GetBlockExpiry of a missing block: got error %v, want ErrNotFound", err)
+	}
+}
+
+func TestStoreGetEventsByAddress(t *testing.T) {
+	for name, s := range testStores(t, 0) {
+		t.Run(name, func(t *testing.T) { testGetEventsByAddress(t, s) })
+	}
+}
+
+func testGetEventsByAddress(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	// Applied out of order, the events must still come back by block number and log index
+	mustApply(t, s, testBlockData(9, 3, 2), APPLIED)
+	mustApply(t, s, testBlockData(8, 3, 2), APPLIED)
+
+	events, err := s.GetEventsByAddress(ctx, testAddress(2), Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, event := range events {
+		got = append(got, fmt.Sprint(event.BlockNumber, "/", event.Index))
+	}
+	if want := []string{"8/4", "8/5", "9/4", "9/5"}; !slices.Equal(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+
+	if events, _ := s.GetEventsByAddress(ctx, testAddress(7), Page{}); len(events) != 0 {
+		t.Errorf("got %d events of an unknown address, want none", len(events))
+	}
+}
+
+func TestStoreNotFound(t *testing.T) {
+	for name, s := range testStores(t, 0) {
+		t.Run(name, func(t *testing.T) { testNotFound(t, s) })
+	}
+}
+
+func testNotFound(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	if _, err := s.GetBlockByNumber(ctx, "42"); !errors.Is(err, eth_err.ErrNotFound) {
+		t.Errorf("GetBlockByNumber: got error %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetTransactionByHash(ctx, "0x42"); !errors.Is(err, eth_err.ErrNotFound) {
+		t.Errorf("GetTransactionByHash: got error %v, want ErrNotFound", err)
+	}
+	if blocks := blockKeys(t, s); blocks == nil || len(blocks) != 0 {
+		t.Errorf("blocks = %#v, want an empty slice", blocks)
+	}
+}
+
+func TestStoreGetBlockByHash(t *testing.T) {
+	for name, s := range testStores(t, 2) {
+		t.Run(name, func(t *testing.T) { testGetBlockByHash(t, s) })
+	}
+}
+
+func testGetBlockByHash(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	var hashes []string
+	for n := int64(1); n <= 3; n++ {
+		blockData := testBlockData(n, 1, 1)
+		mustApply(t, s, blockData, APPLIED)
+		hashes = append(hashes, blockData.Block.Header.Hash().Hex())
+	}
+
+	// Hashes are looked up whatever their case
+	block, err := s.GetBlockByHash(ctx, "0x"+strings.ToUpper(hashes[2][2:]))
+	if err != nil || block.Header.Hash().Hex() != hashes[2] {
+		t.Errorf("GetBlockByHash(%s) = %v, %v", hashes[2], block, err)
+	}
+
+	// Evicting a block drops its hash along with it
+	if _, err := s.GetBlockByHash(ctx, hashes[0]); !errors.Is(err, eth_err.ErrNotFound) {
+		t.Errorf("GetBlockByHash of an evicted block: got error %v, want ErrNotFound", err)
+	}
+}
+
+func TestStoreGetLogs(t *testing.T) {
+	for name, s := range testStores(t, 0) {
+		t.Run(name, func(t *testing.T) { testGetLogs(t, s) })
+	}
+}
+
+func testGetLogs(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	// Every block has 3 transactions to addresses 0 to 2, each emitting 2 events with topics 0x0 then 0x1
+	for n := int64(1); n <= 4; n++ {
+		mustApply(t, s, testBlockData(n, 3, 2), APPLIED)
+	}
+	topic := func(i int64) string { return strings.ToLower(common.BigToHash(big.NewInt(i)).Hex()) }
+
+	for _, test := range []struct {
+		name   string
+		filter LogFilter
+		want   int
+	}{
+		{"range", LogFilter{FromBlock: 2, ToBlock: 3}, 12},
+		{"single address", LogFilter{FromBlock: 1, ToBlock: 4, Addresses: []string{testAddress(1)}}, 8},
+		{"address set", LogFilter{FromBlock: 1, ToBlock: 4, Addresses: []string{testAddress(0), testAddress(2)}}, 16},
+		{"topic", LogFilter{FromBlock: 1, ToBlock: 4, Topics: [][]string{{topic(1)}}}, 12},
+		{"topic set", LogFilter{FromBlock: 1, ToBlock: 4, Topics: [][]string{{topic(0), topic(1)}}}, 24},
+		{"wildcard position", LogFilter{FromBlock: 1, ToBlock: 4, Topics: [][]string{nil, {topic(0)}}}, 0},
+		{"address and topic", LogFilter{FromBlock: 4, ToBlock: 4, Addresses: []string{testAddress(2)}, Topics: [][]string{{topic(0)}}}, 1},
+		{"unknown address", LogFilter{FromBlock: 1, ToBlock: 4, Addresses: []string{testAddress(9)}}, 0},
+		{"empty range", LogFilter{FromBlock: 4, ToBlock: 1}, 0},
+	} {
+		events, err := s.GetLogs(ctx, &test.filter, Page{})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if events == nil || len(events) != test.want {
+			t.Errorf("%s: got %d events, want %d", test.name, len(events), test.want)
+			continue
+		}
+
+		for i, event := range events {
+			if !test.filter.Matches(event) {
+				t.Errorf("%s: event %d/%d does not match the filter", test.name, event.BlockNumber, event.Index)
+			}
+			if i > 0 && eventScore(event.BlockNumber, event.Index) <= eventScore(events[i-1].BlockNumber, events[i-1].Index) {
+				t.Errorf("%s: events out of (block, log index) order", test.name)
+			}
+		}
+	}
+}
+
+func TestStorePaging(t *testing.T) {
+	for name, s := range testStores(t, 0) {
+		t.Run(name, func(t *testing.T) { testPaging(t, s) })
+	}
+}
+
+func testPaging(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	for n := int64(1); n <= 4; n++ {
+		mustApply(t, s, testBlockData(n, 3, 2), APPLIED)
+	}
+	topic := strings.ToLower(common.BigToHash(big.NewInt(1)).Hex())
+
+	// walk reads every page of a list of events, applying a new block after the first page, which the walk
+	// must pick up at the end without skipping or repeating an event
+	next := int64(5)
+	walk := func(read func(Page) ([]*types.Log, error), limit int) []string {
+		var got []string
+		page := Page{Limit: limit}
+		for {
+			events, err := read(page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) > limit {
+				t.Fatalf("got a page of %d events, want at most %d", len(events), limit)
+			}
+			for _, event := range events {
+				got = append(got, fmt.Sprint(event.BlockNumber, "/", event.Index))
+			}
+			if len(events) < limit {
+				return got
+			}
+			if page.After == nil {
+				mustApply(t, s, testBlockData(next, 3, 2), APPLIED)
+				next++
+			}
+			page.After = &Cursor{BlockNumber: events[len(events)-1].BlockNumber, LogIndex: events[len(events)-1].Index}
+		}
+	}
+
+	for _, test := range []struct {
+		name  string
+		read  func(Page) ([]*types.Log, error)
+		limit int
+		want  int
+	}{
+		{"address", func(page Page) ([]*types.Log, error) { return s.GetEventsByAddress(ctx, testAddress(1), page) }, 3, 10},
+		{"range", func(page Page) ([]*types.Log, error) {
+			return s.GetLogs(ctx, &LogFilter{FromBlock: 2, ToBlock: 10}, page)
+		}, 4, 30},
+		{"topic", func(page Page) ([]*types.Log, error) {
+			return s.GetLogs(ctx, &LogFilter{FromBlock: 1, ToBlock: 10, Topics: [][]string{{topic}}}, page)
+		}, 5, 21},
+	} {
+		got := walk(test.read, test.limit)
+		all, err := test.read(Page{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want []string
+		for _, event := range all {
+			want = append(want, fmt.Sprint(event.BlockNumber, "/", event.Index))
+		}
+		if len(want) != test.want || !slices.Equal(got, want) {
+			t.Errorf("%s: paged events = %v, want %v (%d)", test.name, got, want, test.want)
+		}
+	}
+
+	blocks, err := s.GetAllBlockNumbers(ctx, Page{After: &Cursor{BlockNumber: 2}, Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"block:3", "block:4", "block:5"}; !slices.Equal(blocks, want) {
+		t.Errorf("blocks = %v, want %v", blocks, want)
+	}
+}
+
+func TestStoreWatermark(t *testing.T) {
+	for name, s := range testStores(t, 0) {
+		t.Run(name, func(t *testing.T) { testWatermark(t, s) })
+	}
+}
+
+func testWatermark(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	for _, step := range []struct{ set, want uint64 }{{5, 5}, {3, 5}, {9, 9}} {
+		if got, _ := s.SetWatermark(ctx, step.set); got != step.want {
+			t.Errorf("SetWatermark(%d) = %d, want %d", step.set, got, step.want)
+		}
+	}
+	if got, _ := s.GetWatermark(ctx); got != 9 {
+		t.Errorf("GetWatermark() = %d, want 9", got)
+	}
+}
+
+func TestStoreBlockBounds(t *testing.T) {
+	for name, s := range testStores(t, 0) {
+		t.Run(name, func(t *testing.T) { testBlockBounds(t, s) })
+	}
+}
+
+func testBlockBounds(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	if _, err := s.EarliestBlock(ctx); !errors.Is(err, eth_err.ErrNotFound) {
+		t.Errorf("EarliestBlock of an empty store: got error %v, want ErrNotFound", err)
+	}
+	if _, err := s.LatestBlock(ctx); !errors.Is(err, eth_err.ErrNotFound) {
+		t.Errorf("LatestBlock of an empty store: got error %v, want ErrNotFound", err)
+	}
+
+	for _, number := range []int64{12, 9, 300} {
+		mustApply(t, s, testBlockData(number, 1, 1), APPLIED)
+	}
+	if got, err := s.EarliestBlock(ctx); err != nil || got != 9 {
+		t.Errorf("EarliestBlock() = %d, %v, want 9", got, err)
+	}
+	if got, err := s.LatestBlock(ctx); err != nil || got != 300 {
+		t.Errorf("LatestBlock() = %d, %v, want 300", got, err)
+	}
+
+	if err := s.RemoveBlockData(ctx, "300"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.LatestBlock(ctx); err != nil || got != 12 {
+		t.Errorf("LatestBlock() after removing block 300 = %d, %v, want 12", got, err)
+	}
+}
+
+func TestStoreDetailLevels(t *testing.T) {
+	stores := testStores(t, 0)
+
+	// The fields of the detail levels are optional in the RLP layout
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	stores["redis+rlp"] = NewRedisStore(rdb, &config.Config{
+		STORAGE_CODEC:         model.Codec{Encoding: model.ENCODING_RLP, Compression: model.COMPRESSION_NONE},
+		REDIS_KEY_EXPIRY_TIME: time.Hour,
+	})
+
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) { testDetailLevels(t, s) })
+	}
+}
+
+func testDetailLevels(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	// A block ingested at the `tx_hashes` level only holds its header and transaction hashes
+	hashes := testBlockData(1, 2, 1)
+	hashes.Block = model.Block{Header: hashes.Block.Header, Detail: enum.TX_HASHES}
+	for txHash := range hashes.TransactionHashes {
+		hashes.Block.TxHashes = append(hashes.Block.TxHashes, common.HexToHash(txHash))
+	}
+	hashes.TransactionHashes = make(map[string]*types.Transaction)
+	hashes.Events = make(map[string][]*types.Log)
+	mustApply(t, s, hashes, APPLIED)
+
+	// A block ingested at the `traces` level, part of which the allowlists left out
+	traces := testBlockData(2, 1, 1)
+	tx := traces.Block.Body.Transactions[0]
+	traces.Block.Detail = enum.TRACES
+	traces.Block.Filtered = true
+	traces.Block.Receipts = []*model.Receipt{{TxHash: tx.Hash(), Status: 1, CumulativeGasUsed: 21000, GasUsed: 21000, EffectiveGasPrice: big.NewInt(1e9)}}
+	traces.Block.Traces = []*model.Trace{{TxHash: tx.Hash(), Result: []byte(`{"type":"CALL","gasUsed":"0x5208"}`)}}
+	mustApply(t, s, traces, APPLIED)
+
+	block, err := s.GetBlockByNumber(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Detail != enum.TX_HASHES || block.Body != nil || !slices.Equal(block.TxHashes, hashes.Block.TxHashes) {
+		t.Errorf("tx_hashes block = detail %q, body %v, %d hashes", block.Detail, block.Body, len(block.TxHashes))
+	}
+	if _, err := s.GetTransactionByHash(ctx, hashes.Block.TxHashes[0].Hex()); !errors.Is(err, eth_err.ErrNotFound) {
+		t.Errorf("GetTransactionByHash below the full level: got error %v, want ErrNotFound", err)
+	}
+
+	block, err = s.GetBlockByNumber(ctx, "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Detail != enum.TRACES || !block.Filtered || len(block.Receipts) != 1 || len(block.Traces) != 1 {
+		t.Fatalf("traces block = detail %q, filtered %v, %d receipts, %d traces", block.Detail, block.Filtered, len(block.Receipts), len(block.Traces))
+	}
+	if receipt := block.Receipts[0]; receipt.TxHash != tx.Hash() || receipt.GasUsed != 21000 || receipt.EffectiveGasPrice.Int64() != 1e9 {
+		t.Errorf("receipt = %+v", receipt)
+	}
+	if trace := block.Traces[0]; trace.TxHash != tx.Hash() || !strings.Contains(string(trace.Result), `"gasUsed":"0x5208"`) {
+		t.Errorf("trace = %s %s", trace.TxHash.Hex(), trace.Result)
+	}
+}
+
+func TestMemoryStoreConcurrency(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestMemoryStore(t, 0, time.Minute)
+
+	var wg sync.WaitGroup
+	for n := int64(1); n <= 20; n++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := s.ApplyBlockData(ctx, testBlockData(n, 2, 1)); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			s.GetAllBlockNumbers(ctx, Page{})
+			s.GetEventsByAddress(ctx, testAddress(0), Page{})
+			s.SetWatermark(ctx, uint64(n))
+		}()
+	}
+	wg.Wait()
+
+	if blocks := blockKeys(t, s); len(blocks) != 20 {
+		t.Errorf("got %d blocks, want 20", len(blocks))
+	}
+	if got, _ := s.GetWatermark(ctx); got != 20 {
+		t.Errorf("watermark = %d, want 20", got)
+	}
+}
+
+func TestStoreDeadLetters(t *testing.T) {
+	for name, s := range testStores(t, 0) {
+		if s, ok := s.(DeadLetters); ok {
+			t.Run(name, func(t *testing.T) { testDeadLetters(t, s) })
+		}
+	}
+}
+
+func testDeadLetters(t *testing.T, s DeadLetters) {
+	ctx := context.Background()
+
+	for _, want := range []int64{1, 2} {
+		id, err := s.NextDeadLetterID(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != want {
+			t.Fatalf("NextDeadLetterID() = %d, want %d", id, want)
+		}
+		if err := s.PutDeadLetter(ctx, fmt.Sprint(id), []byte(fmt.Sprint("entry ", id))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if entry, err := s.GetDeadLetter(ctx, "2"); err != nil || string(entry) != "entry 2" {
+		t.Errorf("GetDeadLetter(2) = %q, %v, want %q", entry, err, "entry 2")
+	}
+	if _, err := s.GetDeadLetter(ctx, "3"); !errors.Is(err, eth_err.ErrNotFound) {
+		t.Errorf("GetDeadLetter of a missing entry: got error %v, want ErrNotFound", err)
+	}
+
+	if err := s.RemoveDeadLetter(ctx, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveDeadLetter(ctx, "1"); !errors.Is(err, eth_err.ErrNotFound) {
+		t.Errorf("RemoveDeadLetter of a removed entry: got error %v, want ErrNotFound", err)
+	}
+	if entries, _ := s.ListDeadLetters(ctx); len(entries) != 1 || string(entries["2"]) != "entry 2" {
+		t.Errorf("ListDeadLetters() = %q, want only entry 2", entries)
+	}
+
+	if n, err := s.PurgeDeadLetters(ctx); err != nil || n != 1 {
+		t.Errorf("PurgeDeadLetters() = %d, %v, want 1", n, err)
+	}
+	if entries, _ := s.ListDeadLetters(ctx); len(entries) != 0 {
+		t.Errorf("entries left after purging: %q", entries)
+	}
+
+	// IDs are not reused after a purge
+	if id, _ := s.NextDeadLetterID(ctx); id != 3 {
+		t.Errorf("NextDeadLetterID() after purging = %d, want 3", id)
+	}
+}
@@ -0,0 +1,61 @@
+-- Blocks, their transactions and their logs. Values are stored encoded with `STORAGE_CODEC` in the `data`
+-- columns, next to the columns ad-hoc queries filter on. Transactions and logs are deleted along with their
+-- block, on reorgs and when the block falls out of the retention window. A transaction moved to another block
+-- by a reorg may briefly be stored twice, in which case it is looked up in the latest block.
+
+CREATE TABLE blocks (
+    number      BIGINT PRIMARY KEY,
+    hash        TEXT NOT NULL,
+    parent_hash TEXT NOT NULL,
+    timestamp   TIMESTAMPTZ NOT NULL,
+    gas_used    BIGINT NOT NULL,
+    tx_count    INTEGER NOT NULL,
+    data        BYTEA NOT NULL
+);
+
+CREATE UNIQUE INDEX blocks_hash_idx ON blocks (hash);
+CREATE INDEX blocks_timestamp_idx ON blocks (timestamp);
+
+CREATE TABLE transactions (
+    hash         TEXT NOT NULL,
+    block_number BIGINT NOT NULL REFERENCES blocks (number) ON DELETE CASCADE,
+    tx_index     INTEGER NOT NULL,
+    from_address TEXT,
+    to_address   TEXT,
+    value        NUMERIC(78, 0) NOT NULL,
+    data         BYTEA NOT NULL,
+    PRIMARY KEY (block_number, tx_index)
+);
+
+CREATE INDEX transactions_hash_idx ON transactions (hash, block_number);
+CREATE INDEX transactions_from_idx ON transactions (from_address, block_number);
+CREATE INDEX transactions_to_idx ON transactions (to_address, block_number);
+
+CREATE TABLE logs (
+    block_number BIGINT NOT NULL REFERENCES blocks (number) ON DELETE CASCADE,
+    log_index    INTEGER NOT NULL,
+    tx_hash      TEXT NOT NULL,
+    address      TEXT NOT NULL,
+    topic0       TEXT,
+    topic1       TEXT,
+    topic2       TEXT,
+    topic3       TEXT,
+    data         BYTEA NOT NULL,
+    PRIMARY KEY (block_number, log_index)
+);
+
+CREATE INDEX logs_address_idx ON logs (address, block_number, log_index);
+CREATE INDEX logs_tx_idx ON logs (tx_hash);
+CREATE INDEX logs_topic0_idx ON logs (topic0, block_number);
+
+-- Ingestion counters, as kept in the `stats:sub` hash by the Redis backend.
+CREATE TABLE ingestion_stats (
+    name  TEXT PRIMARY KEY,
+    value BIGINT NOT NULL
+);
+
+-- Single-value service state, such as the contiguous watermark.
+CREATE TABLE meta (
+    key   TEXT PRIMARY KEY,
+    value BIGINT NOT NULL
+);
@@ -0,0 +1,5 @@
+-- Indexes serving `eth_getLogs`-style filters on any topic position. Topic 0 is indexed since 0001.
+
+CREATE INDEX logs_topic1_idx ON logs (topic1, block_number);
+CREATE INDEX logs_topic2_idx ON logs (topic2, block_number);
+CREATE INDEX logs_topic3_idx ON logs (topic3, block_number);
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Cursor is a position in a list ordered by block number and log index. Lists of blocks only use the block number.
+type Cursor struct {
+	BlockNumber uint64
+	LogIndex    uint
+}
+
+// Page selects the items of a list which come after a cursor, up to a limit. Lists only ever grow at the end
+// as new blocks arrive, so walking a list page by page neither skips nor repeats an item.
+type Page struct {
+	// After is the position of the last item of the previous page, nil for the first page.
+	After *Cursor
+	// Limit is the maximum number of items of the page, 0 for no limit.
+	Limit int
+}
+
+// includes reports whether the item at the given position comes after the cursor.
+func (p Page) includes(blockNumber uint64, logIndex uint) bool {
+	if p.After == nil {
+		return true
+	}
+	if blockNumber != p.After.BlockNumber {
+		return blockNumber > p.After.BlockNumber
+	}
+	return logIndex > p.After.LogIndex
+}
+
+// full reports whether a page holding n items reached the limit.
+func (p Page) full(n int) bool {
+	return p.Limit > 0 && n >= p.Limit
+}
+
+// afterEventScore returns the event score of the cursor, -1 for the first page, every score being positive.
+func (p Page) afterEventScore() float64 {
+	if p.After == nil {
+		return -1
+	}
+	return eventScore(p.After.BlockNumber, p.After.LogIndex)
+}
+
+// afterBlockScore returns the block score of the cursor, -1 for the first page.
+func (p Page) afterBlockScore() float64 {
+	if p.After == nil {
+		return -1
+	}
+	return float64(p.After.BlockNumber)
+}
+
+// minEventScore returns the lower bound (ZRANGEBYSCORE syntax) of the event scores of the page.
+func (p Page) minEventScore() string {
+	if p.After == nil {
+		return "-inf"
+	}
+	return "(" + strconv.FormatFloat(p.afterEventScore(), 'f', -1, 64)
+}
+
+// minBlockScore returns the lower bound (ZRANGEBYSCORE syntax) of the block scores of the page.
+func (p Page) minBlockScore() string {
+	if p.After == nil {
+		return "-inf"
+	}
+	return "(" + strconv.FormatUint(p.After.BlockNumber, 10)
+}
+
+// filter narrows the block range of a log filter to the page.
+func (p Page) filter(filter *LogFilter) *LogFilter {
+	narrowed := *filter
+	if p.After != nil && p.After.BlockNumber > narrowed.FromBlock {
+		narrowed.FromBlock = p.After.BlockNumber
+	}
+	return &narrowed
+}
+
+// logs drops the events, ordered by block number and log index, which do not come after the cursor and
+// truncates them to the limit.
+func (p Page) logs(events []*types.Log) []*types.Log {
+	paged := events[:0]
+	for _, event := range events {
+		if p.full(len(paged)) {
+			break
+		}
+		if p.includes(event.BlockNumber, event.Index) {
+			paged = append(paged, event)
+		}
+	}
+	return paged
+}
@@ -0,0 +1,544 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"ethereum-data-service/internal/config"
+	"ethereum-data-service/internal/model"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	eth_err "ethereum-data-service/pkg/err"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/lib/pq"
+)
+
+const (
+	// watermarkMetaKey is the row of the meta table holding the contiguous watermark.
+	watermarkMetaKey = "watermark"
+	// migrationLockID is the advisory lock serializing concurrent migrations.
+	migrationLockID = 0x657468
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// PostgresStore is the PostgreSQL implementation of Store. Blocks, transactions and logs live in relational
+// tables (see `migrations/`), their values being encoded with `STORAGE_CODEC` as in Redis. There is no TTL:
+// blocks are kept for the `RETENTION_BLOCKS` window, or forever when it is 0.
+type PostgresStore struct {
+	db              *sql.DB
+	codec           model.Codec
+	retentionBlocks int
+}
+
+// OpenPostgresStore connects to the database at `POSTGRES_DSN` and applies the pending migrations.
+func OpenPostgresStore(ctx context.Context, cfg *config.Config) (*PostgresStore, error) {
+	db, err := sql.Open("postgres", cfg.POSTGRES_DSN)
+	if err != nil {
+		return nil, fmt.Errorf("error opening PostgreSQL database: %v", err)
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error connecting to PostgreSQL: %v", err)
+	}
+
+	if err := migrate(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &PostgresStore{
+		db:              db,
+		codec:           cfg.STORAGE_CODEC,
+		retentionBlocks: cfg.RETENTION_BLOCKS,
+	}, nil
+}
+
+// migrate applies the embedded migrations which have not been applied yet, in file name order, recording them
+// in the schema_migrations table. Concurrent callers are serialized by an advisory lock.
+func migrate(ctx context.Context, db *sql.DB) error {
+	files, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting migration: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("error locking migrations: %v", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    TEXT PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return fmt.Errorf("error creating schema_migrations table: %v", err)
+	}
+
+	for _, file := range files {
+		version := strings.TrimSuffix(strings.TrimPrefix(file, "migrations/"), ".sql")
+
+		var applied bool
+		err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, version).Scan(&applied)
+		if err != nil {
+			return fmt.Errorf("error checking migration %s: %v", version, err)
+		}
+		if applied {
+			continue
+		}
+
+		script, err := migrations.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, string(script)); err != nil {
+			return fmt.Errorf("error applying migration %s: %v", version, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, version); err != nil {
+			return fmt.Errorf("error recording migration %s: %v", version, err)
+		}
+		log.Printf("Applied PostgreSQL migration %s\n", version)
+	}
+
+	return tx.Commit()
+}
+
+// ApplyBlockData stores the block data in a single database transaction, skipping duplicates and replacing
+// conflicting blocks as the Redis backend does (see `storage.ApplyBlockData`).
+func (s *PostgresStore) ApplyBlockData(ctx context.Context, blockData *model.Data) (ApplyResult, error) {
+	header := blockData.Block.Header
+	number, hash := header.Number.Uint64(), header.Hash().Hex()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return APPLIED, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	result := APPLIED
+	var storedHash string
+	err = tx.QueryRowContext(ctx, `SELECT hash FROM blocks WHERE number = $1 FOR UPDATE`, number).Scan(&storedHash)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// First time we see this block number
+	case err != nil:
+		return APPLIED, fmt.Errorf("error fetching stored hash of block %d: %v", number, err)
+	case storedHash == hash:
+		log.Printf("Skipping duplicate block %d (%s)\n", number, hash)
+		if err := incrStat(ctx, tx, STAT_DUPLICATES); err != nil {
+			return DUPLICATE, err
+		}
+		return DUPLICATE, tx.Commit()
+	default:
+		log.Printf("Block %d conflicts with the stored one: %s replaces %s\n", number, hash, storedHash)
+		if _, err := tx.ExecContext(ctx, `DELETE FROM blocks WHERE number = $1`, number); err != nil {
+			return APPLIED, fmt.Errorf("error removing reorged block %d: %v", number, err)
+		}
+		if err := incrStat(ctx, tx, STAT_CONFLICTS); err != nil {
+			return APPLIED, err
+		}
+		result = REORGED
+	}
+
+	if err := s.insertBlockData(ctx, tx, blockData); err != nil {
+		return APPLIED, fmt.Errorf("error storing block %d in PostgreSQL: %v", number, err)
+	}
+
+	// Committing an older block never moves the window backwards, since the cutoff is taken from the latest block
+	if s.retentionBlocks > 0 {
+		_, err := tx.ExecContext(ctx, `DELETE FROM blocks WHERE number <= (SELECT max(number) FROM blocks) - $1`, s.retentionBlocks)
+		if err != nil {
+			return APPLIED, fmt.Errorf("error evicting blocks out of the retention window: %v", err)
+		}
+	}
+
+	if err := incrStat(ctx, tx, STAT_APPLIED); err != nil {
+		return APPLIED, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return APPLIED, fmt.Errorf("error committing block %d: %v", number, err)
+	}
+
+	log.Printf("Stored block %d in PostgreSQL\n", number)
+	return result, nil
+}
+
+// insertBlockData inserts the block, then copies its transactions and logs in bulk.
+func (s *PostgresStore) insertBlockData(ctx context.Context, tx *sql.Tx, blockData *model.Data) error {
+	header := blockData.Block.Header
+
+	blockValue, err := s.codec.MarshalBlock(&blockData.Block)
+	if err != nil {
+		return fmt.Errorf("error marshalling block data: %v", err)
+	}
+
+	var txs []*types.Transaction
+	if blockData.Block.Body != nil {
+		txs = blockData.Block.Body.Transactions
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO blocks (number, hash, parent_hash, timestamp, gas_used, tx_count, data)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		header.Number.Uint64(), header.Hash().Hex(), header.ParentHash.Hex(),
+		time.Unix(int64(header.Time), 0).UTC(), header.GasUsed, len(txs), blockValue)
+	if err != nil {
+		return err
+	}
+
+	txStmt, err := tx.PrepareContext(ctx, pq.CopyIn("transactions",
+		"hash", "block_number", "tx_index", "from_address", "to_address", "value", "data"))
+	if err != nil {
+		return err
+	}
+	defer txStmt.Close()
+
+	for i, t := range txs {
+		value, err := s.codec.MarshalTx(t)
+		if err != nil {
+			return fmt.Errorf("error marshalling transaction %s: %v", t.Hash().Hex(), err)
+		}
+
+		var from, to interface{}
+		if sender, err := types.Sender(types.LatestSignerForChainID(t.ChainId()), t); err == nil {
+			from = strings.ToLower(sender.Hex())
+		}
+		if t.To() != nil {
+			to = strings.ToLower(t.To().Hex())
+		}
+
+		if _, err := txStmt.ExecContext(ctx, t.Hash().Hex(), header.Number.Uint64(), i, from, to, t.Value().String(), value); err != nil {
+			return err
+		}
+	}
+	if _, err := txStmt.ExecContext(ctx); err != nil {
+		return err
+	}
+
+	logStmt, err := tx.PrepareContext(ctx, pq.CopyIn("logs",
+		"block_number", "log_index", "tx_hash", "address", "topic0", "topic1", "topic2", "topic3", "data"))
+	if err != nil {
+		return err
+	}
+	defer logStmt.Close()
+
+	for txHash, events := range blockData.Events {
+		for _, event := range events {
+			value, err := s.codec.MarshalLog(event)
+			if err != nil {
+				return fmt.Errorf("error marshalling event %+v: %v", event, err)
+			}
+
+			topics := make([]interface{}, 4)
+			for i := 0; i < len(event.Topics) && i < len(topics); i++ {
+				topics[i] = event.Topics[i].Hex()
+			}
+
+			_, err = logStmt.ExecContext(ctx, header.Number.Uint64(), event.Index, txHash,
+				strings.ToLower(event.Address.Hex()), topics[0], topics[1], topics[2], topics[3], value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	_, err = logStmt.ExecContext(ctx)
+	return err
+}
+
+func (s *PostgresStore) RemoveBlockData(ctx context.Context, blockNumber string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM blocks WHERE number = $1`, blockNumber)
+	return err
+}
+
+func (s *PostgresStore) IsBlockStored(ctx context.Context, blockNumber uint64) (bool, error) {
+	var stored bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM blocks WHERE number = $1)`, blockNumber).Scan(&stored)
+	return stored, err
+}
+
+func (s *PostgresStore) GetEventsByAddress(ctx context.Context, address string, page Page) ([]*types.Log, error) {
+	query, args := pgEventPage(`SELECT data FROM logs WHERE address = $1`, []interface{}{address}, page)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching events from PostgreSQL: %v", err)
+	}
+	defer rows.Close()
+
+	events := make([]*types.Log, 0)
+	for rows.Next() {
+		var value []byte
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+
+		event, err := model.UnmarshalLog(value)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshalling event: %v", err)
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+func (s *PostgresStore) GetLogs(ctx context.Context, filter *LogFilter, page Page) ([]*types.Log, error) {
+	query := `SELECT data FROM logs WHERE block_number BETWEEN $1 AND $2`
+	args := []interface{}{filter.FromBlock, filter.ToBlock}
+	if len(filter.Addresses) > 0 {
+		args = append(args, pq.Array(filter.Addresses))
+		query += fmt.Sprintf(" AND address = ANY($%d)", len(args))
+	}
+	for position, topics := range filter.Topics {
+		if len(topics) == 0 || position >= MAX_TOPICS {
+			continue
+		}
+		args = append(args, pq.Array(topics))
+		query += fmt.Sprintf(" AND topic%d = ANY($%d)", position, len(args))
+	}
+	query, args = pgEventPage(query, args, page)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching filtered events from PostgreSQL: %v", err)
+	}
+	defer rows.Close()
+
+	events := make([]*types.Log, 0)
+	for rows.Next() {
+		var value []byte
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+
+		event, err := model.UnmarshalLog(value)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshalling event: %v", err)
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+func (s *PostgresStore) GetBlockByNumber(ctx context.Context, blockNumber string) (*model.Block, error) {
+	number, err := strconv.ParseUint(blockNumber, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("block %s: %w", blockNumber, eth_err.ErrNotFound)
+	}
+
+	var value []byte
+	err = s.db.QueryRowContext(ctx, `SELECT data FROM blocks WHERE number = $1`, number).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("block %s: %w", blockNumber, eth_err.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return model.UnmarshalBlock(value)
+}
+
+func (s *PostgresStore) GetBlockByHash(ctx context.Context, blockHash string) (*model.Block, error) {
+	var value []byte
+	err := s.db.QueryRowContext(ctx, `SELECT data FROM blocks WHERE hash = $1`, strings.ToLower(blockHash)).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("block %s: %w", blockHash, eth_err.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return model.UnmarshalBlock(value)
+}
+
+func (s *PostgresStore) GetTransactionByHash(ctx context.Context, txHash string) (*types.Transaction, error) {
+	var value []byte
+	err := s.db.QueryRowContext(ctx, `SELECT data FROM transactions WHERE hash = $1 ORDER BY block_number DESC LIMIT 1`, txHash).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("transaction %s: %w", txHash, eth_err.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return model.UnmarshalTx(value)
+}
+
+func (s *PostgresStore) GetBlockData(ctx context.Context, blockNumber string) (*model.Data, error) {
+	block, err := s.GetBlockByNumber(ctx, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT data FROM logs WHERE block_number = $1 ORDER BY log_index`, block.Header.Number.Uint64())
+	if err != nil {
+		return nil, fmt.Errorf("error fetching events of block %s from PostgreSQL: %v", blockNumber, err)
+	}
+	defer rows.Close()
+
+	var events []*types.Log
+	for rows.Next() {
+		var value []byte
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+
+		event, err := model.UnmarshalLog(value)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshalling event: %v", err)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return newBlockData(block, events), nil
+}
+
+func (s *PostgresStore) GetAllBlockNumbers(ctx context.Context, page Page) ([]string, error) {
+	query, args := `SELECT number FROM blocks`, []interface{}{}
+	if page.After != nil {
+		args = append(args, page.After.BlockNumber)
+		query += ` WHERE number > $1`
+	}
+	query += ` ORDER BY number`
+	if page.Limit > 0 {
+		args = append(args, page.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching blocks from PostgreSQL: %v", err)
+	}
+	defer rows.Close()
+
+	blocks := make([]string, 0)
+	for rows.Next() {
+		var number uint64
+		if err := rows.Scan(&number); err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, fmt.Sprint(BLOCK_PREFIX, number))
+	}
+
+	return blocks, rows.Err()
+}
+
+func (s *PostgresStore) IncrStat(ctx context.Context, stat string) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err == nil {
+		defer tx.Rollback()
+		if err = incrStat(ctx, tx, stat); err == nil {
+			err = tx.Commit()
+		}
+	}
+	if err != nil {
+		log.Printf("error incrementing %s counter: %v\n", stat, err)
+	}
+}
+
+func (s *PostgresStore) GetStats(ctx context.Context) (map[string]int64, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT name, value FROM ingestion_stats`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := map[string]int64{STAT_APPLIED: 0, STAT_DUPLICATES: 0, STAT_CONFLICTS: 0, STAT_BACKFILLED: 0}
+	for rows.Next() {
+		var name string
+		var value int64
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		stats[name] = value
+	}
+
+	return stats, rows.Err()
+}
+
+func (s *PostgresStore) EarliestBlock(ctx context.Context) (uint64, error) {
+	return s.blockBound(ctx, `SELECT MIN(number) FROM blocks`)
+}
+
+func (s *PostgresStore) LatestBlock(ctx context.Context) (uint64, error) {
+	return s.blockBound(ctx, `SELECT MAX(number) FROM blocks`)
+}
+
+// blockBound runs a query aggregating the block numbers, which is NULL when no block is stored.
+func (s *PostgresStore) blockBound(ctx context.Context, query string) (uint64, error) {
+	var number sql.NullInt64
+	if err := s.db.QueryRowContext(ctx, query).Scan(&number); err != nil {
+		return 0, fmt.Errorf("error fetching blocks from PostgreSQL: %v", err)
+	}
+	if !number.Valid {
+		return 0, fmt.Errorf("no block stored: %w", eth_err.ErrNotFound)
+	}
+	return uint64(number.Int64), nil
+}
+
+func (s *PostgresStore) GetWatermark(ctx context.Context) (uint64, error) {
+	var watermark uint64
+	err := s.db.QueryRowContext(ctx, `SELECT value FROM meta WHERE key = $1`, watermarkMetaKey).Scan(&watermark)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return watermark, err
+}
+
+func (s *PostgresStore) SetWatermark(ctx context.Context, blockNumber uint64) (uint64, error) {
+	var watermark uint64
+	err := s.db.QueryRowContext(ctx, `INSERT INTO meta (key, value) VALUES ($1, $2)
+		ON CONFLICT (key) DO UPDATE SET value = greatest(meta.value, excluded.value)
+		RETURNING value`, watermarkMetaKey, blockNumber).Scan(&watermark)
+	return watermark, err
+}
+
+// Close closes the database connection pool.
+func (s *PostgresStore) Close() error {
+	return s.db.Close()
+}
+
+// incrStat increments an ingestion counter within tx.
+func incrStat(ctx context.Context, tx *sql.Tx, stat string) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO ingestion_stats (name, value) VALUES ($1, 1)
+		ON CONFLICT (name) DO UPDATE SET value = ingestion_stats.value + 1`, stat)
+	if err != nil {
+		return fmt.Errorf("error incrementing %s counter: %v", stat, err)
+	}
+	return nil
+}
+
+// pgEventPage completes a query of the logs table, already holding a WHERE clause, to select a page of events
+// ordered by block number and log index.
+func pgEventPage(query string, args []interface{}, page Page) (string, []interface{}) {
+	if page.After != nil {
+		args = append(args, page.After.BlockNumber, page.After.LogIndex)
+		query += fmt.Sprintf(" AND (block_number, log_index) > ($%d, $%d)", len(args)-1, len(args))
+	}
+	query += " ORDER BY block_number, log_index"
+	if page.Limit > 0 {
+		args = append(args, page.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	return query, args
+}
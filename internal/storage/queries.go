@@ -2,97 +2,353 @@ package storage
 
 import (
 	"context"
-	"encoding/json"
 	"ethereum-data-service/internal/model"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/redis/go-redis/v9"
 )
 
 // GetEventsByAddress retrieves all events related to a specific Ethereum address from Redis.
-// It takes a Redis client and an address as input, fetches the stored event data, and unmarshals it into a slice of Ethereum log events.
+// It takes a Redis client and an address as input, looks the event keys up in the index of the address, fetches
+// the stored event data with a single MGET, and decodes it into a slice of Ethereum log events whichever codec it
+// was stored with. Events are ordered by block number and log index, and only those of the page are read.
 // Returns a slice of logs or an error if any operation fails.
-func GetEventsByAddress(rdb *redis.Client, address string) ([]*types.Log, error) {
+func GetEventsByAddress(rdb redis.UniversalClient, ks Keyspace, address string, page Page) ([]*types.Log, error) {
+	ctx := context.Background()
 
-	// Use the Redis wild card pattern
-	keyPattern := fmt.Sprint(EVENT_PREFIX, address, "_*")
+	keys, err := indexMembers(ctx, rdb, ks, ks.Key(ADDRESS_INDEX_PREFIX)+address, page.minEventScore(), page.Limit)
+	if err != nil {
+		return nil, err
+	}
 
-	ctx := context.Background()
+	events := make([]*types.Log, 0, len(keys))
+	if len(keys) == 0 {
+		return events, nil
+	}
+
+	values, err := rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error fetching events from Redis: %v", err)
+	}
+
+	for _, value := range values {
+		// The key expired or was removed since the index was last pruned
+		eventJSON, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		event, err := model.UnmarshalLog([]byte(eventJSON))
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshalling event: %v", err)
+		}
+
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// GetLogs retrieves the events matching a filter from Redis, ordered by block number and log index.
+// It counts the events within the block range of each index the filter can be served from (see `indexOptions`),
+// reads the event keys of the smallest one and fetches them with a single MGET, keeping the events which match the
+// whole filter. A filter on the block range alone reads the event keys from the manifests of the blocks in range.
+// Only the events of the page are returned; the indexes are read from the cursor on, and only up to the limit
+// when a single index set serves the filter exactly.
+func GetLogs(ctx context.Context, rdb redis.UniversalClient, ks Keyspace, filter *LogFilter, page Page) ([]*types.Log, error) {
+	events := make([]*types.Log, 0)
+	filter = page.filter(filter)
+	if filter.FromBlock > filter.ToBlock {
+		return events, nil
+	}
+
+	if _, err := PruneIndexes(ctx, rdb, ks); err != nil {
+		return nil, err
+	}
+
+	keys, err := filteredEventKeys(ctx, rdb, ks, filter, page)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return events, nil
+	}
 
-	// Get all keys matching the pattern from Redis
-	keys, err := rdb.Keys(ctx, keyPattern).Result()
+	values, err := rdb.MGet(ctx, keys...).Result()
 	if err != nil {
-		return nil, fmt.Errorf("error fetching keys from Redis: %v", err)
+		return nil, fmt.Errorf("error fetching events from Redis: %v", err)
 	}
 
-	events := make([]*types.Log, len(keys))
+	for _, value := range values {
+		eventJSON, ok := value.(string)
+		if !ok {
+			continue
+		}
 
-	// Iterate through keys and retrieve events
-	for idx, key := range keys {
-		eventJSON, err := rdb.Get(ctx, key).Result()
+		event, err := model.UnmarshalLog([]byte(eventJSON))
 		if err != nil {
-			return nil, fmt.Errorf("error fetching event from Redis: %v", err)
+			return nil, fmt.Errorf("error unmarshalling event: %v", err)
+		}
+		if filter.Matches(event) {
+			events = append(events, event)
 		}
+	}
 
-		var event types.Log
-		if err := json.Unmarshal([]byte(eventJSON), &event); err != nil {
-			return nil, fmt.Errorf("error unmarshalling event JSON: %v", err)
+	return page.logs(sortLogs(events)), nil
+}
+
+// filteredEventKeys returns the keys of the events within the block range of the smallest index set the filter
+// can be served from, or of every event of the blocks in range if the filter constrains neither addresses nor topics.
+func filteredEventKeys(ctx context.Context, rdb redis.UniversalClient, ks Keyspace, filter *LogFilter, page Page) ([]string, error) {
+	min := strconv.FormatFloat(eventScore(filter.FromBlock, 0), 'f', -1, 64)
+	max := strconv.FormatFloat(eventScore(filter.ToBlock, 1<<logIndexBits-1), 'f', -1, 64)
+	if page.After != nil {
+		min = page.minEventScore()
+	}
+
+	options := filter.indexOptions(ks)
+	if len(options) == 0 {
+		blockKeys, err := rdb.ZRangeByScore(ctx, ks.Key(BLOCKS_INDEX_KEY), &redis.ZRangeBy{
+			Min: strconv.FormatUint(filter.FromBlock, 10),
+			Max: strconv.FormatUint(filter.ToBlock, 10),
+		}).Result()
+		if err != nil {
+			return nil, fmt.Errorf("error fetching index %s from Redis: %v", ks.Key(BLOCKS_INDEX_KEY), err)
 		}
 
-		events[idx] = &event
+		// Read whole blocks until the page is full, not counting the events of the block of the cursor, which
+		// may come before it
+		var keys []string
+		counted := 0
+		for _, blockKey := range blockKeys {
+			if page.full(counted) {
+				break
+			}
+
+			blockNumber := strings.TrimPrefix(blockKey, ks.Key(BLOCK_PREFIX))
+			blockKeys, _, err := blockManifest(ctx, rdb, ks, blockNumber)
+			if err != nil {
+				return nil, err
+			}
+			for _, key := range blockKeys {
+				if strings.HasPrefix(key, ks.Key(EVENT_PREFIX)) {
+					keys = append(keys, key)
+					if page.After == nil || blockNumber != strconv.FormatUint(page.After.BlockNumber, 10) {
+						counted++
+					}
+				}
+			}
+		}
+		return keys, nil
 	}
 
-	return events, nil
+	// Count the events in range of every index in a single round trip
+	counts := make([][]*redis.IntCmd, len(options))
+	_, err := rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, indexKeys := range options {
+			for _, indexKey := range indexKeys {
+				counts[i] = append(counts[i], pipe.ZCount(ctx, indexKey, min, max))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error counting filtered events in Redis: %v", err)
+	}
+
+	best, bestCount := 0, int64(-1)
+	for i, cmds := range counts {
+		var count int64
+		for _, cmd := range cmds {
+			count += cmd.Val()
+		}
+		if bestCount < 0 || count < bestCount {
+			best, bestCount = i, count
+		}
+	}
+	if bestCount == 0 {
+		return nil, nil
+	}
+
+	// With a single index set every event read matches the filter, so no index is read past the limit
+	var limit int64
+	if len(options) == 1 {
+		limit = int64(page.Limit)
+	}
+
+	ranges := make([]*redis.StringSliceCmd, 0, len(options[best]))
+	_, err = rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, indexKey := range options[best] {
+			ranges = append(ranges, pipe.ZRangeByScore(ctx, indexKey, &redis.ZRangeBy{Min: min, Max: max, Count: limit}))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching filtered events from Redis: %v", err)
+	}
+
+	// An event is listed in several indexes of the set when the filter lists several of its topics
+	seen := make(map[string]bool)
+	var keys []string
+	for _, cmd := range ranges {
+		for _, key := range cmd.Val() {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	return keys, nil
 }
 
 // GetBlockByNumber retrieves a specific Ethereum block by its number from Redis.
-// It takes a Redis client and a block number as input, fetches the stored block data, and unmarshals it into a Block struct.
+// It takes a Redis client and a block number as input, fetches the stored block data, and decodes it into a Block struct.
 // Returns a pointer to the Block struct or an error if any operation fails.
-func GetBlockByNumber(rdb *redis.Client, blockNumber string) (*model.Block, error) {
-	data, err := rdb.Get(context.Background(), BLOCK_PREFIX+blockNumber).Result()
+func GetBlockByNumber(rdb redis.UniversalClient, ks Keyspace, blockNumber string) (*model.Block, error) {
+	data, err := rdb.Get(context.Background(), ks.Key(BLOCK_PREFIX)+blockNumber).Result()
 	if err != nil {
 		return nil, err
 	}
 
-	var block model.Block
-	if err := json.Unmarshal([]byte(data), &block); err != nil {
+	return model.UnmarshalBlock([]byte(data))
+}
+
+// GetBlockByHash retrieves a specific Ethereum block by its hash from Redis.
+// It looks the block number up by the hash (lower case) and fetches the block stored under that number.
+// Returns redis.Nil if no block with that hash is stored.
+func GetBlockByHash(rdb redis.UniversalClient, ks Keyspace, blockHash string) (*model.Block, error) {
+	blockNumber, err := rdb.Get(context.Background(), ks.Key(BLOCK_NUMBER_PREFIX)+strings.ToLower(blockHash)).Result()
+	if err != nil {
 		return nil, err
 	}
 
-	return &block, nil
+	return GetBlockByNumber(rdb, ks, blockNumber)
 }
 
 // GetTransactionByHash retrieves a specific Ethereum transaction by its hash from Redis.
-// It takes a Redis client and a transaction hash as input, fetches the stored transaction data, and unmarshals it into a Transaction struct.
+// It takes a Redis client and a transaction hash as input, fetches the stored transaction data, and decodes it into a Transaction struct.
 // Returns a pointer to the Transaction struct or an error if any operation fails.
-func GetTransactionByHash(rdb *redis.Client, txHash string) (*types.Transaction, error) {
-	data, err := rdb.Get(context.Background(), TX_PREFIX+txHash).Result()
+func GetTransactionByHash(rdb redis.UniversalClient, ks Keyspace, txHash string) (*types.Transaction, error) {
+	data, err := rdb.Get(context.Background(), ks.Key(TX_PREFIX)+txHash).Result()
 	if err != nil {
 		return nil, err
 	}
 
-	var tx types.Transaction
-	if err := json.Unmarshal([]byte(data), &tx); err != nil {
+	return model.UnmarshalTx([]byte(data))
+}
+
+// GetBlockData retrieves a specific Ethereum block by its number from Redis, along with its transactions and events.
+// The transactions are taken from the block body, and the events are fetched with a single MGET of the event keys
+// listed in the block manifest. Returns redis.Nil if the block is not stored.
+func GetBlockData(ctx context.Context, rdb redis.UniversalClient, ks Keyspace, blockNumber string) (*model.Data, error) {
+	block, err := GetBlockByNumber(rdb, ks, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, _, err := blockManifest(ctx, rdb, ks, blockNumber)
+	if err != nil {
 		return nil, err
 	}
 
-	return &tx, nil
+	var eventKeys []string
+	for _, key := range keys {
+		if strings.HasPrefix(key, ks.Key(EVENT_PREFIX)) {
+			eventKeys = append(eventKeys, key)
+		}
+	}
+
+	var events []*types.Log
+	if len(eventKeys) > 0 {
+		values, err := rdb.MGet(ctx, eventKeys...).Result()
+		if err != nil {
+			return nil, fmt.Errorf("error fetching events of block %s from Redis: %v", blockNumber, err)
+		}
+
+		for _, value := range values {
+			eventJSON, ok := value.(string)
+			if !ok {
+				continue
+			}
+
+			event, err := model.UnmarshalLog([]byte(eventJSON))
+			if err != nil {
+				return nil, fmt.Errorf("error unmarshalling event: %v", err)
+			}
+			events = append(events, event)
+		}
+	}
+
+	return newBlockData(block, events), nil
 }
 
-// GetAllBlockNumbers: Retrieves all block numbers stored in Redis.
-// It takes a Redis client as input, constructs a Redis key pattern to fetch all block numbers,
-// and retrieves all keys matching the pattern from Redis.
-// Returns a slice of strings representing block numbers or an error if any operation fails.
-func GetAllBlockNumbers(rdb *redis.Client) ([]string, error) {
-	// Use the Redis wild card pattern
-	keyPattern := fmt.Sprint(BLOCK_PREFIX, "*")
+// GetAllBlockNumbers: Retrieves all block numbers stored in Redis, or those of a page.
+// It takes a Redis client as input and reads the blocks index, which holds the key of every stored block.
+// Returns a slice of strings representing block keys in ascending block number or an error if any operation fails.
+func GetAllBlockNumbers(rdb redis.UniversalClient, ks Keyspace, page Page) ([]string, error) {
+	return indexMembers(context.Background(), rdb, ks, ks.Key(BLOCKS_INDEX_KEY), page.minBlockScore(), page.Limit)
+}
+
+// EarliestBlock: Retrieves the lowest stored block number from the blocks index. Returns redis.Nil if no block is stored.
+func EarliestBlock(ctx context.Context, rdb redis.UniversalClient, ks Keyspace) (uint64, error) {
+	return blockIndexBound(ctx, rdb, ks, 0)
+}
 
-	// Get all keys matching the pattern from Redis
-	keys, err := rdb.Keys(context.Background(), keyPattern).Result()
+// LatestBlock: Retrieves the highest stored block number from the blocks index. Returns redis.Nil if no block is stored.
+func LatestBlock(ctx context.Context, rdb redis.UniversalClient, ks Keyspace) (uint64, error) {
+	return blockIndexBound(ctx, rdb, ks, -1)
+}
+
+// blockIndexBound prunes the expired index entries and returns the score of the blocks index entry at the given
+// rank, 0 for the lowest and -1 for the highest.
+func blockIndexBound(ctx context.Context, rdb redis.UniversalClient, ks Keyspace, rank int64) (uint64, error) {
+	if _, err := PruneIndexes(ctx, rdb, ks); err != nil {
+		return 0, err
+	}
+
+	entries, err := rdb.ZRangeWithScores(ctx, ks.Key(BLOCKS_INDEX_KEY), rank, rank).Result()
 	if err != nil {
-		return nil, fmt.Errorf("error fetching keys from Redis: %v", err)
+		return 0, fmt.Errorf("error fetching index %s from Redis: %v", ks.Key(BLOCKS_INDEX_KEY), err)
+	}
+	if len(entries) == 0 {
+		return 0, redis.Nil
 	}
 
-	return keys, nil
+	return uint64(entries[0].Score), nil
+}
+
+// indexMembers prunes the expired index entries and returns up to limit (0 for all) members of the index in
+// ascending score, from the min score (ZRANGEBYSCORE syntax) on.
+func indexMembers(ctx context.Context, rdb redis.UniversalClient, ks Keyspace, indexKey, min string, limit int) ([]string, error) {
+	if _, err := PruneIndexes(ctx, rdb, ks); err != nil {
+		return nil, err
+	}
+
+	members, err := rdb.ZRangeByScore(ctx, indexKey, &redis.ZRangeBy{Min: min, Max: "+inf", Count: int64(limit)}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error fetching index %s from Redis: %v", indexKey, err)
+	}
+
+	return members, nil
+}
+
+// GetBlockExpiry: Retrieves the time the keys of a block expire at, the zero time if they do not expire. The keys of
+// a block are written with the same TTL, so the TTL of the block key stands for all of them.
+func GetBlockExpiry(ctx context.Context, rdb redis.UniversalClient, ks Keyspace, blockNumber string) (time.Time, error) {
+	ttl, err := rdb.PTTL(ctx, ks.Key(BLOCK_PREFIX)+blockNumber).Result()
+	switch {
+	case err != nil:
+		return time.Time{}, err
+	case ttl == -2:
+		return time.Time{}, redis.Nil
+	case ttl < 0:
+		return time.Time{}, nil
+	}
+
+	return time.Now().Add(ttl), nil
 }
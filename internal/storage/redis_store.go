@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"ethereum-data-service/internal/config"
+	"ethereum-data-service/internal/model"
+	"fmt"
+	"strings"
+	"time"
+
+	eth_err "ethereum-data-service/pkg/err"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore is the Redis implementation of Store, built on the functions of this package.
+type RedisStore struct {
+	rdb             redis.UniversalClient
+	keys            Keyspace
+	codec           model.Codec
+	expiryTime      time.Duration
+	retentionBlocks int
+}
+
+// NewRedisStore returns a RedisStore configured from cfg.
+func NewRedisStore(rdb redis.UniversalClient, cfg *config.Config) *RedisStore {
+	return &RedisStore{
+		rdb:             rdb,
+		keys:            Keyspace(cfg.REDIS_HASH_TAG),
+		codec:           cfg.STORAGE_CODEC,
+		expiryTime:      cfg.REDIS_KEY_EXPIRY_TIME,
+		retentionBlocks: cfg.RETENTION_BLOCKS,
+	}
+}
+
+func (s *RedisStore) ApplyBlockData(ctx context.Context, blockData *model.Data) (ApplyResult, error) {
+	return ApplyBlockData(ctx, s.rdb, s.keys, blockData, s.codec, s.expiryTime, s.retentionBlocks)
+}
+
+func (s *RedisStore) ApplyBlockDataWithExpiry(ctx context.Context, blockData *model.Data, expiryTime time.Duration) (ApplyResult, error) {
+	return ApplyBlockData(ctx, s.rdb, s.keys, blockData, s.codec, expiryTime, s.retentionBlocks)
+}
+
+func (s *RedisStore) RemoveBlockData(ctx context.Context, blockNumber string) error {
+	return RemoveBlockData(ctx, s.rdb, s.keys, blockNumber)
+}
+
+func (s *RedisStore) IsBlockStored(ctx context.Context, blockNumber uint64) (bool, error) {
+	return IsBlockStored(ctx, s.rdb, s.keys, blockNumber)
+}
+
+func (s *RedisStore) GetEventsByAddress(ctx context.Context, address string, page Page) ([]*types.Log, error) {
+	return GetEventsByAddress(s.rdb, s.keys, address, page)
+}
+
+func (s *RedisStore) GetLogs(ctx context.Context, filter *LogFilter, page Page) ([]*types.Log, error) {
+	return GetLogs(ctx, s.rdb, s.keys, filter, page)
+}
+
+func (s *RedisStore) GetBlockByNumber(ctx context.Context, blockNumber string) (*model.Block, error) {
+	block, err := GetBlockByNumber(s.rdb, s.keys, blockNumber)
+	return block, notFound(err, "block %s", blockNumber)
+}
+
+func (s *RedisStore) GetBlockByHash(ctx context.Context, blockHash string) (*model.Block, error) {
+	block, err := GetBlockByHash(s.rdb, s.keys, blockHash)
+	return block, notFound(err, "block %s", blockHash)
+}
+
+func (s *RedisStore) GetTransactionByHash(ctx context.Context, txHash string) (*types.Transaction, error) {
+	tx, err := GetTransactionByHash(s.rdb, s.keys, txHash)
+	return tx, notFound(err, "transaction %s", txHash)
+}
+
+func (s *RedisStore) GetBlockData(ctx context.Context, blockNumber string) (*model.Data, error) {
+	blockData, err := GetBlockData(ctx, s.rdb, s.keys, blockNumber)
+	return blockData, notFound(err, "block %s", blockNumber)
+}
+
+func (s *RedisStore) GetBlockExpiry(ctx context.Context, blockNumber string) (time.Time, error) {
+	expiry, err := GetBlockExpiry(ctx, s.rdb, s.keys, blockNumber)
+	return expiry, notFound(err, "block %s", blockNumber)
+}
+
+// GetAllBlockNumbers lists the block keys without the hash tag of the store, which is a storage detail.
+func (s *RedisStore) GetAllBlockNumbers(ctx context.Context, page Page) ([]string, error) {
+	blocks, err := GetAllBlockNumbers(s.rdb, s.keys, page)
+	for i, block := range blocks {
+		blocks[i] = strings.TrimPrefix(block, string(s.keys))
+	}
+	return blocks, err
+}
+
+func (s *RedisStore) EarliestBlock(ctx context.Context) (uint64, error) {
+	number, err := EarliestBlock(ctx, s.rdb, s.keys)
+	return number, notFound(err, "no block stored")
+}
+
+func (s *RedisStore) LatestBlock(ctx context.Context) (uint64, error) {
+	number, err := LatestBlock(ctx, s.rdb, s.keys)
+	return number, notFound(err, "no block stored")
+}
+
+func (s *RedisStore) CheckSchema(ctx context.Context) error {
+	return CheckSchema(ctx, s.rdb, s.keys)
+}
+
+func (s *RedisStore) Migrate(ctx context.Context, opts MigrateOptions) (*MigrateResult, error) {
+	return Migrate(ctx, s.rdb, s.keys, s.codec, s.expiryTime, opts)
+}
+
+func (s *RedisStore) IncrStat(ctx context.Context, stat string) {
+	IncrStat(ctx, s.rdb, s.keys, stat)
+}
+
+func (s *RedisStore) GetStats(ctx context.Context) (map[string]int64, error) {
+	return GetStats(s.rdb, s.keys)
+}
+
+func (s *RedisStore) GetWatermark(ctx context.Context) (uint64, error) {
+	return GetWatermark(ctx, s.rdb, s.keys)
+}
+
+func (s *RedisStore) SetWatermark(ctx context.Context, blockNumber uint64) (uint64, error) {
+	return SetWatermark(ctx, s.rdb, s.keys, blockNumber)
+}
+
+// Close is a no-op, the Redis client is owned by the caller.
+func (s *RedisStore) Close() error {
+	return nil
+}
+
+// notFound translates a missing key into an error wrapping `ErrNotFound`.
+func notFound(err error, format string, args ...interface{}) error {
+	if errors.Is(err, redis.Nil) {
+		return fmt.Errorf("%s: %w", fmt.Sprintf(format, args...), eth_err.ErrNotFound)
+	}
+	return err
+}
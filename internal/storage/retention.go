@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// MANIFEST_PREFIX prefixes the per-block manifests: the set of every key written for a block, along with its
+// index entries (`<index key>|<member>`), so that the block can be evicted as a whole.
+var MANIFEST_PREFIX = "manifest:"
+
+// manifestKey returns the manifest key of the block with the given number.
+func (ks Keyspace) manifestKey(blockNumber *big.Int) string {
+	return fmt.Sprint(ks.Key(MANIFEST_PREFIX), blockNumber)
+}
+
+// retentionEvictions returns the keys and index entries of the stored blocks which fall out of the retention
+// window once the block with the given number is committed: with a window of w blocks, committing block N
+// evicts every block up to N-w, so that the w most recent blocks are kept. A window of 0 disables height-based
+// retention, leaving the keys to their TTL.
+func retentionEvictions(ctx context.Context, rdb redis.UniversalClient, ks Keyspace, blockNumber uint64, window int) ([]string, []string, error) {
+	if window <= 0 {
+		return nil, nil, nil
+	}
+
+	// Committing an older block (for ex. a backfilled one) must not move the window backwards
+	head := blockNumber
+	latest, err := rdb.ZRevRangeWithScores(ctx, ks.Key(BLOCKS_INDEX_KEY), 0, 0).Result()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error fetching latest stored block: %v", err)
+	}
+	if len(latest) > 0 && uint64(latest[0].Score) > head {
+		head = uint64(latest[0].Score)
+	}
+
+	if head < uint64(window) {
+		return nil, nil, nil
+	}
+	cutoff := head - uint64(window)
+
+	evicted, err := rdb.ZRangeByScore(ctx, ks.Key(BLOCKS_INDEX_KEY), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatUint(cutoff, 10),
+	}).Result()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error fetching blocks out of the retention window: %v", err)
+	}
+
+	var keys, entries []string
+	for _, blockKey := range evicted {
+		blockKeys, blockEntries, err := blockManifest(ctx, rdb, ks, strings.TrimPrefix(blockKey, ks.Key(BLOCK_PREFIX)))
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, blockKeys...)
+		entries = append(entries, blockEntries...)
+	}
+
+	return keys, entries, nil
+}
+
+// blockManifest returns the keys and index entries of the block with the given number, the manifest itself
+// included. Blocks stored before manifests existed have their keys looked up instead.
+func blockManifest(ctx context.Context, rdb redis.UniversalClient, ks Keyspace, blockNumber string) ([]string, []string, error) {
+	manifest := ks.Key(MANIFEST_PREFIX) + blockNumber
+	members, err := rdb.SMembers(ctx, manifest).Result()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error fetching manifest of block %s: %v", blockNumber, err)
+	}
+
+	if len(members) == 0 {
+		keys, err := blockDataKeys(ctx, rdb, ks, blockNumber)
+		if err != nil {
+			return nil, nil, err
+		}
+		return keys, []string{ks.Key(BLOCKS_INDEX_KEY) + indexEntrySeparator + ks.Key(BLOCK_PREFIX) + blockNumber}, nil
+	}
+
+	keys := []string{manifest}
+	var entries []string
+	for _, member := range members {
+		if strings.Contains(member, indexEntrySeparator) {
+			entries = append(entries, member)
+		} else {
+			keys = append(keys, member)
+		}
+	}
+
+	return keys, entries, nil
+}
+
+// queueRemoval queues the deletion of keys and the removal of index entries on pipe.
+func queueRemoval(ctx context.Context, pipe redis.Pipeliner, ks Keyspace, keys, entries []string) {
+	if len(keys) > 0 {
+		pipe.Del(ctx, keys...)
+	}
+
+	for _, entry := range entries {
+		indexKey, member, _ := strings.Cut(entry, indexEntrySeparator)
+		pipe.ZRem(ctx, indexKey, member)
+	}
+	if len(entries) > 0 {
+		pipe.ZRem(ctx, ks.Key(INDEX_EXPIRY_KEY), toInterfaces(entries)...)
+	}
+}
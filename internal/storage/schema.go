@@ -0,0 +1,427 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"ethereum-data-service/internal/model"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	eth_err "ethereum-data-service/pkg/err"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/redis/go-redis/v9"
+)
+
+// SCHEMA_VERSION_KEY holds the version of the key layout the data in Redis was written with.
+var SCHEMA_VERSION_KEY = "meta:schema_version"
+
+const (
+	// SCHEMA_VERSION is the version of the key layout written by this build. Bump it, and register the migration
+	// from the previous version in MIGRATIONS, whenever the layout changes.
+	SCHEMA_VERSION = 3
+	// migrateProgressInterval is the number of blocks between two progress reports of a migration.
+	migrateProgressInterval = 1000
+)
+
+// Schema versions:
+//
+//	0: values only (`block:`, `blockhash:`, `tx:`, `event:<address>_<block>_<tx hash>_<log index>`), looked up by
+//	   scanning the keyspace. It can only be upgraded by a rebuild.
+//	1: the blocks and address indexes (`idx:blocks`, `idx:addr:<address>`, `idx:expiry`) and the block manifests
+//	   (`manifest:<block>`), as written before the version was stored.
+//	2: the block numbers by block hash (`blocknumber:<hash>`).
+//	3: the topic indexes (`idx:topic:<position>:<topic>`).
+
+// Migration upgrades the data of a schema version to the next one, block by block.
+type Migration struct {
+	// Version is the schema version the migration upgrades to.
+	Version int
+	// Description tells what the migration adds to the layout.
+	Description string
+	// block queues the writes upgrading a stored block on pipe, the keys it adds expiring along with the block.
+	block func(ctx context.Context, rdb redis.UniversalClient, ks Keyspace, pipe redis.Pipeliner, blockNumber string, expiryTime time.Duration) error
+}
+
+// MIGRATIONS lists the migrations in version order.
+var MIGRATIONS = []Migration{
+	{Version: 2, Description: "index block numbers by block hash", block: migrateBlockNumbers},
+	{Version: 3, Description: "index events by topic", block: migrateTopicIndexes},
+}
+
+// MigrateOptions selects how Migrate upgrades the data.
+type MigrateOptions struct {
+	// DryRun reports what the migration would write without writing anything.
+	DryRun bool
+	// Rebuild rewrites every stored block from its values, with the current layout and codec, instead of applying
+	// the pending migrations. It is the only way up from version 0, and re-encodes the values after a codec change.
+	Rebuild bool
+}
+
+// MigrateResult reports what a migration did, or would do on a dry run.
+type MigrateResult struct {
+	From   int
+	To     int
+	Blocks int
+	Writes int
+}
+
+// GetSchemaVersion returns the schema version of the data in Redis. Data written before the version was stored is
+// version 1 when indexed and version 0 otherwise, and an empty keyspace has the version of this build.
+func GetSchemaVersion(ctx context.Context, rdb redis.UniversalClient, ks Keyspace) (int, error) {
+	version, err := rdb.Get(ctx, ks.Key(SCHEMA_VERSION_KEY)).Int()
+	if err == nil {
+		return version, nil
+	}
+	if !errors.Is(err, redis.Nil) {
+		return 0, fmt.Errorf("error fetching schema version from Redis: %v", err)
+	}
+
+	indexed, err := rdb.Exists(ctx, ks.Key(BLOCKS_INDEX_KEY)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("error fetching blocks index from Redis: %v", err)
+	}
+	if indexed > 0 {
+		return 1, nil
+	}
+
+	blockKeys, err := scanKeys(ctx, rdb, ks.Key(BLOCK_PREFIX)+"*", 1)
+	if err != nil {
+		return 0, err
+	}
+	if len(blockKeys) > 0 {
+		return 0, nil
+	}
+
+	return SCHEMA_VERSION, nil
+}
+
+// CheckSchema returns an error wrapping ErrIncompatibleSchema unless the data in Redis has the schema version of
+// this build. An empty keyspace is stamped with the version of this build.
+func CheckSchema(ctx context.Context, rdb redis.UniversalClient, ks Keyspace) error {
+	version, err := GetSchemaVersion(ctx, rdb, ks)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case version > SCHEMA_VERSION:
+		return fmt.Errorf("data has schema version %d, newer than version %d of this build: %w", version, SCHEMA_VERSION, eth_err.ErrIncompatibleSchema)
+	case version < SCHEMA_VERSION:
+		return fmt.Errorf("data has schema version %d, older than version %d of this build, run `migrate`: %w", version, SCHEMA_VERSION, eth_err.ErrIncompatibleSchema)
+	}
+
+	if err := rdb.SetNX(ctx, ks.Key(SCHEMA_VERSION_KEY), SCHEMA_VERSION, 0).Err(); err != nil {
+		return fmt.Errorf("error storing schema version in Redis: %v", err)
+	}
+	return nil
+}
+
+// Migrate upgrades the data in Redis to the schema version of this build, applying the pending migrations to every
+// stored block, or rebuilding every block. The version is only stored once every block is upgraded, and every
+// migration can be applied again, so an interrupted migration is resumed by running it again. The services must be
+// stopped while it runs.
+func Migrate(ctx context.Context, rdb redis.UniversalClient, ks Keyspace, codec model.Codec, expiryTime time.Duration, opts MigrateOptions) (*MigrateResult, error) {
+	from, err := GetSchemaVersion(ctx, rdb, ks)
+	if err != nil {
+		return nil, err
+	}
+	result := &MigrateResult{From: from, To: SCHEMA_VERSION}
+
+	switch {
+	case from > SCHEMA_VERSION:
+		return nil, fmt.Errorf("data has schema version %d, newer than version %d of this build: %w", from, SCHEMA_VERSION, eth_err.ErrIncompatibleSchema)
+	case opts.Rebuild:
+		err = rebuildBlocks(ctx, rdb, ks, codec, expiryTime, opts.DryRun, result)
+	case from == 0:
+		return nil, fmt.Errorf("data has schema version 0, which can only be upgraded by a rebuild: %w", eth_err.ErrIncompatibleSchema)
+	default:
+		err = migrateBlocks(ctx, rdb, ks, from, expiryTime, opts.DryRun, result)
+	}
+	if err != nil || opts.DryRun {
+		return result, err
+	}
+
+	if err := rdb.Set(ctx, ks.Key(SCHEMA_VERSION_KEY), SCHEMA_VERSION, 0).Err(); err != nil {
+		return result, fmt.Errorf("error storing schema version in Redis: %v", err)
+	}
+	return result, nil
+}
+
+// migrateBlocks applies the migrations after version from to every indexed block, queueing the writes of a block
+// in a single transaction.
+func migrateBlocks(ctx context.Context, rdb redis.UniversalClient, ks Keyspace, from int, expiryTime time.Duration, dryRun bool, result *MigrateResult) error {
+	var pending []Migration
+	for _, migration := range MIGRATIONS {
+		if migration.Version > from {
+			log.Printf("Migrating to schema version %d: %s\n", migration.Version, migration.Description)
+			pending = append(pending, migration)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	blockKeys, err := indexMembers(ctx, rdb, ks, ks.Key(BLOCKS_INDEX_KEY), "-inf", 0)
+	if err != nil {
+		return err
+	}
+
+	for i, blockKey := range blockKeys {
+		blockNumber := strings.TrimPrefix(blockKey, ks.Key(BLOCK_PREFIX))
+
+		pipe := rdb.TxPipeline()
+		for _, migration := range pending {
+			if err := migration.block(ctx, rdb, ks, pipe, blockNumber, expiryTime); err != nil {
+				pipe.Discard()
+				return fmt.Errorf("error migrating block %s to schema version %d: %v", blockNumber, migration.Version, err)
+			}
+		}
+
+		result.Blocks++
+		result.Writes += pipe.Len()
+		if dryRun {
+			pipe.Discard()
+		} else if _, err := pipe.Exec(ctx); err != nil {
+			return fmt.Errorf("error migrating block %s: %v", blockNumber, err)
+		}
+
+		if (i+1)%migrateProgressInterval == 0 || i+1 == len(blockKeys) {
+			log.Printf("Migrated %d/%d blocks\n", i+1, len(blockKeys))
+		}
+	}
+
+	return nil
+}
+
+// migrateBlockNumbers stores the number of a block under its hash.
+func migrateBlockNumbers(ctx context.Context, rdb redis.UniversalClient, ks Keyspace, pipe redis.Pipeliner, blockNumber string, expiryTime time.Duration) error {
+	hash, err := rdb.Get(ctx, ks.Key(BLOCK_HASH_PREFIX)+blockNumber).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	ttl, err := remainingTTL(ctx, rdb, ks.Key(BLOCK_PREFIX)+blockNumber, expiryTime)
+	if err != nil || ttl == 0 {
+		return err
+	}
+
+	numberKey := ks.Key(BLOCK_NUMBER_PREFIX) + strings.ToLower(hash)
+	pipe.Set(ctx, numberKey, blockNumber, ttl)
+	pipe.SAdd(ctx, ks.Key(MANIFEST_PREFIX)+blockNumber, numberKey)
+	return nil
+}
+
+// migrateTopicIndexes adds the events of a block to the indexes of their topics.
+func migrateTopicIndexes(ctx context.Context, rdb redis.UniversalClient, ks Keyspace, pipe redis.Pipeliner, blockNumber string, expiryTime time.Duration) error {
+	keys, _, err := blockManifest(ctx, rdb, ks, blockNumber)
+	if err != nil {
+		return err
+	}
+
+	var eventKeys []string
+	for _, key := range keys {
+		if strings.HasPrefix(key, ks.Key(EVENT_PREFIX)) {
+			eventKeys = append(eventKeys, key)
+		}
+	}
+	if len(eventKeys) == 0 {
+		return nil
+	}
+
+	ttl, err := remainingTTL(ctx, rdb, ks.Key(BLOCK_PREFIX)+blockNumber, expiryTime)
+	if err != nil || ttl == 0 {
+		return err
+	}
+
+	values, err := rdb.MGet(ctx, eventKeys...).Result()
+	if err != nil {
+		return err
+	}
+
+	manifest := ks.Key(MANIFEST_PREFIX) + blockNumber
+	for i, value := range values {
+		value, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		event, err := model.UnmarshalLog([]byte(value))
+		if err != nil {
+			return fmt.Errorf("error unmarshalling event: %v", err)
+		}
+		score := eventScore(event.BlockNumber, event.Index)
+		for position, topic := range event.Topics {
+			if position < MAX_TOPICS {
+				addToIndex(ctx, pipe, ks, manifest, ks.topicIndexKey(position, strings.ToLower(topic.Hex())), eventKeys[i], score, ttl)
+			}
+		}
+	}
+
+	return nil
+}
+
+// remainingTTL returns the time left before a key expires, expiryTime for a key without TTL, and 0 for a key
+// which no longer exists.
+func remainingTTL(ctx context.Context, rdb redis.UniversalClient, key string, expiryTime time.Duration) (time.Duration, error) {
+	ttl, err := rdb.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("error fetching TTL of %s: %v", key, err)
+	}
+
+	switch {
+	case ttl == -1:
+		return expiryTime, nil
+	case ttl < 0:
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// rebuildBlocks stores every block again from its values, found by scanning the keyspace, with the current layout
+// and codec. The keys rebuilt get a fresh TTL.
+func rebuildBlocks(ctx context.Context, rdb redis.UniversalClient, ks Keyspace, codec model.Codec, expiryTime time.Duration, dryRun bool, result *MigrateResult) error {
+	blockKeys, err := scanKeys(ctx, rdb, ks.Key(BLOCK_PREFIX)+"*", 0)
+	if err != nil {
+		return err
+	}
+
+	// Event keys end with `_<block>_<tx hash>_<log index>`
+	eventKeys, err := scanKeys(ctx, rdb, ks.Key(EVENT_PREFIX)+"*", 0)
+	if err != nil {
+		return err
+	}
+	blockEvents := make(map[string][]string)
+	for _, key := range eventKeys {
+		parts := strings.Split(key, "_")
+		if len(parts) >= 4 {
+			blockNumber := parts[len(parts)-3]
+			blockEvents[blockNumber] = append(blockEvents[blockNumber], key)
+		}
+	}
+
+	var numbers []uint64
+	for _, key := range blockKeys {
+		if number, err := strconv.ParseUint(strings.TrimPrefix(key, ks.Key(BLOCK_PREFIX)), 10, 64); err == nil {
+			numbers = append(numbers, number)
+		}
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	log.Printf("Rebuilding %d blocks with schema version %d\n", len(numbers), SCHEMA_VERSION)
+	for i, number := range numbers {
+		blockNumber := strconv.FormatUint(number, 10)
+		blockData, err := rebuiltBlockData(ctx, rdb, ks, blockNumber, blockEvents[blockNumber])
+		if err != nil {
+			return fmt.Errorf("error rebuilding block %s: %v", blockNumber, err)
+		}
+		if blockData == nil {
+			continue
+		}
+
+		result.Blocks++
+		result.Writes += 3 + len(blockData.TransactionHashes) + len(blockEvents[blockNumber])
+		if !dryRun {
+			if err := AddBlockDataToDB(ctx, rdb, ks, blockData, codec, expiryTime, 0); err != nil {
+				return err
+			}
+		}
+
+		if (i+1)%migrateProgressInterval == 0 || i+1 == len(numbers) {
+			log.Printf("Rebuilt %d/%d blocks\n", i+1, len(numbers))
+		}
+	}
+
+	return nil
+}
+
+// rebuiltBlockData reads a block and its events back from their values, nil if the block expired meanwhile.
+func rebuiltBlockData(ctx context.Context, rdb redis.UniversalClient, ks Keyspace, blockNumber string, eventKeys []string) (*model.Data, error) {
+	blockValue, err := rdb.Get(ctx, ks.Key(BLOCK_PREFIX)+blockNumber).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := model.UnmarshalBlock(blockValue)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling block: %v", err)
+	}
+
+	events := make([]*types.Log, 0, len(eventKeys))
+	if len(eventKeys) > 0 {
+		values, err := rdb.MGet(ctx, eventKeys...).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range values {
+			value, ok := value.(string)
+			if !ok {
+				continue
+			}
+			event, err := model.UnmarshalLog([]byte(value))
+			if err != nil {
+				return nil, fmt.Errorf("error unmarshalling event: %v", err)
+			}
+			events = append(events, event)
+		}
+	}
+
+	return newBlockData(block, events), nil
+}
+
+// scanKeys returns the keys matching pattern, iterating with SCAN rather than KEYS so that Redis keeps serving
+// in between, up to limit keys (0 for all). On Redis Cluster, every master is scanned.
+func scanKeys(ctx context.Context, rdb redis.UniversalClient, pattern string, limit int) ([]string, error) {
+	cluster, ok := rdb.(*redis.ClusterClient)
+	if !ok {
+		return scanNodeKeys(ctx, rdb, pattern, limit)
+	}
+
+	var (
+		mu   sync.Mutex
+		keys []string
+	)
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+		nodeKeys, err := scanNodeKeys(ctx, node, pattern, limit)
+		mu.Lock()
+		keys = append(keys, nodeKeys...)
+		mu.Unlock()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+
+	return keys, nil
+}
+
+// scanNodeKeys scans the keyspace of a single Redis node for scanKeys.
+func scanNodeKeys(ctx context.Context, rdb redis.Cmdable, pattern string, limit int) ([]string, error) {
+	var keys []string
+	iter := rdb.Scan(ctx, 0, pattern, 1000).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning keys %s: %v", pattern, err)
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"ethereum-data-service/internal/config"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	eth_err "ethereum-data-service/pkg/err"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestRedisStore returns a RedisStore backed by an in-process Redis, along with its client.
+func newTestRedisStore(t *testing.T) (*RedisStore, *redis.Client) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	memory, _ := newTestMemoryStore(t, 0, time.Hour)
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	return NewRedisStore(rdb, &config.Config{STORAGE_CODEC: memory.codec, REDIS_KEY_EXPIRY_TIME: time.Hour}), rdb
+}
+
+// downgrade turns the layout of the stored blocks into the given schema version, 0 or 1.
+func downgrade(t *testing.T, rdb *redis.Client, version int) {
+	t.Helper()
+	ctx := context.Background()
+
+	patterns := []string{SCHEMA_VERSION_KEY, BLOCK_NUMBER_PREFIX + "*", TOPIC_INDEX_PREFIX + "*"}
+	if version == 0 {
+		patterns = append(patterns, "idx:*", MANIFEST_PREFIX+"*")
+	}
+	for _, pattern := range patterns {
+		keys, err := scanKeys(ctx, rdb, pattern, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) > 0 {
+			rdb.Del(ctx, keys...)
+		}
+	}
+
+	// The manifests of version 1 only list the keys and the address and blocks index entries
+	manifests, _ := scanKeys(ctx, rdb, MANIFEST_PREFIX+"*", 0)
+	for _, manifest := range manifests {
+		for _, member := range rdb.SMembers(ctx, manifest).Val() {
+			if strings.HasPrefix(member, BLOCK_NUMBER_PREFIX) || strings.HasPrefix(member, TOPIC_INDEX_PREFIX) {
+				rdb.SRem(ctx, manifest, member)
+			}
+		}
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	ctx := context.Background()
+	s, rdb := newTestRedisStore(t)
+
+	// An empty keyspace is stamped with the version of this build
+	if err := s.CheckSchema(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := rdb.Get(ctx, SCHEMA_VERSION_KEY).Int(); version != SCHEMA_VERSION {
+		t.Errorf("stamped schema version %d, want %d", version, SCHEMA_VERSION)
+	}
+
+	rdb.Set(ctx, SCHEMA_VERSION_KEY, SCHEMA_VERSION+1, 0)
+	if err := s.CheckSchema(ctx); !errors.Is(err, eth_err.ErrIncompatibleSchema) {
+		t.Errorf("CheckSchema of a newer version: got error %v, want ErrIncompatibleSchema", err)
+	}
+	if _, err := s.Migrate(ctx, MigrateOptions{}); !errors.Is(err, eth_err.ErrIncompatibleSchema) {
+		t.Errorf("Migrate of a newer version: got error %v, want ErrIncompatibleSchema", err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	s, rdb := newTestRedisStore(t)
+
+	var hashes []string
+	for n := int64(1); n <= 3; n++ {
+		blockData := testBlockData(n, 3, 2)
+		mustApply(t, s, blockData, APPLIED)
+		hashes = append(hashes, blockData.Block.Header.Hash().Hex())
+	}
+	topic := strings.ToLower(common.BigToHash(big.NewInt(1)).Hex())
+
+	check := func(step string) {
+		t.Helper()
+		if err := s.CheckSchema(ctx); err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+		if _, err := s.GetBlockByHash(ctx, hashes[1]); err != nil {
+			t.Errorf("%s: GetBlockByHash: %v", step, err)
+		}
+		events, err := s.GetLogs(ctx, &LogFilter{FromBlock: 1, ToBlock: 3, Topics: [][]string{{topic}}}, Page{})
+		if err != nil || len(events) != 9 {
+			t.Errorf("%s: got %d events of a topic (%v), want 9", step, len(events), err)
+		}
+	}
+
+	downgrade(t, rdb, 1)
+	if version, _ := GetSchemaVersion(ctx, rdb, ""); version != 1 {
+		t.Fatalf("schema version of unversioned indexed data = %d, want 1", version)
+	}
+	if err := s.CheckSchema(ctx); !errors.Is(err, eth_err.ErrIncompatibleSchema) {
+		t.Errorf("CheckSchema of an older version: got error %v, want ErrIncompatibleSchema", err)
+	}
+
+	// A dry run reports the writes of every block without writing any
+	keys := rdb.DBSize(ctx).Val()
+	result, err := s.Migrate(ctx, MigrateOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.From != 1 || result.To != SCHEMA_VERSION || result.Blocks != 3 || result.Writes == 0 {
+		t.Errorf("dry run result = %+v", result)
+	}
+	if got := rdb.DBSize(ctx).Val(); got != keys {
+		t.Errorf("dry run changed the number of keys from %d to %d", keys, got)
+	}
+
+	if _, err := s.Migrate(ctx, MigrateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	check("migrated")
+
+	// Version 0 can only be rebuilt
+	downgrade(t, rdb, 0)
+	if version, _ := GetSchemaVersion(ctx, rdb, ""); version != 0 {
+		t.Fatalf("schema version of unindexed data = %d, want 0", version)
+	}
+	if _, err := s.Migrate(ctx, MigrateOptions{}); !errors.Is(err, eth_err.ErrIncompatibleSchema) {
+		t.Errorf("Migrate of version 0: got error %v, want ErrIncompatibleSchema", err)
+	}
+	if result, err := s.Migrate(ctx, MigrateOptions{Rebuild: true}); err != nil || result.Blocks != 3 {
+		t.Fatalf("rebuild = %+v, %v", result, err)
+	}
+	check("rebuilt")
+	if blocks := blockKeys(t, s); len(blocks) != 3 {
+		t.Errorf("got %d blocks after the rebuild, want 3", len(blocks))
+	}
+}
+
+func TestKeyHashTag(t *testing.T) {
+	ctx := context.Background()
+	untagged, rdb := newTestRedisStore(t)
+	// A second store with its own hash tag shares the Redis of the first one
+	s := NewRedisStore(rdb, &config.Config{STORAGE_CODEC: untagged.codec, REDIS_KEY_EXPIRY_TIME: time.Hour, REDIS_HASH_TAG: "{eth}"})
+	if err := s.CheckSchema(ctx); err != nil {
+		t.Fatal(err)
+	}
+	blockData := testBlockData(5, 2, 2)
+	mustApply(t, s, blockData, APPLIED)
+
+	// Every key lands in the slot of the hash tag
+	for _, key := range rdb.Keys(ctx, "*").Val() {
+		if !strings.HasPrefix(key, "{eth}") {
+			t.Errorf("key %s has no hash tag", key)
+		}
+	}
+	if blocks := blockKeys(t, s); len(blocks) != 1 || blocks[0] != "block:5" {
+		t.Errorf("got blocks %v, want [block:5]", blocks)
+	}
+	if _, err := s.GetBlockByHash(ctx, blockData.Block.Header.Hash().Hex()); err != nil {
+		t.Errorf("GetBlockByHash: %v", err)
+	}
+	events, err := s.GetEventsByAddress(ctx, testAddress(0), Page{})
+	if err != nil || len(events) != 2 {
+		t.Errorf("got %d events of an address (%v), want 2", len(events), err)
+	}
+
+	// The keyspaces of the two stores do not overlap
+	if blocks := blockKeys(t, untagged); len(blocks) != 0 {
+		t.Errorf("got blocks %v in the untagged store, want none", blocks)
+	}
+	mustApply(t, untagged, blockData, APPLIED)
+	if _, err := untagged.GetBlockByHash(ctx, blockData.Block.Header.Hash().Hex()); err != nil {
+		t.Errorf("GetBlockByHash of the untagged store: %v", err)
+	}
+}
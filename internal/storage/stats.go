@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// STATS_KEY is the Redis hash holding the ingestion counters.
+var STATS_KEY = "stats:sub"
+
+const (
+	// STAT_APPLIED counts the blocks stored.
+	STAT_APPLIED = "applied"
+	// STAT_DUPLICATES counts the blocks skipped because they were already stored.
+	STAT_DUPLICATES = "duplicates"
+	// STAT_CONFLICTS counts the blocks which replaced a stored block with the same number but a different hash.
+	STAT_CONFLICTS = "conflicts"
+	// STAT_BACKFILLED counts the missing blocks the subscriber fetched from the node to close a gap.
+	STAT_BACKFILLED = "backfilled"
+)
+
+// IncrStat increments an ingestion counter. Failures are only logged since counters are informational.
+func IncrStat(ctx context.Context, rdb redis.UniversalClient, ks Keyspace, stat string) {
+	if err := rdb.HIncrBy(ctx, ks.Key(STATS_KEY), stat, 1).Err(); err != nil {
+		log.Printf("error incrementing %s counter: %v\n", stat, err)
+	}
+}
+
+// GetStats returns all ingestion counters.
+func GetStats(rdb redis.UniversalClient, ks Keyspace) (map[string]int64, error) {
+	values, err := rdb.HGetAll(context.Background(), ks.Key(STATS_KEY)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := map[string]int64{STAT_APPLIED: 0, STAT_DUPLICATES: 0, STAT_CONFLICTS: 0, STAT_BACKFILLED: 0}
+	for stat, value := range values {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		stats[stat] = n
+	}
+
+	return stats, nil
+}
@@ -2,8 +2,8 @@ package storage
 
 import (
 	"context"
-	"encoding/json"
 	"ethereum-data-service/internal/model"
+	"fmt"
 	"log"
 	"time"
 
@@ -11,32 +11,63 @@ import (
 )
 
 var (
-	BLOCK_PREFIX string = "block:"
-	TX_PREFIX    string = "tx:"
-	EVENT_PREFIX string = "event:"
+	BLOCK_PREFIX        string = "block:"
+	BLOCK_HASH_PREFIX   string = "blockhash:"
+	BLOCK_NUMBER_PREFIX string = "blocknumber:"
+	TX_PREFIX           string = "tx:"
+	EVENT_PREFIX        string = "event:"
 )
 
-func AddBlockDataToDB(ctx context.Context, rdb *redis.Client, payload []byte, expiryTime time.Duration) error {
-	var blockData model.Data
+// Keyspace is the Redis Cluster hash tag (`{...}`) prefixing every key of the layout of a Redis store, empty by
+// default. Redis Cluster hashes only the tag of such keys, so that they all land in the same slot and the writes of
+// a block can still be a single MULTI/EXEC transaction. The store carries it and the functions of this package
+// build every key with it, so that stores with different tags can live in the same process.
+type Keyspace string
 
-	// Deserialize the block data
-	err := json.Unmarshal(payload, &blockData)
+// Key returns the key, or key prefix, of the layout with the given name within the keyspace.
+func (ks Keyspace) Key(name string) string {
+	return string(ks) + name
+}
+
+// AddBlockDataToDB: Indexes the block, its transactions and its events and stores them in Redis,
+// each value being serialized with the given codec. All the writes of a block are sent as a single
+// pipelined MULTI/EXEC transaction, so readers see the block either entirely or not at all. The blocks
+// which fall out of the window of the `retentionBlocks` most recent blocks are evicted in the same
+// transaction, the TTL only being a safety net.
+func AddBlockDataToDB(ctx context.Context, rdb redis.UniversalClient, ks Keyspace, blockData *model.Data, codec model.Codec, expiryTime time.Duration, retentionBlocks int) error {
+	return replaceBlockData(ctx, rdb, ks, nil, nil, blockData, codec, expiryTime, retentionBlocks)
+}
+
+// replaceBlockData deletes the stale keys and index entries and stores the block data within the same MULTI/EXEC
+// transaction, evicting the blocks which fall out of the retention window.
+func replaceBlockData(ctx context.Context, rdb redis.UniversalClient, ks Keyspace, staleKeys, staleEntries []string, blockData *model.Data, codec model.Codec, expiryTime time.Duration, retentionBlocks int) error {
+	evictedKeys, evictedEntries, err := retentionEvictions(ctx, rdb, ks, blockData.Block.Header.Number.Uint64(), retentionBlocks)
 	if err != nil {
 		return err
 	}
 
-	if err := IdxBlockAndStore(ctx, rdb, &blockData, expiryTime); err != nil {
-		return err
-	}
+	_, err = rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		queueRemoval(ctx, pipe, ks, append(staleKeys, evictedKeys...), append(staleEntries, evictedEntries...))
 
-	if err := IdxTxAndStore(ctx, rdb, &blockData, expiryTime); err != nil {
-		return err
-	}
+		if err := IdxBlockAndStore(ctx, pipe, ks, blockData, codec, expiryTime); err != nil {
+			return err
+		}
 
-	if err := IdxEventsAndStore(ctx, rdb, &blockData, expiryTime); err != nil {
-		return err
+		if err := IdxTxAndStore(ctx, pipe, ks, blockData, codec, expiryTime); err != nil {
+			return err
+		}
+
+		return IdxEventsAndStore(ctx, pipe, ks, blockData, codec, expiryTime)
+	})
+	if err != nil {
+		return fmt.Errorf("error storing block %d in Redis: %v", blockData.Block.Header.Number, err)
 	}
 
 	log.Printf("Stored block %d in Redis\n", blockData.Block.Header.Number)
+
+	// Index entries pointing to expired keys are only informational, a failure to prune them is not fatal
+	if _, err := PruneIndexes(ctx, rdb, ks); err != nil {
+		log.Printf("%v\n", err)
+	}
 	return nil
 }
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"context"
+	"ethereum-data-service/internal/model"
+	"ethereum-data-service/internal/testutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	benchTxs         = 200
+	benchLogsPerTx   = 4
+	benchKeyExpiry   = 10 * time.Minute
+	benchRedisEnvVar = "BENCH_REDIS_ADDR"
+)
+
+// BenchmarkAddBlockDataToDB compares storing a block with 200 transactions and 800 logs as one pipelined
+// MULTI/EXEC transaction against the previous one-round-trip-per-command writes. Both sides send the same
+// commands (every SET, ZADD, SADD and EXPIRE queued by the Idx* functions) and leave out retention and index
+// pruning, so that only the round trips differ. It runs against an in-process Redis by default, where a round
+// trip costs next to nothing; set `BENCH_REDIS_ADDR` to measure against a real server:
+//
+//	BENCH_REDIS_ADDR=localhost:6379 go test -run=^$ -bench=AddBlockDataToDB ./internal/storage/
+func BenchmarkAddBlockDataToDB(b *testing.B) {
+	rdb := benchRedis(b)
+	blockData := benchBlockData(20000000)
+	codec := model.Codec{Encoding: model.ENCODING_JSON, Compression: model.COMPRESSION_NONE}
+	ctx := context.Background()
+
+	b.Run("pipelined", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				return indexBlockData(ctx, pipe, blockData, codec)
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "blocks/s")
+	})
+
+	b.Run("per-command", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			pipe := &roundTripper{rdb: rdb}
+			if err := indexBlockData(ctx, pipe, blockData, codec); err != nil {
+				b.Fatal(err)
+			}
+			if pipe.err != nil {
+				b.Fatal(pipe.err)
+			}
+		}
+		b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "blocks/s")
+	})
+}
+
+// indexBlockData queues the writes of the block data on pipe, as AddBlockDataToDB does.
+func indexBlockData(ctx context.Context, pipe IndexWriter, blockData *model.Data, codec model.Codec) error {
+	if err := IdxBlockAndStore(ctx, pipe, "", blockData, codec, benchKeyExpiry); err != nil {
+		return err
+	}
+	if err := IdxTxAndStore(ctx, pipe, "", blockData, codec, benchKeyExpiry); err != nil {
+		return err
+	}
+	return IdxEventsAndStore(ctx, pipe, "", blockData, codec, benchKeyExpiry)
+}
+
+// roundTripper sends every command the Idx* functions queue right away, in its own round trip, as the writes
+// were sent before they were pipelined. It keeps the first error.
+type roundTripper struct {
+	rdb *redis.Client
+	err error
+}
+
+func (r *roundTripper) keep(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *roundTripper) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	cmd := r.rdb.Set(ctx, key, value, expiration)
+	r.keep(cmd.Err())
+	return cmd
+}
+
+func (r *roundTripper) Expire(ctx context.Context, key string, expiration time.Duration) *redis.BoolCmd {
+	cmd := r.rdb.Expire(ctx, key, expiration)
+	r.keep(cmd.Err())
+	return cmd
+}
+
+func (r *roundTripper) SAdd(ctx context.Context, key string, members ...interface{}) *redis.IntCmd {
+	cmd := r.rdb.SAdd(ctx, key, members...)
+	r.keep(cmd.Err())
+	return cmd
+}
+
+func (r *roundTripper) ZAdd(ctx context.Context, key string, members ...redis.Z) *redis.IntCmd {
+	cmd := r.rdb.ZAdd(ctx, key, members...)
+	r.keep(cmd.Err())
+	return cmd
+}
+
+func benchRedis(b *testing.B) *redis.Client {
+	addr := os.Getenv(benchRedisEnvVar)
+	if addr == "" {
+		addr = miniredis.RunT(b).Addr()
+	}
+
+	rdb := redis.NewClient(&redis.Options{Addr: addr})
+	b.Cleanup(func() { rdb.Close() })
+
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		b.Skipf("redis at %s unavailable: %v", addr, err)
+	}
+	return rdb
+}
+
+// benchBlockData builds a block with benchTxs transactions, each emitting benchLogsPerTx logs.
+func benchBlockData(number int64) *model.Data {
+	return testBlockData(number, benchTxs, benchLogsPerTx)
+}
+
+// testBlockData builds a block mined now, as per testutil.BlockData.
+func testBlockData(number int64, txCount, logsPerTx int) *model.Data {
+	return testutil.BlockData(number, time.Now(), txCount, logsPerTx)
+}
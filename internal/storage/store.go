@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"ethereum-data-service/internal/config"
+	"ethereum-data-service/internal/model"
+	"ethereum-data-service/pkg/enum"
+	"sort"
+	"time"
+
+	eth_err "ethereum-data-service/pkg/err"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/redis/go-redis/v9"
+)
+
+// Store persists block data and serves every query of the API. Lookups of missing data return an error
+// wrapping `ErrNotFound`.
+type Store interface {
+	// ApplyBlockData stores the block data unless the very same block is already stored, replacing a stored
+	// block with the same number but a different hash, and evicts the blocks falling out of the retention window.
+	ApplyBlockData(ctx context.Context, blockData *model.Data) (ApplyResult, error)
+	// RemoveBlockData deletes the block with the given number along with its transactions and events.
+	RemoveBlockData(ctx context.Context, blockNumber string) error
+	// IsBlockStored reports whether a block with the given number is stored.
+	IsBlockStored(ctx context.Context, blockNumber uint64) (bool, error)
+
+	// GetEventsByAddress returns a page of the events emitted by an address (lower case), ordered by block number
+	// and log index.
+	GetEventsByAddress(ctx context.Context, address string, page Page) ([]*types.Log, error)
+	// GetLogs returns a page of the events matching the filter, ordered by block number and log index.
+	GetLogs(ctx context.Context, filter *LogFilter, page Page) ([]*types.Log, error)
+	// GetBlockByNumber returns the block with the given number.
+	GetBlockByNumber(ctx context.Context, blockNumber string) (*model.Block, error)
+	// GetBlockByHash returns the block with the given hash.
+	GetBlockByHash(ctx context.Context, blockHash string) (*model.Block, error)
+	// GetTransactionByHash returns the transaction with the given hash.
+	GetTransactionByHash(ctx context.Context, txHash string) (*types.Transaction, error)
+	// GetBlockData returns the block with the given number along with its transactions and events, as applied.
+	GetBlockData(ctx context.Context, blockNumber string) (*model.Data, error)
+	// GetAllBlockNumbers returns the keys (`block:<number>`) of a page of the stored blocks in ascending block
+	// number, the cursor only using the block number.
+	GetAllBlockNumbers(ctx context.Context, page Page) ([]string, error)
+	// EarliestBlock returns the lowest stored block number, an error wrapping `ErrNotFound` if no block is stored.
+	EarliestBlock(ctx context.Context) (uint64, error)
+	// LatestBlock returns the highest stored block number, an error wrapping `ErrNotFound` if no block is stored.
+	LatestBlock(ctx context.Context) (uint64, error)
+
+	// IncrStat increments an ingestion counter, logging rather than returning failures.
+	IncrStat(ctx context.Context, stat string)
+	// GetStats returns the ingestion counters.
+	GetStats(ctx context.Context) (map[string]int64, error)
+	// GetWatermark returns the highest block number up to which every block is stored, 0 if unknown.
+	GetWatermark(ctx context.Context) (uint64, error)
+	// SetWatermark raises the watermark to blockNumber and returns the resulting, possibly higher, watermark.
+	SetWatermark(ctx context.Context, blockNumber uint64) (uint64, error)
+
+	// Close releases the resources held by the store.
+	Close() error
+}
+
+// Versioned is implemented by the stores whose key layout carries a schema version (see `schema.go`). PostgreSQL
+// applies its own migrations when opened, while the embedded and in-memory stores are not versioned.
+type Versioned interface {
+	// CheckSchema returns an error wrapping `ErrIncompatibleSchema` unless the stored data has the schema version
+	// of this build.
+	CheckSchema(ctx context.Context) error
+	// Migrate upgrades the stored data to the schema version of this build.
+	Migrate(ctx context.Context, opts MigrateOptions) (*MigrateResult, error)
+}
+
+// Expiring is implemented by the stores whose keys expire after `REDIS_KEY_EXPIRY_TIME`, which lets a snapshot
+// carry the expiry of every block over to the store it is imported into.
+type Expiring interface {
+	// GetBlockExpiry returns the time the keys of the block with the given number expire at, the zero time if they
+	// do not expire.
+	GetBlockExpiry(ctx context.Context, blockNumber string) (time.Time, error)
+	// ApplyBlockDataWithExpiry stores the block data as ApplyBlockData does, with keys expiring after expiryTime.
+	ApplyBlockDataWithExpiry(ctx context.Context, blockData *model.Data, expiryTime time.Duration) (ApplyResult, error)
+}
+
+// DeadLetters is implemented by the stores which can keep the dead-letter queue themselves, for the deployments
+// running without Redis. Entries are opaque values under IDs drawn from NextDeadLetterID.
+type DeadLetters interface {
+	// NextDeadLetterID returns a new entry ID, greater than all the previous ones.
+	NextDeadLetterID(ctx context.Context) (int64, error)
+	// PutDeadLetter stores an entry under its ID, overwriting any previous version of it.
+	PutDeadLetter(ctx context.Context, id string, entry []byte) error
+	// GetDeadLetter returns the entry with the given ID, eth_err.ErrNotFound if there is none.
+	GetDeadLetter(ctx context.Context, id string) ([]byte, error)
+	// ListDeadLetters returns all entries by ID.
+	ListDeadLetters(ctx context.Context) (map[string][]byte, error)
+	// RemoveDeadLetter deletes the entry with the given ID, eth_err.ErrNotFound if there is none.
+	RemoveDeadLetter(ctx context.Context, id string) error
+	// PurgeDeadLetters deletes all entries and returns how many there were.
+	PurgeDeadLetters(ctx context.Context) (int64, error)
+}
+
+// newBlockData assembles the block data of a stored block and its events.
+func newBlockData(block *model.Block, events []*types.Log) *model.Data {
+	blockData := &model.Data{
+		Block:             *block,
+		TransactionHashes: make(map[string]*types.Transaction),
+		Events:            make(map[string][]*types.Log),
+	}
+
+	if block.Body != nil {
+		for _, tx := range block.Body.Transactions {
+			blockData.TransactionHashes[tx.Hash().Hex()] = tx
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool { return events[i].Index < events[j].Index })
+	for _, event := range events {
+		blockData.Events[event.TxHash.Hex()] = append(blockData.Events[event.TxHash.Hex()], event)
+	}
+
+	return blockData
+}
+
+// New returns the store selected by `STORAGE_BACKEND` in the config.
+func New(ctx context.Context, cfg *config.Config, rdb redis.UniversalClient) (Store, error) {
+	switch enum.StorageBackend(cfg.STORAGE_BACKEND) {
+	case enum.REDIS:
+		return NewRedisStore(rdb, cfg), nil
+	case enum.POSTGRES:
+		store, err := OpenPostgresStore(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	case enum.BOLT:
+		store, err := OpenBoltStore(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	case enum.IN_MEMORY:
+		return NewMemoryStore(cfg), nil
+	default:
+		return nil, eth_err.ErrInvalidStorageBackend
+	}
+}
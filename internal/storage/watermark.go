@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// WATERMARK_KEY holds the highest block number up to which every block has been stored without a gap.
+var WATERMARK_KEY = "meta:watermark"
+
+// raiseWatermark sets the watermark only when the new value is higher, so that subscribers racing each
+// other (or a subscriber restarting from an older state) never move it backwards.
+var raiseWatermark = redis.NewScript(`
+local current = tonumber(redis.call("GET", KEYS[1]) or "0")
+local new = tonumber(ARGV[1])
+if new > current then
+	redis.call("SET", KEYS[1], ARGV[1])
+	return new
+end
+return current
+`)
+
+// SetWatermark raises the contiguous watermark to blockNumber and returns the resulting watermark, which is
+// higher than blockNumber if another subscriber has already moved it further.
+func SetWatermark(ctx context.Context, rdb redis.UniversalClient, ks Keyspace, blockNumber uint64) (uint64, error) {
+	n, err := raiseWatermark.Run(ctx, rdb, []string{ks.Key(WATERMARK_KEY)}, blockNumber).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return uint64(n), nil
+}
+
+// GetWatermark returns the contiguous watermark, or 0 if no block has been sequenced yet.
+func GetWatermark(ctx context.Context, rdb redis.UniversalClient, ks Keyspace) (uint64, error) {
+	value, err := rdb.Get(ctx, ks.Key(WATERMARK_KEY)).Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// IsBlockStored reports whether a block with the given number is currently stored.
+func IsBlockStored(ctx context.Context, rdb redis.UniversalClient, ks Keyspace, blockNumber uint64) (bool, error) {
+	n, err := rdb.Exists(ctx, ks.Key(BLOCK_HASH_PREFIX)+strconv.FormatUint(blockNumber, 10)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,54 @@
+// Package testutil holds the fixtures shared by the tests of several packages.
+package testutil
+
+import (
+	"ethereum-data-service/internal/model"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// BlockData builds a block mined at the given time with the given number of transactions, each emitting logsPerTx
+// logs. The i-th transaction is sent to, and its logs emitted by, the address i, and the j-th log of a transaction
+// has the topic j. Transactions differ from one block to another.
+func BlockData(number int64, at time.Time, txCount, logsPerTx int) *model.Data {
+	header := &types.Header{Number: big.NewInt(number), Difficulty: big.NewInt(0), Time: uint64(at.Unix())}
+	blockData := &model.Data{
+		TransactionHashes: make(map[string]*types.Transaction),
+		Events:            make(map[string][]*types.Log),
+	}
+
+	var txs []*types.Transaction
+	for i := 0; i < txCount; i++ {
+		to := common.BigToAddress(big.NewInt(int64(i)))
+		tx := types.NewTx(&types.LegacyTx{
+			Nonce:    uint64(number)<<16 | uint64(i),
+			GasPrice: big.NewInt(1e9),
+			Gas:      21000,
+			To:       &to,
+			Value:    big.NewInt(1),
+			Data:     []byte(strings.Repeat("a", 64)),
+		})
+		txs = append(txs, tx)
+		blockData.TransactionHashes[tx.Hash().Hex()] = tx
+
+		for j := 0; j < logsPerTx; j++ {
+			blockData.Events[tx.Hash().Hex()] = append(blockData.Events[tx.Hash().Hex()], &types.Log{
+				Address:     to,
+				Topics:      []common.Hash{common.HexToHash(fmt.Sprint(j))},
+				Data:        make([]byte, 32),
+				BlockNumber: uint64(number),
+				TxHash:      tx.Hash(),
+				TxIndex:     uint(i),
+				Index:       uint(i*logsPerTx + j),
+			})
+		}
+	}
+
+	blockData.Block = model.Block{Header: header, Body: &types.Body{Transactions: txs}}
+	return blockData
+}
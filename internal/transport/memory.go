@@ -0,0 +1,107 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"ethereum-data-service/internal/config"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// redeliveryDelay is how long a failed message waits before it is handed to a subscriber again.
+const redeliveryDelay = time.Second
+
+// Memory is an in-process transport backed by a buffered Go channel. It is meant for single-node setups
+// where the notifier and the subscriber run in the same process and no broker is wanted, which is what
+// `all` does, handing the same Memory to both. Subscribers compete for messages; a message whose handler
+// fails is redelivered, at most `MAX_DELIVERIES` times, to whichever subscriber is consuming by then, and then
+// once more as exhausted. Messages do not survive a process restart.
+type Memory struct {
+	ch            chan *Message
+	seq           atomic.Int64
+	maxDeliveries int64
+	closed        chan struct{}
+	closeOnce     sync.Once
+}
+
+// NewMemory returns an in-memory transport with a buffer of `MEMORY_BUFFER_SIZE`.
+func NewMemory(cfg *config.Config) *Memory {
+	return &Memory{
+		ch:            make(chan *Message, cfg.MEMORY_BUFFER_SIZE),
+		maxDeliveries: cfg.MAX_DELIVERIES,
+		closed:        make(chan struct{}),
+	}
+}
+
+// Publish enqueues the payload, blocking while the buffer is full.
+func (m *Memory) Publish(ctx context.Context, payload []byte) error {
+	msg := &Message{ID: fmt.Sprint(m.seq.Add(1)), Payload: payload}
+	return m.enqueue(ctx, msg)
+}
+
+// Subscribe invokes handler for every message until ctx is cancelled.
+func (m *Memory) Subscribe(ctx context.Context, handler Handler) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg := <-m.ch:
+			if m.maxDeliveries > 0 && msg.Attempts > m.maxDeliveries {
+				log.Printf("giving up on message %s after %d delivery attempts\n", msg.ID, msg.Attempts-1)
+				msg.Exhausted = true
+			}
+
+			msg.settle = func(err error) {
+				if err != nil {
+					m.redeliver(msg)
+				}
+			}
+
+			err := handler(ctx, msg)
+			if err != nil && !errors.Is(err, ErrDeferred) {
+				log.Printf("error processing message %s (attempt %d): %v\n", msg.ID, msg.Attempts, err)
+				m.redeliver(msg)
+			}
+		}
+	}
+}
+
+// Close drops the messages waiting for their redelivery.
+func (m *Memory) Close() error {
+	m.closeOnce.Do(func() { close(m.closed) })
+	return nil
+}
+
+func (m *Memory) enqueue(ctx context.Context, msg *Message) error {
+	msg.Attempts++
+	select {
+	case m.ch <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// redeliver re-enqueues a failed message after redeliveryDelay, unless even its exhausted delivery failed. The
+// message outlives the subscriber it failed in, so that the one restarted in its place gets it.
+func (m *Memory) redeliver(msg *Message) {
+	if msg.Exhausted {
+		log.Printf("dropping message %s, which failed past %d delivery attempts\n", msg.ID, m.maxDeliveries)
+		return
+	}
+
+	go func() {
+		select {
+		case <-time.After(redeliveryDelay):
+			msg.Attempts++
+			select {
+			case m.ch <- msg:
+			case <-m.closed:
+			}
+		case <-m.closed:
+		}
+	}()
+}
@@ -0,0 +1,188 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"ethereum-data-service/internal/config"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// NATSJetStream publishes and consumes block payloads through a NATS JetStream stream. Subscribers share
+// a durable pull consumer with explicit acknowledgements, so a message which is not acknowledged within
+// `NATS_ACK_WAIT` is redelivered, at most `MAX_DELIVERIES` times. The consumer allows one more delivery, on
+// which the message is handed to the handler as exhausted.
+type NATSJetStream struct {
+	nc            *nats.Conn
+	js            jetstream.JetStream
+	stream        string
+	subject       string
+	consumer      string
+	ackWait       time.Duration
+	maxDeliveries int64
+	ownsConn      bool
+}
+
+// DialNATSJetStream connects to `NATS_URL` and returns a NATSJetStream which closes the connection on Close.
+func DialNATSJetStream(cfg *config.Config) (*NATSJetStream, error) {
+	nc, err := nats.Connect(cfg.NATS_URL)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to NATS at %s: %v", cfg.NATS_URL, err)
+	}
+
+	t, err := NewNATSJetStream(nc, cfg)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	t.ownsConn = true
+	return t, nil
+}
+
+// NewNATSJetStream returns a NATSJetStream on an existing connection (for ex. one to an embedded server)
+// and creates or updates the `NATS_STREAM` stream.
+func NewNATSJetStream(nc *nats.Conn, cfg *config.Config) (*NATSJetStream, error) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DEFAULT_TIMEOUT)
+	defer cancel()
+
+	_, err = js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:     cfg.NATS_STREAM,
+		Subjects: []string{cfg.NATS_SUBJECT},
+		MaxMsgs:  cfg.NATS_STREAM_MAXMSGS,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating NATS stream %s: %v", cfg.NATS_STREAM, err)
+	}
+
+	return &NATSJetStream{
+		nc:            nc,
+		js:            js,
+		stream:        cfg.NATS_STREAM,
+		subject:       cfg.NATS_SUBJECT,
+		consumer:      cfg.NATS_CONSUMER,
+		ackWait:       cfg.NATS_ACK_WAIT,
+		maxDeliveries: cfg.MAX_DELIVERIES,
+	}, nil
+}
+
+// Publish publishes the payload to the stream subject and waits for the server acknowledgement.
+func (t *NATSJetStream) Publish(ctx context.Context, payload []byte) error {
+	_, err := t.js.Publish(ctx, t.subject, payload)
+	return err
+}
+
+// Subscribe consumes the stream through the durable consumer and invokes handler for every message until
+// ctx is cancelled. Messages are acknowledged when handler succeeds and negatively acknowledged otherwise. The
+// consumer is created again if it is deleted (see RemoveConsumer) while subscribed.
+func (t *NATSJetStream) Subscribe(ctx context.Context, handler Handler) error {
+	for {
+		deleted, err := t.consume(ctx, handler)
+		if err != nil || !deleted {
+			return err
+		}
+		log.Printf("NATS consumer %s is gone, creating it again\n", t.consumer)
+	}
+}
+
+// consume consumes the stream until ctx is cancelled or the consumer is deleted, which it reports.
+func (t *NATSJetStream) consume(ctx context.Context, handler Handler) (bool, error) {
+	// The delivery past `MAX_DELIVERIES` hands the message to the handler as exhausted
+	maxDeliver := -1
+	if t.maxDeliveries > 0 {
+		maxDeliver = int(t.maxDeliveries) + 1
+	}
+
+	cons, err := t.js.CreateOrUpdateConsumer(ctx, t.stream, jetstream.ConsumerConfig{
+		Durable:       t.consumer,
+		FilterSubject: t.subject,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       t.ackWait,
+		MaxDeliver:    maxDeliver,
+	})
+	if err != nil {
+		return false, fmt.Errorf("error creating NATS consumer %s: %v", t.consumer, err)
+	}
+
+	deleted := make(chan struct{})
+	var once sync.Once
+	cc, err := cons.Consume(func(m jetstream.Msg) {
+		msg := &Message{Payload: m.Data(), Attempts: 1}
+		if md, err := m.Metadata(); err == nil {
+			msg.ID = fmt.Sprint(md.Sequence.Stream)
+			msg.Attempts = int64(md.NumDelivered)
+		}
+		if t.maxDeliveries > 0 && msg.Attempts > t.maxDeliveries {
+			log.Printf("giving up on NATS message %s after %d delivery attempts\n", msg.ID, msg.Attempts-1)
+			msg.Exhausted = true
+		}
+
+		msg.settle = func(err error) { settleNATS(m, msg.ID, err) }
+
+		err := handler(ctx, msg)
+		if errors.Is(err, ErrDeferred) {
+			return
+		}
+		if err != nil {
+			log.Printf("error processing NATS message %s (attempt %d): %v\n", msg.ID, msg.Attempts, err)
+		}
+		settleNATS(m, msg.ID, err)
+	}, jetstream.ConsumeErrHandler(func(_ jetstream.ConsumeContext, err error) {
+		if errors.Is(err, jetstream.ErrConsumerDeleted) {
+			once.Do(func() { close(deleted) })
+			return
+		}
+		log.Printf("error consuming from NATS stream %s: %v\n", t.stream, err)
+	}))
+	if err != nil {
+		return false, err
+	}
+	defer cc.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false, nil
+	case <-deleted:
+		return true, nil
+	}
+}
+
+// settleNATS acknowledges a message when err is nil and negatively acknowledges it otherwise.
+func settleNATS(m jetstream.Msg, id string, err error) {
+	if err != nil {
+		if err := m.Nak(); err != nil {
+			log.Printf("error negatively acknowledging NATS message %s: %v\n", id, err)
+		}
+		return
+	}
+
+	if err := m.Ack(); err != nil {
+		log.Printf("error acknowledging NATS message %s: %v\n", id, err)
+	}
+}
+
+// RemoveConsumer deletes the `NATS_CONSUMER` durable consumer of cfg.
+func (t *NATSJetStream) RemoveConsumer(ctx context.Context, cfg *config.Config) error {
+	err := t.js.DeleteConsumer(ctx, t.stream, cfg.NATS_CONSUMER)
+	if err != nil && !errors.Is(err, jetstream.ErrConsumerNotFound) {
+		return fmt.Errorf("error deleting NATS consumer %s: %v", cfg.NATS_CONSUMER, err)
+	}
+	return nil
+}
+
+// Close drains the NATS connection if it was opened by DialNATSJetStream.
+func (t *NATSJetStream) Close() error {
+	if t.ownsConn {
+		return t.nc.Drain()
+	}
+	return nil
+}
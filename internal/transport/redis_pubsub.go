@@ -0,0 +1,57 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"ethereum-data-service/internal/config"
+	"log"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisPubSub publishes and consumes block payloads through a Redis Pub/Sub channel.
+// Pub/Sub is fire-and-forget: messages are not acknowledged, and anything published
+// while no subscriber is connected is lost.
+type RedisPubSub struct {
+	rdb     redis.UniversalClient
+	channel string
+}
+
+// NewRedisPubSub returns a RedisPubSub on the `REDIS_PUBSUB_CH` channel.
+func NewRedisPubSub(rdb redis.UniversalClient, cfg *config.Config) *RedisPubSub {
+	return &RedisPubSub{rdb: rdb, channel: cfg.REDIS_PUBSUB_CH}
+}
+
+// Publish publishes the payload to the channel.
+func (p *RedisPubSub) Publish(ctx context.Context, payload []byte) error {
+	return p.rdb.Publish(ctx, p.channel, payload).Err()
+}
+
+// Subscribe subscribes to the channel and invokes handler for every message until ctx is cancelled.
+// Handler errors are only logged since Pub/Sub cannot redeliver a message.
+func (p *RedisPubSub) Subscribe(ctx context.Context, handler Handler) error {
+	subscriber := p.rdb.Subscribe(ctx, p.channel)
+	defer subscriber.Close()
+
+	// Channel to receive messages
+	ch := subscriber.Channel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			if err := handler(ctx, &Message{Payload: []byte(msg.Payload), Attempts: 1}); err != nil && !errors.Is(err, ErrDeferred) {
+				log.Printf("error processing message from channel %s: %v\n", p.channel, err)
+			}
+		}
+	}
+}
+
+// Close is a no-op, the Redis client is owned by the caller.
+func (p *RedisPubSub) Close() error {
+	return nil
+}
@@ -0,0 +1,250 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"ethereum-data-service/internal/config"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// PAYLOAD_FIELD is the stream entry field which carries the block payload.
+	PAYLOAD_FIELD = "payload"
+
+	// readBlockTimeout bounds how long XREADGROUP waits for new entries before the loop checks for reclaimable ones.
+	readBlockTimeout = 5 * time.Second
+	// readBatchSize is the maximum number of entries fetched per XREADGROUP/XAUTOCLAIM call.
+	readBatchSize = 10
+)
+
+// RedisStream publishes and consumes block payloads through a Redis stream with a consumer group.
+// Entries are acknowledged only after they have been processed, which gives at-least-once delivery:
+// entries left pending by a crashed consumer are reclaimed by another one once they have been idle
+// for `REDIS_CLAIM_MIN_IDLE`. An entry delivered more than `MAX_DELIVERIES` times is handed to the handler
+// once more as exhausted, and acknowledged once the handler recorded it.
+type RedisStream struct {
+	rdb           redis.UniversalClient
+	stream        string
+	group         string
+	consumer      string
+	maxLen        int64
+	minIdle       time.Duration
+	maxDeliveries int64
+	readBlock     time.Duration
+}
+
+// NewRedisStream returns a RedisStream configured from cfg.
+func NewRedisStream(rdb redis.UniversalClient, cfg *config.Config) *RedisStream {
+	return &RedisStream{
+		rdb:           rdb,
+		stream:        cfg.REDIS_STREAM,
+		group:         cfg.REDIS_CONSUMER_GROUP,
+		consumer:      cfg.REDIS_CONSUMER_NAME,
+		maxLen:        cfg.REDIS_STREAM_MAXLEN,
+		minIdle:       cfg.REDIS_CLAIM_MIN_IDLE,
+		maxDeliveries: cfg.MAX_DELIVERIES,
+		readBlock:     readBlockTimeout,
+	}
+}
+
+// Publish appends the payload to the stream, trimming it to approximately `REDIS_STREAM_MAXLEN` entries.
+func (s *RedisStream) Publish(ctx context.Context, payload []byte) error {
+	return s.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.stream,
+		MaxLen: s.maxLen,
+		Approx: true,
+		Values: map[string]interface{}{PAYLOAD_FIELD: payload},
+	}).Err()
+}
+
+// Subscribe consumes the stream through the consumer group and invokes handler for every entry until ctx is cancelled.
+// Entries this consumer had not acknowledged before a restart are processed first.
+func (s *RedisStream) Subscribe(ctx context.Context, handler Handler) error {
+	if err := s.createGroup(ctx); err != nil {
+		return err
+	}
+
+	// Drain the entries that were delivered to this consumer before it restarted
+	if err := s.drainOwnPending(ctx, handler); err != nil {
+		return err
+	}
+
+	lastClaim := time.Time{}
+	for ctx.Err() == nil {
+		// Take over entries from consumers that crashed before acknowledging them
+		if time.Since(lastClaim) >= s.minIdle/2 {
+			if err := s.reclaim(ctx, handler); err != nil && ctx.Err() == nil {
+				log.Printf("error reclaiming pending stream entries: %v", err)
+			}
+			lastClaim = time.Now()
+		}
+
+		streams, err := s.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    s.group,
+			Consumer: s.consumer,
+			Streams:  []string{s.stream, ">"},
+			Count:    readBatchSize,
+			Block:    s.readBlock,
+		}).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) || ctx.Err() != nil {
+				continue
+			}
+			// The group was removed (see RemoveConsumer) while this consumer was away
+			if strings.HasPrefix(err.Error(), "NOGROUP") {
+				log.Printf("Consumer group %s is gone, creating it again\n", s.group)
+				if err := s.createGroup(ctx); err != nil {
+					return err
+				}
+				continue
+			}
+			return fmt.Errorf("error reading from stream %s: %v", s.stream, err)
+		}
+
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				s.process(ctx, handler, msg, false)
+			}
+		}
+	}
+
+	return nil
+}
+
+// createGroup creates the consumer group (and the stream) if it does not exist yet.
+func (s *RedisStream) createGroup(ctx context.Context) error {
+	err := s.rdb.XGroupCreateMkStream(ctx, s.stream, s.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("error creating consumer group %s: %v", s.group, err)
+	}
+	return nil
+}
+
+// RemoveConsumer destroys the `REDIS_CONSUMER_GROUP` consumer group of cfg.
+func (s *RedisStream) RemoveConsumer(ctx context.Context, cfg *config.Config) error {
+	if err := s.rdb.XGroupDestroy(ctx, s.stream, cfg.REDIS_CONSUMER_GROUP).Err(); err != nil {
+		return fmt.Errorf("error destroying consumer group %s: %v", cfg.REDIS_CONSUMER_GROUP, err)
+	}
+	return nil
+}
+
+// drainOwnPending re-reads the entries that are still pending for this consumer.
+func (s *RedisStream) drainOwnPending(ctx context.Context, handler Handler) error {
+	start := "0"
+	for {
+		streams, err := s.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    s.group,
+			Consumer: s.consumer,
+			Streams:  []string{s.stream, start},
+			Count:    readBatchSize,
+			Block:    -1,
+		}).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				return nil
+			}
+			return fmt.Errorf("error reading pending entries from stream %s: %v", s.stream, err)
+		}
+
+		if len(streams) == 0 || len(streams[0].Messages) == 0 {
+			return nil
+		}
+
+		for _, msg := range streams[0].Messages {
+			s.process(ctx, handler, msg, true)
+			start = msg.ID
+		}
+	}
+}
+
+// reclaim claims entries which have been idle for longer than `REDIS_CLAIM_MIN_IDLE` and processes them.
+func (s *RedisStream) reclaim(ctx context.Context, handler Handler) error {
+	start := "0-0"
+	for {
+		msgs, next, err := s.rdb.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   s.stream,
+			Group:    s.group,
+			Consumer: s.consumer,
+			MinIdle:  s.minIdle,
+			Start:    start,
+			Count:    readBatchSize,
+		}).Result()
+		if err != nil {
+			return err
+		}
+
+		for _, msg := range msgs {
+			log.Printf("Reclaimed pending stream entry %s\n", msg.ID)
+			s.process(ctx, handler, msg, true)
+		}
+
+		if next == "0-0" || len(msgs) == 0 {
+			return nil
+		}
+		start = next
+	}
+}
+
+// process hands a single entry to the handler and acknowledges it on success.
+func (s *RedisStream) process(ctx context.Context, handler Handler, entry redis.XMessage, redelivered bool) {
+	msg := &Message{ID: entry.ID, Attempts: 1}
+	if payload, ok := entry.Values[PAYLOAD_FIELD].(string); ok {
+		msg.Payload = []byte(payload)
+	}
+
+	if redelivered {
+		msg.Attempts = s.deliveryCount(ctx, entry.ID)
+	}
+
+	if s.maxDeliveries > 0 && msg.Attempts > s.maxDeliveries {
+		log.Printf("giving up on stream entry %s after %d delivery attempts\n", msg.ID, msg.Attempts-1)
+		msg.Exhausted = true
+	}
+
+	// An entry left unacknowledged is reclaimed once it has been idle for `REDIS_CLAIM_MIN_IDLE`
+	msg.settle = func(err error) {
+		if err == nil {
+			s.ack(context.Background(), msg.ID)
+		}
+	}
+
+	if err := handler(ctx, msg); err != nil {
+		if !errors.Is(err, ErrDeferred) {
+			log.Printf("error processing stream entry %s (attempt %d): %v\n", msg.ID, msg.Attempts, err)
+		}
+		return
+	}
+
+	s.ack(ctx, msg.ID)
+}
+
+// deliveryCount returns how many times the entry has been delivered, as tracked by the consumer group.
+func (s *RedisStream) deliveryCount(ctx context.Context, id string) int64 {
+	pending, err := s.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: s.stream,
+		Group:  s.group,
+		Start:  id,
+		End:    id,
+		Count:  1,
+	}).Result()
+	if err != nil || len(pending) == 0 {
+		return 1
+	}
+	return pending[0].RetryCount
+}
+
+// Close is a no-op, the Redis client is owned by the caller.
+func (s *RedisStream) Close() error {
+	return nil
+}
+
+func (s *RedisStream) ack(ctx context.Context, id string) {
+	if err := s.rdb.XAck(ctx, s.stream, s.group, id).Err(); err != nil {
+		log.Printf("error acknowledging stream entry %s: %v\n", id, err)
+	}
+}
@@ -0,0 +1,77 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"ethereum-data-service/internal/config"
+	"ethereum-data-service/pkg/enum"
+
+	eth_err "ethereum-data-service/pkg/err"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Transport carries formatted block payloads from the notifier to the subscribers.
+type Transport interface {
+	// Publish hands a payload over to the transport.
+	Publish(ctx context.Context, payload []byte) error
+	// Subscribe delivers every payload to handler until ctx is cancelled. Transports which support
+	// acknowledgements acknowledge a message only when handler returns nil and redeliver it otherwise.
+	Subscribe(ctx context.Context, handler Handler) error
+	// Close releases the resources held by the transport.
+	Close() error
+}
+
+// ConsumerRemover is implemented by the transports which read through a consumer kept on the server, which
+// outlives the subscribers reading through it until it is removed.
+type ConsumerRemover interface {
+	// RemoveConsumer deletes the consumer named in cfg, the Redis consumer group or the NATS durable consumer,
+	// along with the messages still pending for it. A missing consumer is not an error.
+	RemoveConsumer(ctx context.Context, cfg *config.Config) error
+}
+
+// ErrDeferred is returned by a Handler which keeps a message to process it later. The transport then neither
+// acknowledges nor redelivers the message, which the handler settles with Message.Settle once it is processed.
+var ErrDeferred = errors.New("message processing deferred")
+
+// Message is a single block payload delivered to a subscriber.
+type Message struct {
+	ID       string // ID identifies the message within the transport.
+	Payload  []byte // Payload is the formatted block data.
+	Attempts int64  // Attempts is the number of times this message has been delivered so far (starting at 1).
+	// Exhausted is set on the delivery following the last of `MAX_DELIVERIES`. The handler must not process the
+	// message again, only record it (for ex. in the dead-letter store), after which it is acknowledged.
+	Exhausted bool
+
+	settle func(err error)
+}
+
+// Settle acknowledges a message whose handler returned ErrDeferred when err is nil, and has it redelivered
+// otherwise, as the transport does with the error its handler returns.
+func (m *Message) Settle(err error) {
+	if m.settle != nil {
+		m.settle(err)
+	}
+}
+
+// Handler processes a delivered message. Returning an error leaves the message unacknowledged, except for
+// ErrDeferred.
+type Handler func(ctx context.Context, msg *Message) error
+
+// New returns the transport selected by `TRANSPORT` in the config. The `memory` transport is rejected, since a
+// transport created on its own would connect no other service: `all` creates one with NewMemory and hands it to
+// every service of the process.
+func New(cfg *config.Config, rdb redis.UniversalClient) (Transport, error) {
+	switch enum.Transport(cfg.TRANSPORT) {
+	case enum.REDIS_STREAMS:
+		return NewRedisStream(rdb, cfg), nil
+	case enum.REDIS_PUBSUB:
+		return NewRedisPubSub(rdb, cfg), nil
+	case enum.NATS:
+		return DialNATSJetStream(cfg)
+	case enum.MEMORY:
+		return nil, eth_err.ErrMemoryTransport
+	default:
+		return nil, eth_err.ErrInvalidTransport
+	}
+}
@@ -0,0 +1,494 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"ethereum-data-service/internal/config"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/redis/go-redis/v9"
+)
+
+// receiveTimeout bounds how long a test waits for a message.
+const receiveTimeout = 10 * time.Second
+
+// collector is a Handler passing every delivered message on, failing the first delivery of the payloads in fail.
+type collector struct {
+	msgs chan *Message
+	fail map[string]bool
+}
+
+func newCollector(fail ...string) *collector {
+	c := &collector{msgs: make(chan *Message, 100), fail: make(map[string]bool)}
+	for _, payload := range fail {
+		c.fail[payload] = true
+	}
+	return c
+}
+
+func (c *collector) handle(ctx context.Context, msg *Message) error {
+	c.msgs <- &Message{ID: msg.ID, Payload: append([]byte(nil), msg.Payload...), Attempts: msg.Attempts, Exhausted: msg.Exhausted}
+	if c.fail[string(msg.Payload)] && msg.Attempts == 1 {
+		return errors.New("handler failure")
+	}
+	return nil
+}
+
+// receive waits for the next delivered message.
+func (c *collector) receive(t *testing.T) *Message {
+	t.Helper()
+	select {
+	case msg := <-c.msgs:
+		return msg
+	case <-time.After(receiveTimeout):
+		t.Fatal("no message delivered")
+		return nil
+	}
+}
+
+// expectNone checks that no message is delivered within d.
+func (c *collector) expectNone(t *testing.T, d time.Duration) {
+	t.Helper()
+	select {
+	case msg := <-c.msgs:
+		t.Errorf("unexpected delivery of %q (attempt %d)", msg.Payload, msg.Attempts)
+	case <-time.After(d):
+	}
+}
+
+// subscribe runs tr.Subscribe in the background and returns a function stopping it and waiting for it to return.
+func subscribe(t *testing.T, tr Transport, handler Handler) func() {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- tr.Subscribe(ctx, handler) }()
+
+	stopped := false
+	stop := func() {
+		if stopped {
+			return
+		}
+		stopped = true
+		cancel()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Subscribe() = %v", err)
+			}
+		case <-time.After(receiveTimeout):
+			t.Error("Subscribe did not return once cancelled")
+		}
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+func publish(t *testing.T, tr Transport, payloads ...string) {
+	t.Helper()
+	for _, payload := range payloads {
+		if err := tr.Publish(context.Background(), []byte(payload)); err != nil {
+			t.Fatalf("Publish(%q): %v", payload, err)
+		}
+	}
+}
+
+func quietLogs(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+}
+
+// testRoundTrip checks that the payloads published are delivered once each, in order, at their first attempt.
+func testRoundTrip(t *testing.T, tr Transport) {
+	c := newCollector()
+	subscribe(t, tr, c.handle)
+
+	publish(t, tr, "a", "b", "c")
+	for _, want := range []string{"a", "b", "c"} {
+		msg := c.receive(t)
+		if string(msg.Payload) != want || msg.Attempts != 1 {
+			t.Errorf("got %q (attempt %d), want %q (attempt 1)", msg.Payload, msg.Attempts, want)
+		}
+	}
+	c.expectNone(t, 200*time.Millisecond)
+}
+
+// testRedelivery checks that a message whose handler failed is delivered again, either right away or to the
+// next subscriber once the failing one is gone, while an acknowledged one is not.
+func testRedelivery(t *testing.T, newTransport func() Transport) {
+	c := newCollector("b")
+	tr := newTransport()
+	stop := subscribe(t, tr, c.handle)
+
+	publish(t, tr, "a", "b")
+	for _, want := range []string{"a", "b"} {
+		if msg := c.receive(t); string(msg.Payload) != want || msg.Attempts != 1 {
+			t.Fatalf("got %q (attempt %d), want %q (attempt 1)", msg.Payload, msg.Attempts, want)
+		}
+	}
+
+	stop()
+	subscribe(t, newTransport(), c.handle)
+
+	msg := c.receive(t)
+	if string(msg.Payload) != "b" || msg.Attempts != 2 {
+		t.Errorf("got %q (attempt %d), want %q redelivered (attempt 2)", msg.Payload, msg.Attempts, "b")
+	}
+	c.expectNone(t, 500*time.Millisecond)
+}
+
+// testDeferred checks that a message whose handler returned ErrDeferred is neither acknowledged nor redelivered
+// until it is settled, and then only redelivered when settled with an error.
+func testDeferred(t *testing.T, newTransport func() Transport) {
+	c := newCollector()
+	deferred := make(chan *Message, 2)
+	handler := func(ctx context.Context, msg *Message) error {
+		if msg.Attempts == 1 && string(msg.Payload) != "c" {
+			deferred <- msg
+			return ErrDeferred
+		}
+		return c.handle(ctx, msg)
+	}
+
+	tr := newTransport()
+	stop := subscribe(t, tr, handler)
+
+	// The deferred messages do not hold up the following ones
+	publish(t, tr, "a", "b", "c")
+	if msg := c.receive(t); string(msg.Payload) != "c" {
+		t.Fatalf("got %q, want %q", msg.Payload, "c")
+	}
+	c.expectNone(t, 2*redeliveryDelay)
+
+	for _, want := range []string{"a", "b"} {
+		select {
+		case msg := <-deferred:
+			if string(msg.Payload) != want {
+				t.Fatalf("deferred %q, want %q", msg.Payload, want)
+			}
+			if want == "a" {
+				msg.Settle(nil)
+			} else {
+				msg.Settle(errors.New("handler failure"))
+			}
+		case <-time.After(receiveTimeout):
+			t.Fatalf("%q was not delivered", want)
+		}
+	}
+
+	stop()
+	subscribe(t, newTransport(), handler)
+
+	msg := c.receive(t)
+	if string(msg.Payload) != "b" || msg.Attempts != 2 {
+		t.Errorf("got %q (attempt %d), want %q redelivered (attempt 2)", msg.Payload, msg.Attempts, "b")
+	}
+	c.expectNone(t, 500*time.Millisecond)
+}
+
+// testMaxDeliveries checks that a message whose handler fails every delivery is delivered maxDeliveries times,
+// then once more as exhausted, and is no longer delivered once its exhausted delivery succeeded.
+func testMaxDeliveries(t *testing.T, tr Transport, maxDeliveries int64) {
+	c := newCollector()
+	subscribe(t, tr, func(ctx context.Context, msg *Message) error {
+		c.handle(ctx, msg)
+		if msg.Exhausted {
+			return nil
+		}
+		return errors.New("handler failure")
+	})
+
+	publish(t, tr, "a")
+	for attempt := int64(1); attempt <= maxDeliveries+1; attempt++ {
+		if msg := c.receive(t); msg.Attempts != attempt || msg.Exhausted != (attempt > maxDeliveries) {
+			t.Errorf("got attempt %d (exhausted %v), want %d (exhausted %v)", msg.Attempts, msg.Exhausted, attempt, attempt > maxDeliveries)
+		}
+	}
+	c.expectNone(t, 2*redeliveryDelay)
+}
+
+func newTestRedis(t *testing.T) redis.UniversalClient {
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return rdb
+}
+
+// newTestRedisStream returns a RedisStream whose reads block briefly, so that it stops soon once cancelled.
+func newTestRedisStream(rdb redis.UniversalClient, consumer string, minIdle time.Duration) *RedisStream {
+	s := NewRedisStream(rdb, &config.Config{
+		REDIS_STREAM:         "blocks",
+		REDIS_CONSUMER_GROUP: "subscribers",
+		REDIS_CONSUMER_NAME:  consumer,
+		REDIS_STREAM_MAXLEN:  1000,
+		REDIS_CLAIM_MIN_IDLE: minIdle,
+		MAX_DELIVERIES:       5,
+	})
+	s.readBlock = 100 * time.Millisecond
+	return s
+}
+
+func TestRedisStreamRoundTrip(t *testing.T) {
+	quietLogs(t)
+	testRoundTrip(t, newTestRedisStream(newTestRedis(t), "sub-1", time.Minute))
+}
+
+func TestRedisStreamRedelivery(t *testing.T) {
+	quietLogs(t)
+	rdb := newTestRedis(t)
+
+	// The restarted consumer reads the entries left pending for it first
+	testRedelivery(t, func() Transport { return newTestRedisStream(rdb, "sub-1", time.Minute) })
+}
+
+func TestRedisStreamDeferred(t *testing.T) {
+	quietLogs(t)
+	rdb := newTestRedis(t)
+	testDeferred(t, func() Transport { return newTestRedisStream(rdb, "sub-1", time.Minute) })
+}
+
+func TestRedisStreamMaxDeliveries(t *testing.T) {
+	quietLogs(t)
+
+	// Failed entries are reclaimed by the consumer itself once idle
+	testMaxDeliveries(t, newTestRedisStream(newTestRedis(t), "sub-1", 50*time.Millisecond), 5)
+}
+
+func TestRedisStreamReclaim(t *testing.T) {
+	quietLogs(t)
+	rdb := newTestRedis(t)
+
+	c := newCollector("a")
+	crashed := newTestRedisStream(rdb, "sub-1", time.Minute)
+	stop := subscribe(t, crashed, c.handle)
+	publish(t, crashed, "a")
+	if msg := c.receive(t); string(msg.Payload) != "a" || msg.Attempts != 1 {
+		t.Fatalf("got %q (attempt %d), want %q (attempt 1)", msg.Payload, msg.Attempts, "a")
+	}
+	stop()
+
+	// Another consumer takes the entry over once it has been idle for REDIS_CLAIM_MIN_IDLE
+	time.Sleep(100 * time.Millisecond)
+	subscribe(t, newTestRedisStream(rdb, "sub-2", 50*time.Millisecond), c.handle)
+	if msg := c.receive(t); string(msg.Payload) != "a" || msg.Attempts != 2 {
+		t.Errorf("got %q (attempt %d), want %q reclaimed (attempt 2)", msg.Payload, msg.Attempts, "a")
+	}
+
+	// The entry is acknowledged once processed
+	deadline := time.Now().Add(receiveTimeout)
+	for {
+		pending, err := rdb.XPending(context.Background(), "blocks", "subscribers").Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pending.Count == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d entries still pending after the reclaimed one was processed", pending.Count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRedisPubSub(t *testing.T) {
+	quietLogs(t)
+	rdb := newTestRedis(t)
+	tr := NewRedisPubSub(rdb, &config.Config{REDIS_PUBSUB_CH: "blocks"})
+
+	// Fire-and-forget: a failed message is not redelivered
+	c := newCollector("a")
+	subscribe(t, tr, c.handle)
+
+	// Messages published before the subscription is active are lost
+	deadline := time.Now().Add(receiveTimeout)
+	for {
+		subs, err := rdb.PubSubNumSub(context.Background(), "blocks").Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if subs["blocks"] > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("subscription never became active")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	publish(t, tr, "a", "b")
+	for _, want := range []string{"a", "b"} {
+		if msg := c.receive(t); string(msg.Payload) != want || msg.Attempts != 1 {
+			t.Errorf("got %q (attempt %d), want %q (attempt 1)", msg.Payload, msg.Attempts, want)
+		}
+	}
+	c.expectNone(t, 200*time.Millisecond)
+}
+
+// newTestNATS starts an in-process NATS server with JetStream and returns a connection to it.
+func newTestNATS(t *testing.T) *nats.Conn {
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir(), NoLog: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ns.Start()
+	t.Cleanup(ns.Shutdown)
+	if !ns.ReadyForConnections(receiveTimeout) {
+		t.Fatal("NATS server not ready")
+	}
+
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+func newTestNATSJetStream(t *testing.T, nc *nats.Conn) Transport {
+	t.Helper()
+	tr, err := NewNATSJetStream(nc, &config.Config{
+		DEFAULT_TIMEOUT: receiveTimeout,
+		NATS_STREAM:     "BLOCKS",
+		NATS_SUBJECT:    "blocks",
+		NATS_CONSUMER:   "subscribers",
+		NATS_ACK_WAIT:   time.Minute,
+		MAX_DELIVERIES:  5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
+
+func TestNATSJetStreamRoundTrip(t *testing.T) {
+	quietLogs(t)
+	testRoundTrip(t, newTestNATSJetStream(t, newTestNATS(t)))
+}
+
+func TestNATSJetStreamRedelivery(t *testing.T) {
+	quietLogs(t)
+	nc := newTestNATS(t)
+
+	// A negatively acknowledged message is redelivered right away, through the durable consumer
+	testRedelivery(t, func() Transport { return newTestNATSJetStream(t, nc) })
+}
+
+func TestNATSJetStreamDeferred(t *testing.T) {
+	quietLogs(t)
+	nc := newTestNATS(t)
+	testDeferred(t, func() Transport { return newTestNATSJetStream(t, nc) })
+}
+
+func TestNATSJetStreamMaxDeliveries(t *testing.T) {
+	quietLogs(t)
+	testMaxDeliveries(t, newTestNATSJetStream(t, newTestNATS(t)), 5)
+}
+
+func TestMemoryRoundTrip(t *testing.T) {
+	quietLogs(t)
+	testRoundTrip(t, NewMemory(&config.Config{MEMORY_BUFFER_SIZE: 10, MAX_DELIVERIES: 5}))
+}
+
+func TestMemoryRedelivery(t *testing.T) {
+	quietLogs(t)
+
+	// Messages only live within the transport, so the subscriber which takes over shares it
+	tr := NewMemory(&config.Config{MEMORY_BUFFER_SIZE: 10, MAX_DELIVERIES: 5})
+	testRedelivery(t, func() Transport { return tr })
+}
+
+func TestMemoryDeferred(t *testing.T) {
+	quietLogs(t)
+	tr := NewMemory(&config.Config{MEMORY_BUFFER_SIZE: 10, MAX_DELIVERIES: 5})
+	testDeferred(t, func() Transport { return tr })
+}
+
+func TestMemoryMaxDeliveries(t *testing.T) {
+	quietLogs(t)
+	testMaxDeliveries(t, NewMemory(&config.Config{MEMORY_BUFFER_SIZE: 10, MAX_DELIVERIES: 2}), 2)
+}
+
+func TestNewRejectsMemory(t *testing.T) {
+	if _, err := New(&config.Config{TRANSPORT: "memory"}, nil); err == nil {
+		t.Error("New() accepted the memory transport")
+	}
+}
+
+// testRemoveConsumer checks that a consumer removed while subscribed is created again and reads the stream from its
+// start, and that removing it once unsubscribed leaves no consumer behind.
+func testRemoveConsumer(t *testing.T, tr Transport, cfg *config.Config, consumers func() int) {
+	remover, ok := tr.(ConsumerRemover)
+	if !ok {
+		t.Fatal("transport cannot remove its consumer")
+	}
+
+	c := newCollector()
+	stop := subscribe(t, tr, c.handle)
+	publish(t, tr, "a")
+	if msg := c.receive(t); string(msg.Payload) != "a" {
+		t.Fatalf("got %q, want %q", msg.Payload, "a")
+	}
+
+	if err := remover.RemoveConsumer(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if msg := c.receive(t); string(msg.Payload) != "a" || msg.Attempts != 1 {
+		t.Errorf("got %q (attempt %d), want %q read again by the new consumer (attempt 1)", msg.Payload, msg.Attempts, "a")
+	}
+	publish(t, tr, "b")
+	if msg := c.receive(t); string(msg.Payload) != "b" {
+		t.Errorf("got %q, want %q", msg.Payload, "b")
+	}
+
+	stop()
+	if err := remover.RemoveConsumer(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if n := consumers(); n != 0 {
+		t.Errorf("%d consumers left once removed", n)
+	}
+	if err := remover.RemoveConsumer(context.Background(), cfg); err != nil {
+		t.Errorf("RemoveConsumer of a missing consumer: %v", err)
+	}
+}
+
+func TestRedisStreamRemoveConsumer(t *testing.T) {
+	quietLogs(t)
+	rdb := newTestRedis(t)
+
+	testRemoveConsumer(t, newTestRedisStream(rdb, "sub-1", time.Minute), &config.Config{REDIS_CONSUMER_GROUP: "subscribers"}, func() int {
+		groups, err := rdb.XInfoGroups(context.Background(), "blocks").Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(groups)
+	})
+}
+
+func TestNATSJetStreamRemoveConsumer(t *testing.T) {
+	quietLogs(t)
+	nc := newTestNATS(t)
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testRemoveConsumer(t, newTestNATSJetStream(t, nc), &config.Config{NATS_CONSUMER: "subscribers"}, func() int {
+		stream, err := js.Stream(context.Background(), "BLOCKS")
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := stream.Info(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.State.Consumers
+	})
+}
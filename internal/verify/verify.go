@@ -0,0 +1,372 @@
+package verify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"ethereum-data-service/internal/model"
+	"ethereum-data-service/internal/storage"
+	"ethereum-data-service/pkg/enum"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	eth_err "ethereum-data-service/pkg/err"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// rangePageSize is the number of block keys read per page when walking a range of stored blocks.
+const rangePageSize = 1000
+
+// Mismatch is a value recomputed from the stored data of a block which differs from the one the block commits to.
+type Mismatch struct {
+	Field    string // Field names the value checked, for ex. `transactions root`.
+	Expected string // Expected is the value the header (or the node, for receipts) commits to.
+	Computed string // Computed is the value recomputed from the stored data.
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("%s: expected %s, computed %s", m.Field, m.Expected, m.Computed)
+}
+
+// Report is the outcome of the verification of a stored block.
+type Report struct {
+	BlockNumber uint64
+	BlockHash   common.Hash
+	Mismatches  []Mismatch
+	Refetched   bool // Refetched tells that the block was replaced by the one fetched from the node.
+}
+
+// Summary counts the outcomes of the verification of a range of blocks.
+type Summary struct {
+	Verified   int // Verified is the number of blocks verified.
+	Mismatched int // Mismatched is the number of blocks with at least one mismatch.
+	Refetched  int // Refetched is the number of mismatched blocks replaced by the ones fetched from the node.
+}
+
+// Options selects the checks which need the node.
+type Options struct {
+	// Receipts fetches the receipts of every block to check the receipts root, which cannot be recomputed from
+	// the stored events alone, and that the stored events match the logs of the receipts.
+	Receipts bool
+	// Refetch replaces every block with a mismatch by the block fetched from the node.
+	Refetch bool
+	// Detail is what of the re-fetched blocks is stored, as per `INGEST_DETAIL`.
+	Detail model.Detail
+}
+
+// Verifier checks that the stored blocks match what their headers commit to.
+type Verifier struct {
+	store     storage.Store
+	ethClient *ethclient.Client
+	opts      Options
+}
+
+// New returns a verifier of the blocks of the store. The node is only queried as per opts.
+func New(store storage.Store, ethClient *ethclient.Client, opts Options) *Verifier {
+	return &Verifier{store: store, ethClient: ethClient, opts: opts}
+}
+
+// Verify checks the stored block with the given number, re-fetching it when it has mismatches and the options
+// say so. It returns an error wrapping `ErrNotFound` if the block is not stored.
+func (v *Verifier) Verify(ctx context.Context, blockNumber uint64) (*Report, error) {
+	number := strconv.FormatUint(blockNumber, 10)
+	blockData, err := v.store.GetBlockData(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{BlockNumber: blockNumber, BlockHash: blockData.Block.Header.Hash()}
+	report.Mismatches = Block(blockData)
+
+	mismatch, err := v.checkHash(ctx, blockData.Block.Header)
+	if err != nil {
+		return nil, err
+	}
+	if mismatch != nil {
+		report.Mismatches = append(report.Mismatches, *mismatch)
+	}
+
+	if v.opts.Receipts {
+		receipts, err := v.ethClient.BlockReceipts(ctx, rpc.BlockNumberOrHashWithHash(report.BlockHash, false))
+		if err != nil {
+			return nil, fmt.Errorf("error fetching receipts of block %d: %v", blockNumber, err)
+		}
+		report.Mismatches = append(report.Mismatches, Receipts(blockData, receipts)...)
+	}
+
+	if len(report.Mismatches) > 0 && v.opts.Refetch {
+		if err := v.refetch(ctx, blockNumber); err != nil {
+			return report, fmt.Errorf("error re-fetching block %d: %v", blockNumber, err)
+		}
+		report.Refetched = true
+	}
+
+	return report, nil
+}
+
+// checkHash checks the hash recomputed from the header against the parent hash of the next stored block or, at
+// the head of the stored window, against the hash of the header fetched from the node. Neither is derived from
+// the stored header, so a header corrupted consistently with its indexes is still caught.
+func (v *Verifier) checkHash(ctx context.Context, header *types.Header) (*Mismatch, error) {
+	hash := header.Hash()
+	next := new(big.Int).Add(header.Number, common.Big1)
+
+	child, err := v.store.GetBlockByNumber(ctx, next.String())
+	switch {
+	case err == nil:
+		if child.Header.ParentHash != hash {
+			return &Mismatch{Field: "block hash", Expected: fmt.Sprintf("parent hash of block %s, %s", next, child.Header.ParentHash.Hex()), Computed: hash.Hex()}, nil
+		}
+		return nil, nil
+	case !errors.Is(err, eth_err.ErrNotFound):
+		return nil, err
+	case v.ethClient == nil:
+		return nil, nil
+	}
+
+	canonical, err := v.ethClient.HeaderByNumber(ctx, header.Number)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching header of block %s: %v", header.Number, err)
+	}
+	if canonical.Hash() != hash {
+		return &Mismatch{Field: "block hash", Expected: fmt.Sprintf("hash of block %s on the node, %s", header.Number, canonical.Hash().Hex()), Computed: hash.Hex()}, nil
+	}
+	return nil, nil
+}
+
+// refetch replaces the stored block with the given number by the one fetched from the node. The stored block is
+// removed first, since a block with the same hash would otherwise be skipped as a duplicate.
+func (v *Verifier) refetch(ctx context.Context, blockNumber uint64) error {
+	block, err := v.ethClient.BlockByNumber(ctx, new(big.Int).SetUint64(blockNumber))
+	if err != nil {
+		return err
+	}
+
+	blockData, err := model.NewBlockData(v.ethClient, block, v.opts.Detail)
+	if err != nil {
+		return err
+	}
+	if mismatches := Block(blockData); len(mismatches) > 0 {
+		return fmt.Errorf("block fetched from the node does not verify either (%s)", mismatches[0])
+	}
+
+	if err := v.store.RemoveBlockData(ctx, strconv.FormatUint(blockNumber, 10)); err != nil {
+		return err
+	}
+	_, err = v.store.ApplyBlockData(ctx, blockData)
+	return err
+}
+
+// Range verifies the stored blocks from `from` to `to` (0 for the latest stored block) in height order, calling
+// report for each of them, until ctx is cancelled. Blocks missing from storage are skipped.
+func (v *Verifier) Range(ctx context.Context, from, to uint64, report func(*Report)) (Summary, error) {
+	var summary Summary
+
+	page := storage.Page{Limit: rangePageSize}
+	if from > 0 {
+		page.After = &storage.Cursor{BlockNumber: from - 1}
+	}
+	for ctx.Err() == nil {
+		keys, err := v.store.GetAllBlockNumbers(ctx, page)
+		if err != nil {
+			return summary, err
+		}
+
+		for _, key := range keys {
+			blockNumber, err := strconv.ParseUint(strings.TrimPrefix(key, storage.BLOCK_PREFIX), 10, 64)
+			if err != nil {
+				return summary, err
+			}
+			if (to > 0 && blockNumber > to) || ctx.Err() != nil {
+				return summary, nil
+			}
+			page.After = &storage.Cursor{BlockNumber: blockNumber}
+
+			r, err := v.Verify(ctx, blockNumber)
+			if errors.Is(err, eth_err.ErrNotFound) {
+				continue
+			}
+			if err != nil && r == nil {
+				return summary, err
+			}
+
+			summary.Verified++
+			if len(r.Mismatches) > 0 {
+				summary.Mismatched++
+			}
+			if r.Refetched {
+				summary.Refetched++
+			}
+			report(r)
+			if err != nil {
+				return summary, err
+			}
+		}
+
+		if len(keys) < page.Limit {
+			break
+		}
+	}
+
+	return summary, nil
+}
+
+// Block recomputes, from the stored data of a block, the transactions root, the uncles hash, the withdrawals
+// root and the logs bloom the header commits to, and checks that the events belong to the block and are complete.
+// The receipts root needs the full receipts, which are not stored, and is checked by Receipts instead. Only the
+// header is stored below the `full` detail level, leaving nothing to check, and the transactions root, the logs
+// bloom and the completeness of the events are not checked when the ingestion allowlists left part of them out.
+func Block(blockData *model.Data) []Mismatch {
+	if !model.IncludesLevel(blockData.Block.Detail, enum.FULL) {
+		return nil
+	}
+
+	header := blockData.Block.Header
+	body := blockData.Block.Body
+	if body == nil {
+		body = &types.Body{}
+	}
+	hash := header.Hash()
+	filtered := blockData.Block.Filtered
+
+	var mismatches []Mismatch
+	check := func(field string, expected, computed common.Hash) {
+		if expected != computed {
+			mismatches = append(mismatches, Mismatch{Field: field, Expected: expected.Hex(), Computed: computed.Hex()})
+		}
+	}
+
+	if !filtered {
+		check("transactions root", header.TxHash, types.DeriveSha(types.Transactions(body.Transactions), trie.NewStackTrie(nil)))
+	}
+	check("uncles hash", header.UncleHash, types.CalcUncleHash(body.Uncles))
+	if header.WithdrawalsHash != nil {
+		check("withdrawals root", *header.WithdrawalsHash, types.DeriveSha(types.Withdrawals(body.Withdrawals), trie.NewStackTrie(nil)))
+	}
+
+	txHashes := make(map[string]bool, len(body.Transactions))
+	for _, tx := range body.Transactions {
+		txHashes[tx.Hash().Hex()] = true
+	}
+
+	var logs []*types.Log
+	for txHash, events := range blockData.Events {
+		if !txHashes[txHash] {
+			mismatches = append(mismatches, Mismatch{Field: "events", Expected: "transactions of the block", Computed: "events of transaction " + txHash})
+		}
+		logs = append(logs, events...)
+	}
+	for _, event := range logs {
+		if event.BlockHash != hash || event.BlockNumber != header.Number.Uint64() {
+			mismatches = append(mismatches, Mismatch{Field: "event block hash", Expected: hash.Hex(), Computed: event.BlockHash.Hex()})
+			break
+		}
+	}
+
+	if filtered {
+		return mismatches
+	}
+
+	// The bloom only depends on the logs, and the log indexes of a block run from 0 without a gap
+	if bloom := types.BytesToBloom(types.LogsBloom(logs)); bloom != header.Bloom {
+		mismatches = append(mismatches, Mismatch{Field: "logs bloom", Expected: common.Bytes2Hex(header.Bloom[:]), Computed: common.Bytes2Hex(bloom[:])})
+	}
+	sort.Slice(logs, func(i, j int) bool { return logs[i].Index < logs[j].Index })
+	for i, event := range logs {
+		if event.Index != uint(i) {
+			mismatches = append(mismatches, Mismatch{Field: "log indexes", Expected: fmt.Sprint(i), Computed: fmt.Sprint(event.Index)})
+			break
+		}
+	}
+
+	return mismatches
+}
+
+// Receipts checks the receipts of a block, fetched from the node, against its stored header and events: the
+// receipts root recomputed from them against the header, and their logs against the stored events. Events are
+// not stored below the `full` detail level, and when the ingestion allowlists left part of them out, the stored
+// events only have to be among the logs.
+func Receipts(blockData *model.Data, receipts []*types.Receipt) []Mismatch {
+	var mismatches []Mismatch
+
+	header := blockData.Block.Header
+	if root := types.DeriveSha(types.Receipts(receipts), trie.NewStackTrie(nil)); root != header.ReceiptHash {
+		mismatches = append(mismatches, Mismatch{Field: "receipts root", Expected: header.ReceiptHash.Hex(), Computed: root.Hex()})
+	}
+	if !model.IncludesLevel(blockData.Block.Detail, enum.FULL) {
+		return mismatches
+	}
+
+	same := sameLogs
+	if blockData.Block.Filtered {
+		same = amongLogs
+	}
+	for _, receipt := range receipts {
+		txHash := receipt.TxHash.Hex()
+		if !same(receipt.Logs, blockData.Events[txHash]) {
+			mismatches = append(mismatches, Mismatch{
+				Field:    "events of transaction " + txHash,
+				Expected: fmt.Sprintf("the %d logs of its receipt", len(receipt.Logs)),
+				Computed: fmt.Sprintf("%d differing stored events", len(blockData.Events[txHash])),
+			})
+		}
+	}
+
+	return mismatches
+}
+
+// sameLogs reports whether the stored events are the logs of a receipt, in the same order.
+func sameLogs(logs, events []*types.Log) bool {
+	if len(logs) != len(events) {
+		return false
+	}
+
+	for i, log := range logs {
+		if !sameLog(log, events[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// amongLogs reports whether the stored events are some of the logs of a receipt, in the same order.
+func amongLogs(logs, events []*types.Log) bool {
+	for _, log := range logs {
+		if len(events) > 0 && sameLog(log, events[0]) {
+			events = events[1:]
+		}
+	}
+	return len(events) == 0
+}
+
+func sameLog(log, event *types.Log) bool {
+	if log.Address != event.Address || log.Index != event.Index || !bytes.Equal(log.Data, event.Data) || len(log.Topics) != len(event.Topics) {
+		return false
+	}
+	for j, topic := range log.Topics {
+		if topic != event.Topics[j] {
+			return false
+		}
+	}
+	return true
+}
+
+// LogMismatches logs the mismatches found in a block, if any, and whether it was re-fetched.
+func LogMismatches(r *Report) {
+	for _, mismatch := range r.Mismatches {
+		log.Printf("Block %d (%s) failed verification, %s\n", r.BlockNumber, r.BlockHash.Hex(), mismatch)
+	}
+	if r.Refetched {
+		log.Printf("Block %d re-fetched from the node\n", r.BlockNumber)
+	}
+}
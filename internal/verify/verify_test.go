@@ -0,0 +1,332 @@
+package verify
+
+import (
+	"ethereum-data-service/internal/model"
+	"ethereum-data-service/pkg/enum"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// testBlock returns the data of a block of three transactions emitting two events each, every event from its own
+// contract, which commits to its transactions, receipts, logs and withdrawals, along with its receipts.
+func testBlock() (*model.Data, []*types.Receipt) {
+	header := &types.Header{
+		ParentHash: common.HexToHash("0x01"),
+		Number:     big.NewInt(42),
+		Difficulty: big.NewInt(0),
+		GasLimit:   30_000_000,
+		Time:       1_700_000_000,
+		BaseFee:    big.NewInt(7),
+	}
+	body := &types.Body{Withdrawals: []*types.Withdrawal{{Index: 1, Validator: 2, Address: common.HexToAddress("0x03"), Amount: 4}}}
+
+	var receipts []*types.Receipt
+	var index uint
+	for i := 0; i < 3; i++ {
+		to := common.BigToAddress(big.NewInt(int64(i + 1)))
+		tx := types.NewTx(&types.LegacyTx{Nonce: uint64(i), GasPrice: big.NewInt(1e9), Gas: 50000, To: &to, Value: big.NewInt(1)})
+		body.Transactions = append(body.Transactions, tx)
+
+		receipt := &types.Receipt{Type: tx.Type(), Status: types.ReceiptStatusSuccessful, CumulativeGasUsed: uint64(i+1) * 50000, TxHash: tx.Hash(), TransactionIndex: uint(i)}
+		for j := 0; j < 2; j++ {
+			receipt.Logs = append(receipt.Logs, &types.Log{
+				Address: common.BigToAddress(big.NewInt(int64(100 + index))),
+				Topics:  []common.Hash{common.BigToHash(big.NewInt(int64(200 + index)))},
+				Data:    []byte{byte(index)},
+				TxHash:  tx.Hash(),
+				TxIndex: uint(i),
+				Index:   index,
+			})
+			index++
+		}
+		receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
+		receipts = append(receipts, receipt)
+	}
+
+	block := types.NewBlock(header, body, receipts, trie.NewStackTrie(nil))
+	data := &model.Data{
+		Block:             model.Block{Header: block.Header(), Body: block.Body()},
+		TransactionHashes: make(map[string]*types.Transaction),
+		Events:            make(map[string][]*types.Log),
+	}
+	for i, tx := range block.Transactions() {
+		data.TransactionHashes[tx.Hash().Hex()] = tx
+		for _, log := range receipts[i].Logs {
+			log.BlockHash, log.BlockNumber = block.Hash(), block.NumberU64()
+			event := *log
+			data.Events[tx.Hash().Hex()] = append(data.Events[tx.Hash().Hex()], &event)
+		}
+	}
+
+	return data, receipts
+}
+
+// txHash returns the hash of the i-th transaction of the block.
+func txHash(data *model.Data, i int) string {
+	return data.Block.Body.Transactions[i].Hash().Hex()
+}
+
+// dropEvent removes the j-th event of the i-th transaction of the block, as an allowlist would.
+func dropEvent(data *model.Data, i, j int) {
+	events := data.Events[txHash(data, i)]
+	data.Events[txHash(data, i)] = append(events[:j:j], events[j+1:]...)
+}
+
+// fields returns the sorted fields of the mismatches, without the transaction hash the events ones name.
+func fields(mismatches []Mismatch) []string {
+	fields := make([]string, 0, len(mismatches))
+	for _, m := range mismatches {
+		field, _, _ := strings.Cut(m.Field, " 0x")
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func TestBlock(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		edit func(data *model.Data)
+		want []string
+	}{
+		{
+			name: "intact",
+			edit: func(data *model.Data) {},
+			want: []string{},
+		},
+		{
+			name: "tampered transaction",
+			edit: func(data *model.Data) {
+				to := common.HexToAddress("0xbad")
+				data.Block.Body.Transactions[0] = types.NewTx(&types.LegacyTx{Nonce: 9, GasPrice: big.NewInt(1e9), Gas: 50000, To: &to})
+			},
+			// The events of the replaced transaction no longer belong to a transaction of the block either
+			want: []string{"events", "transactions root"},
+		},
+		{
+			name: "missing transaction",
+			edit: func(data *model.Data) {
+				data.Block.Body.Transactions = data.Block.Body.Transactions[:2]
+			},
+			want: []string{"events", "transactions root"},
+		},
+		{
+			name: "missing first event",
+			edit: func(data *model.Data) { dropEvent(data, 0, 0) },
+			want: []string{"log indexes", "logs bloom"},
+		},
+		{
+			name: "missing last event",
+			edit: func(data *model.Data) { dropEvent(data, 2, 1) },
+			want: []string{"logs bloom"},
+		},
+		{
+			name: "renumbered event",
+			edit: func(data *model.Data) { data.Events[txHash(data, 1)][0].Index = 7 },
+			want: []string{"log indexes"},
+		},
+		{
+			name: "event of another block",
+			edit: func(data *model.Data) { data.Events[txHash(data, 1)][1].BlockHash = common.HexToHash("0xbad") },
+			want: []string{"event block hash"},
+		},
+		{
+			name: "tampered uncles",
+			edit: func(data *model.Data) {
+				data.Block.Body.Uncles = append(data.Block.Body.Uncles, &types.Header{Number: big.NewInt(41)})
+			},
+			want: []string{"uncles hash"},
+		},
+		{
+			name: "tampered withdrawal",
+			edit: func(data *model.Data) { data.Block.Body.Withdrawals[0].Amount++ },
+			want: []string{"withdrawals root"},
+		},
+		{
+			name: "missing body",
+			edit: func(data *model.Data) {
+				data.Block.Body = nil
+				data.Events = map[string][]*types.Log{}
+			},
+			want: []string{"logs bloom", "transactions root", "withdrawals root"},
+		},
+		{
+			name: "filtered block",
+			edit: func(data *model.Data) {
+				// The allowlists left the first transaction and an event of the second one out
+				data.Block.Filtered = true
+				delete(data.Events, txHash(data, 0))
+				data.Block.Body.Transactions = data.Block.Body.Transactions[1:]
+				dropEvent(data, 0, 0)
+			},
+			want: []string{},
+		},
+		{
+			name: "filtered block with an event of another block",
+			edit: func(data *model.Data) {
+				data.Block.Filtered = true
+				dropEvent(data, 0, 0)
+				data.Events[txHash(data, 1)][1].BlockHash = common.HexToHash("0xbad")
+			},
+			want: []string{"event block hash"},
+		},
+		{
+			name: "headers block",
+			edit: func(data *model.Data) {
+				data.Block.Detail = enum.HEADERS
+				data.Block.Body = nil
+				data.Events = map[string][]*types.Log{}
+			},
+			want: []string{},
+		},
+		{
+			name: "tx_hashes block",
+			edit: func(data *model.Data) {
+				data.Block.Detail = enum.TX_HASHES
+				for _, tx := range data.Block.Body.Transactions {
+					data.Block.TxHashes = append(data.Block.TxHashes, tx.Hash())
+				}
+				data.Block.Body = nil
+				data.Events = map[string][]*types.Log{}
+			},
+			want: []string{},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			data, _ := testBlock()
+			test.edit(data)
+			if got := fields(Block(data)); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Block() mismatches = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReceipts(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		edit func(data *model.Data, receipts []*types.Receipt)
+		want []string
+	}{
+		{
+			name: "intact",
+			edit: func(data *model.Data, receipts []*types.Receipt) {},
+			want: []string{},
+		},
+		{
+			name: "tampered receipt",
+			edit: func(data *model.Data, receipts []*types.Receipt) {
+				receipts[1].Status = types.ReceiptStatusFailed
+			},
+			want: []string{"receipts root"},
+		},
+		{
+			name: "missing event",
+			edit: func(data *model.Data, receipts []*types.Receipt) { dropEvent(data, 1, 0) },
+			want: []string{"events of transaction"},
+		},
+		{
+			name: "tampered event",
+			edit: func(data *model.Data, receipts []*types.Receipt) {
+				data.Events[txHash(data, 2)][1].Data = []byte("bad")
+			},
+			want: []string{"events of transaction"},
+		},
+		{
+			name: "reordered events",
+			edit: func(data *model.Data, receipts []*types.Receipt) {
+				events := data.Events[txHash(data, 0)]
+				events[0], events[1] = events[1], events[0]
+			},
+			want: []string{"events of transaction"},
+		},
+		{
+			name: "filtered block missing events",
+			edit: func(data *model.Data, receipts []*types.Receipt) {
+				data.Block.Filtered = true
+				dropEvent(data, 0, 0)
+				delete(data.Events, txHash(data, 2))
+			},
+			want: []string{},
+		},
+		{
+			name: "filtered block with a tampered event",
+			edit: func(data *model.Data, receipts []*types.Receipt) {
+				data.Block.Filtered = true
+				dropEvent(data, 0, 0)
+				data.Events[txHash(data, 0)][0].Topics = nil
+			},
+			want: []string{"events of transaction"},
+		},
+		{
+			name: "filtered block with reordered events",
+			edit: func(data *model.Data, receipts []*types.Receipt) {
+				data.Block.Filtered = true
+				events := data.Events[txHash(data, 0)]
+				events[0], events[1] = events[1], events[0]
+			},
+			want: []string{"events of transaction"},
+		},
+		{
+			name: "headers block",
+			edit: func(data *model.Data, receipts []*types.Receipt) {
+				data.Block.Detail = enum.HEADERS
+				data.Block.Body = nil
+				data.Events = map[string][]*types.Log{}
+			},
+			want: []string{},
+		},
+		{
+			name: "headers block with a tampered receipt",
+			edit: func(data *model.Data, receipts []*types.Receipt) {
+				data.Block.Detail = enum.HEADERS
+				data.Block.Body = nil
+				data.Events = map[string][]*types.Log{}
+				receipts[0].CumulativeGasUsed++
+			},
+			want: []string{"receipts root"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			data, receipts := testBlock()
+			test.edit(data, receipts)
+			if got := fields(Receipts(data, receipts)); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Receipts() mismatches = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSameLogsAmongLogs(t *testing.T) {
+	_, receipts := testBlock()
+	logs := append(receipts[0].Logs, receipts[1].Logs...)
+
+	for _, test := range []struct {
+		name        string
+		events      []*types.Log
+		same, among bool
+	}{
+		{name: "all logs", events: logs, same: true, among: true},
+		{name: "no logs", events: nil, same: false, among: true},
+		{name: "some logs in order", events: []*types.Log{logs[0], logs[2]}, same: false, among: true},
+		{name: "logs out of order", events: []*types.Log{logs[2], logs[0]}, same: false, among: false},
+		{name: "another log", events: []*types.Log{logs[0], {Address: logs[1].Address, Index: logs[1].Index}}, same: false, among: false},
+		{name: "a log twice", events: []*types.Log{logs[0], logs[0]}, same: false, among: false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := sameLogs(logs, test.events); got != test.same {
+				t.Errorf("sameLogs() = %v, want %v", got, test.same)
+			}
+			if got := amongLogs(logs, test.events); got != test.among {
+				t.Errorf("amongLogs() = %v, want %v", got, test.among)
+			}
+		})
+	}
+}
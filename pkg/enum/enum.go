@@ -7,3 +7,76 @@ const (
 	HTTPS Protocol = "https"
 	WSS   Protocol = "wss"
 )
+
+// Transport represents the supported message transports between the notifier and the subscribers
+type Transport string
+
+const (
+	REDIS_PUBSUB  Transport = "redis-pubsub"
+	REDIS_STREAMS Transport = "redis-streams"
+	NATS          Transport = "nats"
+	MEMORY        Transport = "memory"
+)
+
+// RedisMode represents how the Redis deployment is reached
+type RedisMode string
+
+const (
+	STANDALONE RedisMode = "standalone"
+	SENTINEL   RedisMode = "sentinel"
+	CLUSTER    RedisMode = "cluster"
+)
+
+// PartitionMode represents how subscribers split the blocks among themselves
+type PartitionMode string
+
+const (
+	GROUP  PartitionMode = "group"
+	MODULO PartitionMode = "modulo"
+)
+
+// StorageBackend represents the supported block data stores
+type StorageBackend string
+
+const (
+	REDIS     StorageBackend = "redis"
+	POSTGRES  StorageBackend = "postgres"
+	BOLT      StorageBackend = "bolt"
+	IN_MEMORY StorageBackend = "memory"
+)
+
+// BlockTag represents the named blocks accepted in place of a block number, resolved against the stored window
+type BlockTag string
+
+const (
+	LATEST    BlockTag = "latest"
+	EARLIEST  BlockTag = "earliest"
+	SAFE      BlockTag = "safe"
+	FINALIZED BlockTag = "finalized"
+)
+
+// ArchiveFormat represents the supported file formats of the block archive
+type ArchiveFormat string
+
+const (
+	NDJSON  ArchiveFormat = "ndjson"
+	PARQUET ArchiveFormat = "parquet"
+)
+
+// SnapshotExpiry represents how the blocks of an imported snapshot expire
+type SnapshotExpiry string
+
+const (
+	ORIGINAL SnapshotExpiry = "original"
+	REFRESH  SnapshotExpiry = "refresh"
+)
+
+// DetailLevel represents how much of every block is ingested, from the least to the most detailed
+type DetailLevel string
+
+const (
+	HEADERS   DetailLevel = "headers"
+	TX_HASHES DetailLevel = "tx_hashes"
+	FULL      DetailLevel = "full"
+	TRACES    DetailLevel = "traces"
+)
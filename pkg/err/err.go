@@ -6,10 +6,31 @@ import (
 )
 
 var (
-	ErrEnvFileMissing  = errors.New("environment config variable missing")
-	ErrInvalidProtocol = errors.New("invalid protocol specified")
+	ErrEnvFileMissing   = errors.New("environment config variable missing")
+	ErrInvalidProtocol  = errors.New("invalid protocol specified")
+	ErrInvalidTransport = errors.New("invalid transport specified")
+	ErrMemoryTransport  = errors.New("the memory transport only connects the services of a single process, run them with `all`")
+	ErrMemoryStorage    = errors.New("the memory storage backend only lives within a single process, run the services with `all`")
+	ErrInvalidRedisMode = errors.New("invalid redis mode specified")
+
+	ErrInvalidPartitionMode  = errors.New("invalid partition mode specified")
+	ErrPubSubGroupPartition  = errors.New("the redis-pubsub transport broadcasts every block to all subscribers, use the modulo partition mode")
+	ErrInvalidStorageBackend = errors.New("invalid storage backend specified")
+	ErrInvalidArchiveFormat  = errors.New("invalid archive format specified")
+	ErrInvalidSnapshotExpiry = errors.New("invalid snapshot expiry specified")
+	ErrInvalidDetailLevel    = errors.New("invalid detail level specified")
+
+	ErrNotFound           = errors.New("not found")
+	ErrIncompatibleSchema = errors.New("incompatible storage schema version")
+	ErrInvalidSnapshot    = errors.New("invalid snapshot")
+	ErrStoreNotEmpty      = errors.New("store not empty")
+	ErrNotIngested        = errors.New("not ingested at the configured detail")
 )
 
 func ConfigKeyMissingError(key string) error {
 	return fmt.Errorf("specified key:%s missing from config", key)
 }
+
+func ConfigValueTooSmallError(key string, min int) error {
+	return fmt.Errorf("specified key:%s must be at least %d", key, min)
+}
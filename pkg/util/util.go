@@ -4,6 +4,7 @@ import (
 	"context"
 	eth_err "ethereum-data-service/pkg/err"
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -27,7 +28,34 @@ func GetEnvMap(keys []string) (map[string]string, error) {
 	return envMap, nil
 }
 
+// GetEnvOrDefault returns the value of an optional environment variable, falling back to def when it is unset or empty.
+func GetEnvOrDefault(key, def string) string {
+	if value, exists := os.LookupEnv(key); exists && value != "" {
+		return value
+	}
+	return def
+}
+
 func HandleGracefulShutdown(cancel context.CancelFunc, shutdown chan struct{}) {
 	<-shutdown
 	cancel()
 }
+
+// Retry calls fn until it succeeds, at most attempts times, doubling the delay between two calls starting
+// from baseDelay. It returns the number of calls made along with the last error.
+func Retry(ctx context.Context, attempts int, baseDelay time.Duration, fn func() error) (int, error) {
+	var err error
+	delay := baseDelay
+	for i := 1; ; i++ {
+		if err = fn(); err == nil || i >= attempts {
+			return i, err
+		}
+
+		select {
+		case <-time.After(delay):
+			delay *= 2
+		case <-ctx.Done():
+			return i, err
+		}
+	}
+}